@@ -1,27 +1,39 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 	"os"
 	"pathfinder-api/contracts/dropmanager"
-	"strings"
+	"strconv"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 var client *ethclient.Client
 var dmContract *dropmanager.Dropmanager
+var chainID *big.Int
 
-func initClient()  {
+var indexer *dropIndexer
+
+func initClient(store indexerStore) {
 	c, err := ethclient.Dial(os.Getenv("WS_NODE"))
 	if err != nil {
 		Sugar.Fatal(err)
 	}
-	
+
 	client = c
-	
+
+	id, err := client.ChainID(context.Background())
+	if err != nil {
+		Sugar.Fatal(err)
+	}
+	chainID = id
+
 	ca := common.HexToAddress(os.Getenv("DM_CA"))
 	dm, err := dropmanager.NewDropmanager(ca, client)
 	if err != nil {
@@ -29,63 +41,97 @@ func initClient()  {
 	}
 
 	dmContract = dm
+	indexer = newDropIndexer(dmContract, client, store)
 	Sugar.Info("node client initialized")
 }
 
-func listenForLocks() {
-	sink := make(chan *dropmanager.DropmanagerDropAdded)
-	sub, err := dmContract.WatchDropAdded(nil, sink, nil, nil)
+// chainReader is what the handlers read from the chain: the calls signature
+// checks make and the drops prizes are checked against.
+type chainReader interface {
+	signatureBackend
+	getDropLock(ctx context.Context, id common.Hash) (dropLock, error)
+}
+
+// nodeChain reads through the node client and the DropManager contract.
+type nodeChain struct {
+	*ethclient.Client
+	contract *dropmanager.Dropmanager
+}
+
+// dropLock is a drop as getDropLockById returns it. Drops that were never
+// created, or have been unlocked, come back with a zero sender.
+type dropLock struct {
+	Sender          common.Address
+	HashedPassword  common.Hash
+	PrizeType       string
+	ContractAddress common.Address
+	Amount          *big.Int
+	Expiry          *big.Int
+}
+
+func (c nodeChain) getDropLock(ctx context.Context, id common.Hash) (dropLock, error) {
+	sender, hashedPassword, prizeType, contractAddress, amount, expiry, err := c.contract.GetDropLockById(&bind.CallOpts{Context: ctx}, id)
 	if err != nil {
-		Sugar.Fatal(err)
+		return dropLock{}, err
+	}
+	return dropLock{
+		Sender:          sender,
+		HashedPassword:  hashedPassword,
+		PrizeType:       prizeType,
+		ContractAddress: contractAddress,
+		Amount:          amount,
+		Expiry:          expiry,
+	}, nil
+}
+
+func dropID(id [32]byte) string {
+	return fmt.Sprintf("0x%s", normalizeAddress(common.Bytes2Hex(id[:])))
+}
+
+func envUint(name string, fallback uint64) uint64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
 	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		Sugar.Fatalf("invalid %s: %s", name, err.Error())
+	}
+	return n
+}
 
-	delay := time.Second * 10
-
-	for {
-		select {
-		case err := <-sub.Err():
-			if err != nil {
-				Sugar.Error(err)
-				sub.Unsubscribe()
-				listenForLocks()
-			}
-		case log := <-sink:
-			sender := strings.ToLower(log.Sender.Hex())
-			id := fmt.Sprintf("0x%s",normalizeAddress(common.Bytes2Hex(log.Id[:])))
-			err := updatePrizeLockFields(log.PrizeType, sender, id, true)
-			if err != nil {
-				Sugar.Error(err)
-			}
-		}
-		time.Sleep(delay) // delay so it's not so resource intensive
+func envInt(name string, fallback int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
 	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		Sugar.Fatalf("invalid %s: %s", name, err.Error())
+	}
+	return n
 }
 
-func listenForUnlocks() {
-	sink := make(chan *dropmanager.DropmanagerDropUnlocked)
-	sub, err := dmContract.WatchDropUnlocked(nil, sink, nil, nil, nil)
+func envFloat(name string, fallback float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseFloat(v, 64)
 	if err != nil {
-		Sugar.Fatal(err)
+		Sugar.Fatalf("invalid %s: %s", name, err.Error())
 	}
+	return n
+}
 
-	delay := time.Second * 10
-
-	for {
-		select {
-		case err := <-sub.Err():
-			if err != nil {
-				Sugar.Error(err)
-				sub.Unsubscribe()
-				listenForLocks()
-			}
-		case log := <-sink:
-			sender := strings.ToLower(log.Sender.Hex())
-			id := fmt.Sprintf("0x%s",normalizeAddress(common.Bytes2Hex(log.Id[:])))
-			err := updatePrizeLockFields(log.PrizeType, sender, id, false)
-			if err != nil {
-				Sugar.Error(err)
-			}
-		}
-		time.Sleep(delay) // delay so it's not so resource intensive
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
 	}
-}
\ No newline at end of file
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		Sugar.Fatalf("invalid %s: %s", name, err.Error())
+	}
+	return d
+}
@@ -2,21 +2,32 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 )
 
-var db *sql.DB
+// postgresStore is the Store the API runs on.
+type postgresStore struct {
+    db *sql.DB
+    // postGIS is set at startup when the postgis indexes are there, in
+    // which case radius searches go through ST_DWithin instead of grid cells.
+    postGIS bool
+}
+
+// ST_DWithin on a sphere uses a slightly larger earth radius than haversine,
+// so the radius handed to it is padded and haversine has the final say.
+const postgisRadiusSlack = 1.001
 
-func initDB() {
-    var err error
+func connectPostgres() *sql.DB {
     connStr := fmt.Sprintf("host=%s user=%s dbname=%s sslmode=%s password=%s", os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_NAME"), os.Getenv("DB_SSL_MODE"), os.Getenv("DB_PASSWORD"))
-    db, err = sql.Open("postgres", connStr)
+    db, err := sql.Open("postgres", connStr)
     if err != nil {
         Sugar.Fatalf("DB ERROR: %s", err.Error())
     }
@@ -24,45 +35,119 @@ func initDB() {
     if err = db.Ping(); err != nil {
         Sugar.Fatalf("DB ERROR: %s", err.Error())
     }
+    return db
+}
 
-    initQuery := `
-    CREATE TABLE IF NOT EXISTS messages (
-        id SERIAL PRIMARY KEY,
-        sender TEXT,
-        text SMALLINT[],
-        latitude FLOAT8,
-        longitude FLOAT8,
-        expires BIGINT,
-        active BOOLEAN
-    );
-
-    CREATE TABLE IF NOT EXISTS prizes (
-        id TEXT PRIMARY KEY,
-        sender TEXT,
-        latitude DOUBLE PRECISION,
-        longitude DOUBLE PRECISION,
-        password TEXT,
-        hashed_password TEXT,
-        type TEXT,
-        contract_address TEXT,
-        name TEXT,
-        symbol TEXT,
-        amount NUMERIC,
-        expires BIGINT,
-        active BOOLEAN
-    );
-    `
+// openPostgresStore connects and brings the schema up to date, unless
+// MIGRATE_ON_START=false, when it has to be migrated already. Either way it
+// won't start on a schema newer than it knows.
+func openPostgresStore() *postgresStore {
+    db := connectPostgres()
 
-    _, err = db.Exec(initQuery)
+    if err := postgresSchema.prepare(db); err != nil {
+        Sugar.Fatalf("DB ERROR: %s", err.Error())
+    }
+
+    s := &postgresStore{db: db}
+    if err := s.backfillCells("prizes"); err != nil {
+        Sugar.Error(err)
+    }
+    if err := s.backfillCells("messages"); err != nil {
+        Sugar.Error(err)
+    }
+
+    s.initSpatialIndex()
+    return s
+}
+
+func (s *postgresStore) close() error {
+    return s.db.Close()
+}
+
+// initSpatialIndex switches radius searches over to PostGIS when migration
+// 0013 has built its indexes, which it does if the extension was installed
+// by then. SPATIAL_INDEX=grid forces the grid cell path.
+func (s *postgresStore) initSpatialIndex() {
+    if os.Getenv("SPATIAL_INDEX") == "grid" {
+        return
+    }
+
+    err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis')
+        AND to_regclass('messages_geog_idx') IS NOT NULL
+        AND to_regclass('prizes_geog_idx') IS NOT NULL`).Scan(&s.postGIS)
     if err != nil {
         Sugar.Error(err)
+        return
+    }
+    if !s.postGIS {
+        return
     }
+    Sugar.Info("using postgis for radius searches")
 }
 
-func upsertPrizeLockToDB(prize Prize) error {
+// backfillCells fills in the grid cell for rows written before the column existed.
+func (s *postgresStore) backfillCells(table string) error {
+    rows, err := s.db.Query(fmt.Sprintf(`SELECT %s, latitude, longitude FROM %s WHERE cell IS NULL`, idColumn(table), table))
+    if err != nil {
+        return err
+    }
+
+    type pending struct {
+        id   string
+        cell int64
+    }
+    var updates []pending
+    for rows.Next() {
+        var p pending
+        var lat, lon float64
+        if err := rows.Scan(&p.id, &lat, &lon); err != nil {
+            rows.Close()
+            return err
+        }
+        p.cell = cellFor(lat, lon)
+        updates = append(updates, p)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, p := range updates {
+        if _, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET cell = $1 WHERE %s = $2`, table, idColumn(table)), p.cell, p.id); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func idColumn(table string) string {
+    if table == "messages" {
+        return "id::text"
+    }
+    return "id"
+}
+
+// radiusFilter returns the WHERE fragment that narrows a radius search down
+// to candidate rows, numbering its placeholders from $firstArg. It is always
+// a superset of the haversine result, which callers still apply.
+func (s *postgresStore) radiusFilter(lat, lon, radius float64, firstArg int) (string, []interface{}) {
+    if s.postGIS {
+        return fmt.Sprintf(`ST_DWithin(geography(ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)), geography(ST_SetSRID(ST_MakePoint($%d, $%d), 4326)), $%d, false)`,
+            firstArg, firstArg+1, firstArg+2), []interface{}{lon, lat, radius * 1000 * postgisRadiusSlack}
+    }
+
+    area := searchAreaFor(lat, lon, radius)
+    if area.Cells == nil {
+        return fmt.Sprintf(`latitude BETWEEN $%d AND $%d`, firstArg, firstArg+1), []interface{}{area.MinLat, area.MaxLat}
+    }
+    return fmt.Sprintf(`latitude BETWEEN $%d AND $%d AND cell = ANY($%d)`, firstArg, firstArg+1, firstArg+2),
+        []interface{}{area.MinLat, area.MaxLat, pq.Array(area.Cells)}
+}
+
+func (s *postgresStore) upsertPrizeLock(prize Prize) error {
     query := `
-    INSERT INTO prizes (id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+    INSERT INTO prizes (id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active, cell)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
     ON CONFLICT (id) 
     DO UPDATE SET
         sender = EXCLUDED.sender,
@@ -76,30 +161,383 @@ func upsertPrizeLockToDB(prize Prize) error {
         symbol = EXCLUDED.symbol,
         amount = EXCLUDED.amount,
         expires = EXCLUDED.expires,
-        active = EXCLUDED.active
+        active = prizes.active OR EXCLUDED.active,
+        cell = EXCLUDED.cell
     `
     amountStr := prize.Amount.String()
-    _, err := db.Exec(query, prize.ID, prize.Sender, prize.Latitude, prize.Longitude, prize.Password, prize.HashedPassword,
-        prize.Type, prize.ContractAddress, prize.Name, prize.Symbol, amountStr, prize.Expires, prize.Active)
+    _, err := s.db.Exec(query, prize.ID, prize.Sender, prize.Latitude, prize.Longitude, prize.Password, prize.HashedPassword,
+        prize.Type, prize.ContractAddress, prize.Name, prize.Symbol, amountStr, prize.Expires, prize.Active, cellFor(prize.Latitude, prize.Longitude))
+    return err
+}
+
+// getStoredPasswords returns every prize's stored password by prize id.
+func (s *postgresStore) getStoredPasswords() (map[string]string, error) {
+    rows, err := s.db.Query(`SELECT id, password FROM prizes WHERE password IS NOT NULL AND password <> ''`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    passwords := map[string]string{}
+    for rows.Next() {
+        var id, password string
+        if err := rows.Scan(&id, &password); err != nil {
+            return nil, err
+        }
+        passwords[id] = password
+    }
+    return passwords, rows.Err()
+}
+
+// replaceStoredPassword swaps a prize's stored password, unless it has
+// changed since it was read.
+func (s *postgresStore) replaceStoredPassword(id, old, new string) (bool, error) {
+    res, err := s.db.Exec(`UPDATE prizes SET password = $3 WHERE id = $1 AND password = $2`, id, old, new)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+func (s *postgresStore) getPrizeLockByID(id string) (Prize, bool, error) {
+    query := `
+    SELECT id, sender, latitude, longitude, active
+    FROM prizes
+    WHERE id = $1
+    `
+    var prize Prize
+    err := s.db.QueryRow(query, id).Scan(&prize.ID, &prize.Sender, &prize.Latitude, &prize.Longitude, &prize.Active)
+    if err == sql.ErrNoRows {
+        return Prize{}, false, nil
+    }
+    if err != nil {
+        return Prize{}, false, err
+    }
+    return prize, true, nil
+}
+
+// prizeColumns are the columns scanPrize reads, in order. The last is
+// whether the prize is hidden by moderation, the inverse of moderated; it's
+// written to run on both Postgres and SQLite.
+const prizeColumns = `id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active, claimed_by,
+    (EXISTS (SELECT 1 FROM moderation WHERE target_type = 'prize' AND target_id = CAST(prizes.id AS TEXT) AND hidden)
+        OR EXISTS (SELECT 1 FROM banned_senders WHERE address = prizes.sender))`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanPrize(row rowScanner) (Prize, error) {
+    var prize Prize
+    var amountStr string
+    var claimedBy sql.NullString
+    if err := row.Scan(&prize.ID, &prize.Sender, &prize.Latitude, &prize.Longitude, &prize.Password, &prize.HashedPassword,
+        &prize.Type, &prize.ContractAddress, &prize.Name, &prize.Symbol, &amountStr, &prize.Expires, &prize.Active, &claimedBy, &prize.Hidden); err != nil {
+        return Prize{}, err
+    }
+    prize.Amount, _ = new(big.Int).SetString(amountStr, 10)
+    prize.ClaimedBy = claimedBy.String
+    return prize, nil
+}
+
+func scanPrizes(rows *sql.Rows, err error) ([]Prize, error) {
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var prizes []Prize
+    for rows.Next() {
+        prize, err := scanPrize(rows)
+        if err != nil {
+            return nil, err
+        }
+        prizes = append(prizes, prize)
+    }
+    return prizes, rows.Err()
+}
+
+// getPrize returns everything stored about a prize, including who claimed it.
+func (s *postgresStore) getPrize(id string) (Prize, bool, error) {
+    prize, err := scanPrize(s.db.QueryRow(`SELECT `+prizeColumns+` FROM prizes WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return Prize{}, false, nil
+    }
+    if err != nil {
+        return Prize{}, false, err
+    }
+    return prize, true, nil
+}
+
+// getPrizesBySender returns up to limit of sender's prizes with ids after
+// after, in id order, so the last id is the cursor for the next page.
+func (s *postgresStore) getPrizesBySender(sender, after string, limit int) ([]Prize, error) {
+    return scanPrizes(s.db.Query(`SELECT `+prizeColumns+` FROM prizes WHERE sender = $1 AND id > $2 ORDER BY id LIMIT $3`, sender, after, limit))
+}
+
+// getPrizesClaimedBy pages through the prizes claimer has unlocked, like
+// getPrizesBySender.
+func (s *postgresStore) getPrizesClaimedBy(claimer, after string, limit int) ([]Prize, error) {
+    return scanPrizes(s.db.Query(`SELECT `+prizeColumns+` FROM prizes WHERE claimed_by = $1 AND id > $2 ORDER BY id LIMIT $3`, claimer, after, limit))
+}
+
+// updatePrizeLockFields applies the state from a DropAdded/DropUnlocked log.
+// The log position is stored with the prize and older logs are ignored, so
+// events can be replayed or arrive out of order without clobbering newer state.
+// A row only activates if its sender and hashed password are the log's, so a
+// row posted ahead of the drop by someone else never goes live.
+func (s *postgresStore) updatePrizeLockFields(pType, sender, hashedPassword, id string, active bool, claimedBy string, block uint64, index uint) error {
+    query := `
+    UPDATE prizes
+    SET type = $1, sender = $2, active = $3, claimed_by = NULLIF($7, ''), event_block = $5, event_index = $6
+    WHERE id = $4 AND (event_block IS NULL OR (event_block, event_index) < ($5, $6))
+        AND (NOT $3 OR (sender = $2 AND LOWER(hashed_password) = $8))
+    `
+    _, err := s.db.Exec(query, pType, sender, active, id, block, index, claimedBy, hashedPassword)
     return err
 }
 
-func updatePrizeLockFields(pType, sender, id string, active bool) error {
+// revertPrizeLockFields undoes a log that a reorg removed, provided it is
+// still the latest log applied to the prize.
+func (s *postgresStore) revertPrizeLockFields(id string, active bool, block uint64, index uint) error {
     query := `
     UPDATE prizes
-    SET type = $1, sender = $2, active = $3
-    WHERE id = $4
+    SET active = $2, claimed_by = NULL, event_block = NULL, event_index = NULL
+    WHERE id = $1 AND event_block = $3 AND event_index = $4
     `
-    _, err := db.Exec(query, pType, sender, active, id)
+    _, err := s.db.Exec(query, id, active, block, index)
     return err
 }
 
-func getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error) {
-    rows, err := db.Query(`
+func (s *postgresStore) getIndexedBlock(name string) (uint64, bool, error) {
+    var block uint64
+    err := s.db.QueryRow(`SELECT block FROM indexer_state WHERE name = $1`, name).Scan(&block)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return block, true, nil
+}
+
+// setIndexedBlock moves the cursor forward; it never moves backwards.
+func (s *postgresStore) setIndexedBlock(name string, block uint64) error {
+    query := `
+    INSERT INTO indexer_state (name, block)
+    VALUES ($1, $2)
+    ON CONFLICT (name)
+    DO UPDATE SET block = GREATEST(indexer_state.block, EXCLUDED.block)
+    `
+    _, err := s.db.Exec(query, name, block)
+    return err
+}
+
+// insertDropLog records a drop event. A log replayed at the same position
+// overwrites what was there, which only differs if the block was reorged
+// out while nothing was watching.
+func (s *postgresStore) insertDropLog(log DropLog) error {
+    query := `
+    INSERT INTO drop_events (block_number, log_index, drop_id, event, tx_hash, sender, receiver, block_time)
+    VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+    ON CONFLICT (block_number, log_index)
+    DO UPDATE SET
+        drop_id = EXCLUDED.drop_id,
+        event = EXCLUDED.event,
+        tx_hash = EXCLUDED.tx_hash,
+        sender = EXCLUDED.sender,
+        receiver = EXCLUDED.receiver,
+        block_time = EXCLUDED.block_time
+    `
+    _, err := s.db.Exec(query, log.Block, log.LogIndex, log.DropID, log.Event, log.TxHash, log.Sender, log.Receiver, log.Timestamp)
+    return err
+}
+
+// deleteDropLog forgets a drop event a reorg removed.
+func (s *postgresStore) deleteDropLog(block uint64, index uint, txHash string) error {
+    _, err := s.db.Exec(`DELETE FROM drop_events WHERE block_number = $1 AND log_index = $2 AND tx_hash = $3`, block, index, txHash)
+    return err
+}
+
+// getDropLogs returns a drop's history in chain order.
+func (s *postgresStore) getDropLogs(dropID string) ([]DropLog, error) {
+    return scanDropLogs(s.db.Query(`
+        SELECT drop_id, event, sender, receiver, block_number, tx_hash, log_index, block_time
+        FROM drop_events
+        WHERE drop_id = $1
+        ORDER BY block_number, log_index`, dropID))
+}
+
+func scanDropLogs(rows *sql.Rows, err error) ([]DropLog, error) {
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var logs []DropLog
+    for rows.Next() {
+        var log DropLog
+        var receiver sql.NullString
+        if err := rows.Scan(&log.DropID, &log.Event, &log.Sender, &receiver, &log.Block, &log.TxHash, &log.LogIndex, &log.Timestamp); err != nil {
+            return nil, err
+        }
+        log.Receiver = receiver.String
+        logs = append(logs, log)
+    }
+    return logs, rows.Err()
+}
+
+const movementTrackColumns = `last_latitude, last_longitude, last_at, anchor_latitude, anchor_longitude, anchor_at`
+
+// scanMovementTrack reads movementTrackColumns; times are unix nanoseconds.
+func scanMovementTrack(row rowScanner) (movementTrack, error) {
+    var track movementTrack
+    var lastAt, anchorAt int64
+    if err := row.Scan(&track.last.Latitude, &track.last.Longitude, &lastAt, &track.anchor.Latitude, &track.anchor.Longitude, &anchorAt); err != nil {
+        return movementTrack{}, err
+    }
+    track.last.At, track.anchor.At = time.Unix(0, lastAt), time.Unix(0, anchorAt)
+    return track, nil
+}
+
+func (s *postgresStore) getMovementTrack(key string) (movementTrack, bool, error) {
+    track, err := scanMovementTrack(s.db.QueryRow(`SELECT `+movementTrackColumns+` FROM movement_tracks WHERE key = $1`, key))
+    if err == sql.ErrNoRows {
+        return movementTrack{}, false, nil
+    }
+    if err != nil {
+        return movementTrack{}, false, err
+    }
+    return track, true, nil
+}
+
+// saveMovementTrack writes key's track, provided nobody else has since prev.
+func (s *postgresStore) saveMovementTrack(key string, track movementTrack, prev *time.Time) (bool, error) {
+    args := []interface{}{key, track.last.Latitude, track.last.Longitude, track.last.At.UnixNano(),
+        track.anchor.Latitude, track.anchor.Longitude, track.anchor.At.UnixNano()}
+    if prev == nil {
+        return rowsAffectedOne(s.db.Exec(`
+            INSERT INTO movement_tracks (key, `+movementTrackColumns+`)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            ON CONFLICT (key) DO NOTHING`, args...))
+    }
+    return rowsAffectedOne(s.db.Exec(`
+        UPDATE movement_tracks
+        SET last_latitude = $2, last_longitude = $3, last_at = $4, anchor_latitude = $5, anchor_longitude = $6, anchor_at = $7
+        WHERE key = $1 AND last_at = $8`, append(args, prev.UnixNano())...))
+}
+
+func (s *postgresStore) deleteMovementTracks(before time.Time) error {
+    _, err := s.db.Exec(`DELETE FROM movement_tracks WHERE last_at < $1`, before.UnixNano())
+    return err
+}
+
+// insertSignatureNonce records a nonce issued to sender, clearing out expired
+// ones as it goes; once expired they can't be used so there's nothing to keep.
+func (s *postgresStore) insertSignatureNonce(sender, nonce string, expires int64) error {
+    if _, err := s.db.Exec(`DELETE FROM signature_nonces WHERE expires < $1`, time.Now().Unix()); err != nil {
+        return err
+    }
+
+    _, err := s.db.Exec(`INSERT INTO signature_nonces (nonce, sender, expires) VALUES ($1, $2, $3)`, normalizeAddress(nonce), sender, expires)
+    return err
+}
+
+// useSignatureNonce marks a nonce used, returning false if it wasn't issued to
+// sender, has expired or has already been used.
+func (s *postgresStore) useSignatureNonce(sender, nonce string) (bool, error) {
+    query := `
+    UPDATE signature_nonces SET used = TRUE
+    WHERE nonce = $1 AND sender = $2 AND used = FALSE AND expires >= $3
+    `
+    res, err := s.db.Exec(query, normalizeAddress(nonce), sender, time.Now().Unix())
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+// insertLoginNonce records a sign in nonce, clearing out expired ones.
+func (s *postgresStore) insertLoginNonce(nonce string, expires int64) error {
+    if _, err := s.db.Exec(`DELETE FROM login_nonces WHERE expires < $1`, time.Now().Unix()); err != nil {
+        return err
+    }
+
+    _, err := s.db.Exec(`INSERT INTO login_nonces (nonce, expires) VALUES ($1, $2)`, nonce, expires)
+    return err
+}
+
+// useLoginNonce marks a sign in nonce used, returning false if it was never
+// issued, has expired or has already been used.
+func (s *postgresStore) useLoginNonce(nonce string) (bool, error) {
+    res, err := s.db.Exec(`UPDATE login_nonces SET used = TRUE WHERE nonce = $1 AND used = FALSE AND expires >= $2`, nonce, time.Now().Unix())
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+// takeRateLimitToken refills key's bucket for the time since it was last
+// touched and spends a token if there's one, in a single statement so replicas
+// racing on the same bucket can't both spend the last token. A new bucket
+// starts full. It returns the tokens left and whether one was spent; times
+// are unix seconds.
+func (s *postgresStore) takeRateLimitToken(key string, rate, burst float64, now time.Time) (float64, bool, error) {
+    query := `
+    INSERT INTO rate_limits AS r (key, tokens, updated, allowed) VALUES ($1, $3 - 1, $4, TRUE)
+    ON CONFLICT (key) DO UPDATE SET
+        tokens = CASE WHEN LEAST($3, r.tokens + GREATEST($4 - r.updated, 0) * $2) >= 1
+            THEN LEAST($3, r.tokens + GREATEST($4 - r.updated, 0) * $2) - 1
+            ELSE LEAST($3, r.tokens + GREATEST($4 - r.updated, 0) * $2)
+        END,
+        allowed = LEAST($3, r.tokens + GREATEST($4 - r.updated, 0) * $2) >= 1,
+        updated = GREATEST($4, r.updated)
+    RETURNING tokens, allowed
+    `
+    var tokens float64
+    var allowed bool
+    at := float64(now.UnixNano()) / float64(time.Second)
+    err := s.db.QueryRow(query, key, rate, burst, at).Scan(&tokens, &allowed)
+    return tokens, allowed, err
+}
+
+// deleteIdleRateLimits drops buckets untouched since before, which have long
+// refilled and are no different from a missing one.
+func (s *postgresStore) deleteIdleRateLimits(before time.Time) error {
+    _, err := s.db.Exec(`DELETE FROM rate_limits WHERE updated < $1`, float64(before.Unix()))
+    return err
+}
+
+// moderated leaves out rows of table that have been hidden or whose sender
+// is banned.
+func moderated(table, targetType string) string {
+    return fmt.Sprintf(`
+        NOT EXISTS (SELECT 1 FROM moderation WHERE target_type = '%[2]s' AND target_id = %[1]s.id::text AND hidden)
+        AND NOT EXISTS (SELECT 1 FROM banned_senders WHERE address = %[1]s.sender)`, table, targetType)
+}
+
+func (s *postgresStore) getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error) {
+    filter, args := s.radiusFilter(lat, lon, radius, 2)
+    rows, err := s.db.Query(`
         SELECT id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active
         FROM prizes
-        WHERE active = TRUE AND expires > $1
-    `, time.Now().Unix())
+        WHERE active = TRUE AND expires > $1 AND `+moderated("prizes", "prize")+` AND `+filter,
+        append([]interface{}{time.Now().Unix()}, args...)...)
     if err != nil {
         return nil, err
     }
@@ -126,18 +564,17 @@ func getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error) {
     return prizes, nil
 }
 
-func insertMessageToDB(msg Message) (int64, error) {
+func (s *postgresStore) insertMessage(msg Message) (int64, error) {
     msg.Active = true
-    msg.Expires = time.Now().Unix() + 86400
 
     query := `
-    INSERT INTO messages (sender, text, latitude, longitude, expires, active)
-    VALUES ($1, $2, $3, $4, $5, $6)
+    INSERT INTO messages (sender, text, latitude, longitude, expires, active, cell, template_version)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
     RETURNING id
     `
 
     var id int64
-    err := db.QueryRow(query, msg.Sender, pq.Array(msg.Text), msg.Latitude, msg.Longitude, msg.Expires, msg.Active).Scan(&id)
+    err := s.db.QueryRow(query, msg.Sender, pq.Array(msg.Text), msg.Latitude, msg.Longitude, msg.Expires, msg.Active, cellFor(msg.Latitude, msg.Longitude), msg.TemplateVersion).Scan(&id)
     if err != nil {
         return 0, err
     }
@@ -147,12 +584,57 @@ func insertMessageToDB(msg Message) (int64, error) {
     return id, nil
 }
 
-func getMessagesWithinRadius(lat, lon, radius float64) ([]Message, error) {
-    rows, err := db.Query(`
-        SELECT id, sender, text, latitude, longitude, expires, active
-        FROM messages
-        WHERE active = TRUE AND expires > $1
-    `, time.Now().Unix())
+// editMessage replaces the text of one of sender's live messages, written
+// with catalogue version, returning false if there's no such message.
+func (s *postgresStore) editMessage(id int64, sender string, text []int16, version int) (bool, error) {
+    query := `
+    UPDATE messages SET text = $3, template_version = $4
+    WHERE id = $1 AND sender = $2 AND active = TRUE AND expires > $5 AND deleted_at IS NULL
+    `
+    res, err := s.db.Exec(query, id, sender, pq.Array(text), version, time.Now().Unix())
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+// deleteMessage soft deletes one of sender's messages, it's purged with the
+// expired ones, returning false if there's no such message.
+func (s *postgresStore) deleteMessage(id int64, sender string, at int64) (bool, error) {
+    query := `
+    UPDATE messages SET active = FALSE, deleted_at = $3
+    WHERE id = $1 AND sender = $2 AND deleted_at IS NULL
+    `
+    res, err := s.db.Exec(query, id, sender, at)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+// appraisalCounts is joined onto messages to count their appraisals.
+const appraisalCounts = `
+    CROSS JOIN LATERAL (
+        SELECT COUNT(*) FILTER (WHERE helpful) AS helpful, COUNT(*) FILTER (WHERE NOT helpful) AS unhelpful
+        FROM message_appraisals WHERE message_id = messages.id
+    ) appraisals`
+
+func (s *postgresStore) getMessagesBySender(sender string) ([]Message, error) {
+    rows, err := s.db.Query(`
+        SELECT id, sender, text, latitude, longitude, expires, active, template_version, appraisals.helpful, appraisals.unhelpful
+        FROM messages`+appraisalCounts+`
+        WHERE sender = $1 AND deleted_at IS NULL
+        ORDER BY id DESC`, sender)
     if err != nil {
         return nil, err
     }
@@ -162,7 +644,91 @@ func getMessagesWithinRadius(lat, lon, radius float64) ([]Message, error) {
     for rows.Next() {
         var msg Message
         var int64Array pq.Int64Array
-        if err := rows.Scan(&msg.ID, &msg.Sender, &int64Array, &msg.Latitude, &msg.Longitude, &msg.Expires, &msg.Active); err != nil {
+        if err := rows.Scan(&msg.ID, &msg.Sender, &int64Array, &msg.Latitude, &msg.Longitude, &msg.Expires, &msg.Active, &msg.TemplateVersion, &msg.Helpful, &msg.Unhelpful); err != nil {
+            return nil, err
+        }
+
+        msg.Text = make([]int16, len(int64Array))
+        for i, v := range int64Array {
+            msg.Text[i] = int16(v)
+        }
+        messages = append(messages, msg)
+    }
+    return messages, rows.Err()
+}
+
+// getMessageSender returns who sent a live message, or "" if there's no
+// such message.
+func (s *postgresStore) getMessageSender(id int64) (string, error) {
+    var sender string
+    err := s.db.QueryRow(`
+        SELECT sender FROM messages
+        WHERE id = $1 AND active = TRUE AND expires > $2 AND deleted_at IS NULL`, id, time.Now().Unix()).Scan(&sender)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return sender, err
+}
+
+// appraiseMessage records appraiser's appraisal of a live message, replacing
+// any they'd already made, returning false if there's no such message.
+func (s *postgresStore) appraiseMessage(id int64, appraiser string, helpful bool, at int64) (bool, error) {
+    query := `
+    INSERT INTO message_appraisals (message_id, appraiser, helpful, appraised_at)
+    SELECT id, $2, $3, $4 FROM messages
+    WHERE id = $1 AND active = TRUE AND expires > $4 AND deleted_at IS NULL
+    ON CONFLICT (message_id, appraiser) DO UPDATE SET helpful = EXCLUDED.helpful, appraised_at = EXCLUDED.appraised_at
+    `
+    res, err := s.db.Exec(query, id, appraiser, helpful, at)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+// expireMessages marks messages past their expiry inactive.
+func (s *postgresStore) expireMessages(now int64) (int64, error) {
+    res, err := s.db.Exec(`UPDATE messages SET active = FALSE WHERE active = TRUE AND expires <= $1`, now)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+// purgeMessages deletes inactive messages that expired, or were deleted,
+// before before.
+func (s *postgresStore) purgeMessages(before int64) (int64, error) {
+    res, err := s.db.Exec(`DELETE FROM messages WHERE active = FALSE AND COALESCE(deleted_at, expires) < $1`, before)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+// getMessagesWithinRadius finds live messages within radius km, leaving out
+// those whose helpful count less their unhelpful count is hideScore or lower.
+func (s *postgresStore) getMessagesWithinRadius(lat, lon, radius float64, hideScore int64) ([]Message, error) {
+    filter, args := s.radiusFilter(lat, lon, radius, 3)
+    rows, err := s.db.Query(`
+        SELECT id, sender, text, latitude, longitude, expires, active, template_version, appraisals.helpful, appraisals.unhelpful
+        FROM messages`+appraisalCounts+`
+        WHERE active = TRUE AND expires > $1 AND appraisals.helpful - appraisals.unhelpful > $2 AND `+moderated("messages", "message")+` AND `+filter,
+        append([]interface{}{time.Now().Unix(), hideScore}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []Message
+    for rows.Next() {
+        var msg Message
+        var int64Array pq.Int64Array
+        if err := rows.Scan(&msg.ID, &msg.Sender, &int64Array, &msg.Latitude, &msg.Longitude, &msg.Expires, &msg.Active, &msg.TemplateVersion, &msg.Helpful, &msg.Unhelpful); err != nil {
             return nil, err
         }
 
@@ -179,4 +745,183 @@ func getMessagesWithinRadius(lat, lon, radius float64) ([]Message, error) {
         }
     }
     return messages, nil
-}
\ No newline at end of file
+}
+
+// insertReport files reporter's report of a message or prize, returning
+// false if there's no such thing.
+func (s *postgresStore) insertReport(report ReportInput, reporter string, at int64) (bool, error) {
+    target := `SELECT 1 FROM messages WHERE id::text = $2 AND deleted_at IS NULL`
+    if report.TargetType == "prize" {
+        target = `SELECT 1 FROM prizes WHERE id = $2`
+    }
+    query := `
+    INSERT INTO reports (target_type, target_id, reporter, reason, created_at)
+    SELECT $1, $2, $3, $4, $5 WHERE EXISTS (` + target + `)
+    ON CONFLICT (target_type, target_id, reporter) DO UPDATE
+    SET reason = EXCLUDED.reason, created_at = EXCLUDED.created_at, resolved_at = NULL
+    `
+    res, err := s.db.Exec(query, report.TargetType, report.TargetID, reporter, report.Reason, at)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+func (s *postgresStore) getReports(resolved bool) ([]Report, error) {
+    rows, err := s.db.Query(`
+        SELECT id, target_type, target_id, reporter, reason, created_at, COALESCE(resolved_at, 0)
+        FROM reports
+        WHERE (resolved_at IS NOT NULL) = $1
+        ORDER BY created_at DESC
+        LIMIT 500`, resolved)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var reports []Report
+    for rows.Next() {
+        var report Report
+        if err := rows.Scan(&report.ID, &report.TargetType, &report.TargetID, &report.Reporter, &report.Reason, &report.CreatedAt, &report.ResolvedAt); err != nil {
+            return nil, err
+        }
+        reports = append(reports, report)
+    }
+    return reports, rows.Err()
+}
+
+// setModeration hides or restores a message or prize and resolves its open
+// reports.
+func (s *postgresStore) setModeration(input ModerationInput, moderator string, at int64) error {
+    _, err := s.db.Exec(`
+    WITH resolved AS (
+        UPDATE reports SET resolved_at = $6
+        WHERE target_type = $1 AND target_id = $2 AND resolved_at IS NULL
+    )
+    INSERT INTO moderation (target_type, target_id, hidden, note, moderator, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (target_type, target_id) DO UPDATE
+    SET hidden = EXCLUDED.hidden, note = EXCLUDED.note, moderator = EXCLUDED.moderator, updated_at = EXCLUDED.updated_at
+    `, input.TargetType, input.TargetID, input.Hidden, input.Note, moderator, at)
+    return err
+}
+
+func (s *postgresStore) getBans() ([]Ban, error) {
+    rows, err := s.db.Query(`SELECT address, COALESCE(reason, ''), banned_by, banned_at FROM banned_senders ORDER BY banned_at DESC`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var bans []Ban
+    for rows.Next() {
+        var ban Ban
+        if err := rows.Scan(&ban.Address, &ban.Reason, &ban.BannedBy, &ban.BannedAt); err != nil {
+            return nil, err
+        }
+        bans = append(bans, ban)
+    }
+    return bans, rows.Err()
+}
+
+func (s *postgresStore) insertBan(ban Ban) error {
+    _, err := s.db.Exec(`
+    INSERT INTO banned_senders (address, reason, banned_by, banned_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (address) DO UPDATE SET reason = EXCLUDED.reason, banned_by = EXCLUDED.banned_by, banned_at = EXCLUDED.banned_at
+    `, ban.Address, ban.Reason, ban.BannedBy, ban.BannedAt)
+    return err
+}
+
+func (s *postgresStore) deleteBan(address string) (bool, error) {
+    res, err := s.db.Exec(`DELETE FROM banned_senders WHERE address = $1`, address)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
+
+func (s *postgresStore) isBanned(address string) (bool, error) {
+    var banned bool
+    err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM banned_senders WHERE address = $1)`, address).Scan(&banned)
+    return banned, err
+}
+
+func (s *postgresStore) getExclusionZones() ([]exclusionZone, error) {
+    rows, err := s.db.Query(`SELECT id, name, polygons, created_by, created_at FROM exclusion_zones ORDER BY id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var zones []exclusionZone
+    for rows.Next() {
+        var zone exclusionZone
+        var polygons []byte
+        if err := rows.Scan(&zone.ID, &zone.Name, &polygons, &zone.CreatedBy, &zone.CreatedAt); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(polygons, &zone.Polygons); err != nil {
+            return nil, fmt.Errorf("zone %d: %w", zone.ID, err)
+        }
+        zones = append(zones, zone)
+    }
+    return zones, rows.Err()
+}
+
+// insertExclusionZones adds zones all at once, returning their ids.
+func (s *postgresStore) insertExclusionZones(zones []exclusionZone) ([]int64, error) {
+    var values []string
+    var args []interface{}
+    for _, zone := range zones {
+        polygons, err := json.Marshal(zone.Polygons)
+        if err != nil {
+            return nil, err
+        }
+        n := len(args)
+        values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+        args = append(args, zone.Name, string(polygons), zone.CreatedBy, zone.CreatedAt)
+    }
+
+    rows, err := s.db.Query(`
+    INSERT INTO exclusion_zones (name, polygons, created_by, created_at)
+    VALUES `+strings.Join(values, ", ")+`
+    RETURNING id`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+func (s *postgresStore) deleteExclusionZone(id int64) (bool, error) {
+    res, err := s.db.Exec(`DELETE FROM exclusion_zones WHERE id = $1`, id)
+    if err != nil {
+        return false, err
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return n == 1, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+)
+
+// Drops and messages are bucketed into a fixed lat/lon grid so that radius
+// searches can be narrowed down with an index before the exact haversine
+// check. 0.05 degrees is ~5.5km north/south, so a 10km search touches a
+// handful of cells at most latitudes.
+const (
+	earthRadiusKm   = 6371
+	cellSizeDegrees = 0.05
+	cellRows        = 3600 // 180 / cellSizeDegrees
+	cellColumns     = 7200 // 360 / cellSizeDegrees
+	maxSearchCells  = 1024
+	// padding added to every search bound so floating point noise can only
+	// ever widen the prefilter, never drop a row haversine would keep.
+	boundsEpsilon = 1e-9
+)
+
+func validCoordinates(lat, lon float64) bool {
+	return !math.IsNaN(lat) && !math.IsNaN(lon) &&
+		lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func cellRow(lat float64) int64 {
+	row := int64(math.Floor((lat + 90) / cellSizeDegrees))
+	if row < 0 {
+		return 0
+	}
+	if row >= cellRows {
+		return cellRows - 1
+	}
+	return row
+}
+
+func cellColumn(lon float64) int64 {
+	return floorMod(int64(math.Floor((lon+180)/cellSizeDegrees)), cellColumns)
+}
+
+// cellFor returns the grid cell a point falls in.
+func cellFor(lat, lon float64) int64 {
+	return cellRow(lat)*cellColumns + cellColumn(lon)
+}
+
+// searchArea is the prefilter for a radius search. Every point within the
+// radius is inside [MinLat, MaxLat] and, when Cells is non-nil, inside one
+// of Cells. Cells is nil when the circle covers a pole or too many cells to
+// be worth enumerating, in which case only the latitude band applies.
+type searchArea struct {
+	Cells  []int64
+	MinLat float64
+	MaxLat float64
+}
+
+func searchAreaFor(lat, lon, radius float64) searchArea {
+	angular := radius / earthRadiusKm
+	dLat := angular*(180/math.Pi) + boundsEpsilon
+
+	area := searchArea{MinLat: lat - dLat, MaxLat: lat + dLat}
+	if area.MinLat <= -90 || area.MaxLat >= 90 {
+		return area
+	}
+
+	// widest longitude offset reachable on a circle of this angular radius
+	dLon := math.Asin(math.Sin(angular)/math.Cos(lat*(math.Pi/180)))*(180/math.Pi) + boundsEpsilon
+
+	firstCol := int64(math.Floor((lon - dLon + 180) / cellSizeDegrees))
+	lastCol := int64(math.Floor((lon + dLon + 180) / cellSizeDegrees))
+	firstRow, lastRow := cellRow(area.MinLat), cellRow(area.MaxLat)
+
+	cols := lastCol - firstCol + 1
+	if cols >= cellColumns || cols*(lastRow-firstRow+1) > maxSearchCells {
+		return area
+	}
+
+	area.Cells = make([]int64, 0, cols*(lastRow-firstRow+1))
+	for row := firstRow; row <= lastRow; row++ {
+		for col := firstCol; col <= lastCol; col++ {
+			area.Cells = append(area.Cells, row*cellColumns+floorMod(col, cellColumns))
+		}
+	}
+	return area
+}
+
+// contains reports whether a point passes the prefilter. It mirrors the SQL
+// the stores run so in-process callers get the same candidate set.
+func (a searchArea) contains(lat, lon float64) bool {
+	if lat < a.MinLat || lat > a.MaxLat {
+		return false
+	}
+	if a.Cells == nil {
+		return true
+	}
+	cell := cellFor(lat, lon)
+	for _, c := range a.Cells {
+		if c == cell {
+			return true
+		}
+	}
+	return false
+}
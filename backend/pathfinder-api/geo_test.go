@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func randomPrizes(r *rand.Rand, n int, lat, lon, spread float64) []Prize {
+	prizes := make([]Prize, n)
+	for i := range prizes {
+		pLat := lat + (r.Float64()*2-1)*spread
+		pLon := lon + (r.Float64()*2-1)*spread
+		if pLat > 90 {
+			pLat = 90
+		} else if pLat < -90 {
+			pLat = -90
+		}
+		if pLon > 180 {
+			pLon -= 360
+		} else if pLon < -180 {
+			pLon += 360
+		}
+		prizes[i] = Prize{Latitude: pLat, Longitude: pLon}
+	}
+	return prizes
+}
+
+// linearSearch is the pre-index behaviour: haversine against every row.
+func linearSearch(prizes []Prize, lat, lon, radius float64) []int {
+	var found []int
+	for i, p := range prizes {
+		if d, _ := haversine(lat, lon, p.Latitude, p.Longitude); d <= radius {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// gridIndex mimics the cell index the database keeps.
+type gridIndex map[int64][]int
+
+func newGridIndex(prizes []Prize) gridIndex {
+	idx := gridIndex{}
+	for i, p := range prizes {
+		cell := cellFor(p.Latitude, p.Longitude)
+		idx[cell] = append(idx[cell], i)
+	}
+	return idx
+}
+
+func (g gridIndex) search(prizes []Prize, lat, lon, radius float64) []int {
+	area := searchAreaFor(lat, lon, radius)
+	var candidates []int
+	if area.Cells == nil {
+		for i, p := range prizes {
+			if area.contains(p.Latitude, p.Longitude) {
+				candidates = append(candidates, i)
+			}
+		}
+	} else {
+		for _, cell := range area.Cells {
+			for _, i := range g[cell] {
+				if area.contains(prizes[i].Latitude, prizes[i].Longitude) {
+					candidates = append(candidates, i)
+				}
+			}
+		}
+	}
+
+	var found []int
+	for _, i := range candidates {
+		if d, _ := haversine(lat, lon, prizes[i].Latitude, prizes[i].Longitude); d <= radius {
+			found = append(found, i)
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+func TestCellFor(t *testing.T) {
+	if cellFor(0, 0) != cellFor(0.01, 0.01) {
+		t.Errorf("cellFor() put nearby points in different cells")
+	}
+	if cellFor(0, 180) != cellFor(0, -180) {
+		t.Errorf("cellFor(0, 180) = %d; want %d", cellFor(0, 180), cellFor(0, -180))
+	}
+	if cellFor(90, 0) != cellFor(89.99, 0) {
+		t.Errorf("cellFor(90, 0) = %d; want %d", cellFor(90, 0), cellFor(89.99, 0))
+	}
+}
+
+func TestSearchAreaMatchesHaversine(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	centers := [][2]float64{
+		{51.4687367, -0.0399826}, // london
+		{0, 179.99},              // antimeridian
+		{-33.8688, -179.95},
+		{89.95, 10}, // pole
+		{-89.99, -45},
+		{70.1, 25.3},
+	}
+
+	for _, c := range centers {
+		prizes := randomPrizes(r, 20000, c[0], c[1], 0.5)
+		idx := newGridIndex(prizes)
+		for _, radius := range []float64{0.01, 1, 8, 10, 40} {
+			want := linearSearch(prizes, c[0], c[1], radius)
+			got := idx.search(prizes, c[0], c[1], radius)
+			if len(got) != len(want) {
+				t.Fatalf("search(%f, %f, %f) found %d; want %d", c[0], c[1], radius, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("search(%f, %f, %f) = %v; want %v", c[0], c[1], radius, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchAreaFallsBackNearPoles(t *testing.T) {
+	area := searchAreaFor(89.99, 0, 10)
+	if area.Cells != nil {
+		t.Errorf("searchAreaFor() near the pole returned %d cells; want latitude band only", len(area.Cells))
+	}
+	if !area.contains(89.995, 170) {
+		t.Errorf("searchAreaFor() near the pole excluded a point across the pole")
+	}
+}
+
+// seedRadiusSearch fills s with 100k active drops spread over a 4x4 degree
+// box around london.
+func seedRadiusSearch(b *testing.B, s Store) {
+	expires := time.Now().Add(time.Hour).Unix()
+	for i, p := range randomPrizes(rand.New(rand.NewSource(3)), 100000, 51.5, -0.1, 2) {
+		prize := conformancePrize(0, p.Latitude, p.Longitude)
+		prize.ID = fmt.Sprintf("0x%064x", i)
+		prize.Expires, prize.Active = expires, true
+		if err := s.upsertPrizeLock(prize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkRadiusSearch times the store's 10km prize radius search from
+// random points in the seeded box.
+func benchmarkRadiusSearch(b *testing.B, s Store) {
+	r := rand.New(rand.NewSource(2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.getPrizeLocksWithinRadius(51.5+(r.Float64()*2-1), -0.1+(r.Float64()*2-1), 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSQLiteRadiusSearch100k(b *testing.B) {
+	b.Setenv("SQLITE_PATH", filepath.Join(b.TempDir(), "bench.db"))
+	s := openSQLiteStore()
+	defer s.close()
+	seedRadiusSearch(b, s)
+
+	b.Run("grid", func(b *testing.B) { benchmarkRadiusSearch(b, s) })
+}
+
+// BenchmarkPostgresRadiusSearch100k runs against the DB_ database with
+// TEST_POSTGRES=true, emptying its prizes first. The postgis run needs the
+// extension installed before migration 0013.
+func BenchmarkPostgresRadiusSearch100k(b *testing.B) {
+	if os.Getenv("TEST_POSTGRES") != "true" {
+		b.Skip("TEST_POSTGRES not set")
+	}
+	s := openPostgresStore()
+	defer s.close()
+	if _, err := s.db.Exec(`TRUNCATE prizes CASCADE`); err != nil {
+		b.Fatal(err)
+	}
+	seedRadiusSearch(b, s)
+	if _, err := s.db.Exec(`ANALYZE prizes`); err != nil {
+		b.Fatal(err)
+	}
+
+	postGIS := s.postGIS
+	defer func() { s.postGIS = postGIS }()
+	s.postGIS = false
+	b.Run("grid", func(b *testing.B) { benchmarkRadiusSearch(b, s) })
+	if postGIS {
+		s.postGIS = true
+		b.Run("postgis", func(b *testing.B) { benchmarkRadiusSearch(b, s) })
+	}
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"pathfinder-api/contracts/dropmanager"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// name of the indexer_state row holding the last confirmed block
+	dropManagerCursor = "dropmanager"
+	// blocks re-scanned behind the cursor on catch-up, covering logs that
+	// were still waiting on confirmations when we went down
+	replayBlocks         = 64
+	defaultBlockRange    = 2000
+	defaultConfirmations = 5
+	minReconnectDelay    = time.Second
+	maxReconnectDelay    = time.Minute * 2
+	// how far a subscription can get ahead of the logs and heads being handled
+	subscriptionBuffer = 256
+)
+
+// indexer states reported in indexerStatus
+const (
+	indexerConnecting = "connecting"
+	indexerCatchingUp = "catching_up"
+	indexerLive       = "live"
+	indexerBackoff    = "backoff"
+	indexerStopped    = "stopped"
+)
+
+// indexerStore is the persistence the drop indexer needs.
+type indexerStore interface {
+	updatePrizeLockFields(pType, sender, hashedPassword, id string, active bool, claimedBy string, block uint64, index uint) error
+	revertPrizeLockFields(id string, active bool, block uint64, index uint) error
+	insertDropLog(log DropLog) error
+	deleteDropLog(block uint64, index uint, txHash string) error
+	getIndexedBlock(name string) (uint64, bool, error)
+	setIndexedBlock(name string, block uint64) error
+}
+
+// chainBackend is what the indexer needs from the node, satisfied by both
+// *ethclient.Client and the simulated backend used in tests.
+type chainBackend interface {
+	bind.ContractBackend
+	BlockNumber(ctx context.Context) (uint64, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// dropEvent is either a DropAdded or a DropUnlocked log, so both can be
+// handled together in chain order.
+type dropEvent struct {
+	Raw      types.Log
+	Added    *dropmanager.DropmanagerDropAdded
+	Unlocked *dropmanager.DropmanagerDropUnlocked
+}
+
+func (e dropEvent) id() string {
+	if e.Added != nil {
+		return dropID(e.Added.Id)
+	}
+	return dropID(e.Unlocked.Id)
+}
+
+// active is the prize state the event leaves behind once applied.
+func (e dropEvent) active() bool {
+	return e.Added != nil
+}
+
+// DropLog is a DropAdded or DropUnlocked log as kept in drop_events, the
+// history of a drop. Event is added or unlocked; an unlock is a claim, or a
+// reclaim when the receiver is the sender taking an expired drop back.
+type DropLog struct {
+	DropID    string `json:"dropId"`
+	Event     string `json:"event"`
+	Sender    string `json:"sender"`
+	Receiver  string `json:"receiver,omitempty"`
+	Block     uint64 `json:"block"`
+	TxHash    string `json:"txHash"`
+	LogIndex  uint   `json:"logIndex"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+const (
+	dropLogAdded    = "added"
+	dropLogUnlocked = "unlocked"
+)
+
+// kind is added, claimed or reclaimed.
+func (l DropLog) kind() string {
+	switch {
+	case l.Event == dropLogAdded:
+		return "added"
+	case l.Receiver == l.Sender:
+		return "reclaimed"
+	default:
+		return "claimed"
+	}
+}
+
+// record is the drop_events row for the event, in a block mined at time at.
+func (e dropEvent) record(at int64) DropLog {
+	log := DropLog{
+		DropID:    e.id(),
+		Block:     e.Raw.BlockNumber,
+		TxHash:    strings.ToLower(e.Raw.TxHash.Hex()),
+		LogIndex:  e.Raw.Index,
+		Timestamp: at,
+	}
+	if e.Added != nil {
+		log.Event, log.Sender = dropLogAdded, strings.ToLower(e.Added.Sender.Hex())
+	} else {
+		log.Event, log.Sender = dropLogUnlocked, strings.ToLower(e.Unlocked.Sender.Hex())
+		log.Receiver = strings.ToLower(e.Unlocked.Reciever.Hex())
+	}
+	return log
+}
+
+func (e dropEvent) sameLog(l types.Log) bool {
+	return e.Raw.BlockHash == l.BlockHash && e.Raw.Index == l.Index
+}
+
+func sortDropEvents(events []dropEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Raw.BlockNumber != events[j].Raw.BlockNumber {
+			return events[i].Raw.BlockNumber < events[j].Raw.BlockNumber
+		}
+		return events[i].Raw.Index < events[j].Raw.Index
+	})
+}
+
+// dropIndexer keeps the prizes table and drop_events in step with
+// DropManager events. Logs are held back until they are `confirmations`
+// blocks deep (the block that includes them counts as one), and logs removed
+// by a reorg are dropped, or reverted if they were already applied.
+type dropIndexer struct {
+	contract      *dropmanager.Dropmanager
+	backend       chainBackend
+	store         indexerStore
+	confirmations uint64
+	blockRange    uint64
+	startBlock    *uint64
+	// changed, if set, is called whenever applying or reverting an event
+	// has changed a prize.
+	changed func()
+
+	// mu guards pending and status against snapshot; everything else only
+	// runs on the session's goroutine, so it's never held over a node call
+	// or store write
+	mu      sync.Mutex
+	pending []dropEvent
+	status  indexerStatus
+
+	// the block blockTime last looked up, as logs come in runs from one block
+	timedBlock common.Hash
+	blockAt    int64
+}
+
+// indexerStatus is a snapshot of what the indexer is doing, served on /status.
+type indexerStatus struct {
+	State          string     `json:"state"`
+	HeadBlock      uint64     `json:"headBlock"`
+	ConfirmedBlock uint64     `json:"confirmedBlock"`
+	Confirmations  uint64     `json:"confirmations"`
+	PendingLogs    int        `json:"pendingLogs"`
+	Reconnects     int        `json:"reconnects"`
+	LastError      string     `json:"lastError,omitempty"`
+	LastErrorAt    *time.Time `json:"lastErrorAt,omitempty"`
+	LiveSince      *time.Time `json:"liveSince,omitempty"`
+}
+
+func newDropIndexer(contract *dropmanager.Dropmanager, backend chainBackend, store indexerStore) *dropIndexer {
+	d := &dropIndexer{
+		contract:      contract,
+		backend:       backend,
+		store:         store,
+		confirmations: envUint("INDEXER_CONFIRMATIONS", defaultConfirmations),
+		blockRange:    envUint("INDEXER_BLOCK_RANGE", defaultBlockRange),
+		status:        indexerStatus{State: indexerConnecting},
+	}
+	if d.blockRange == 0 {
+		d.blockRange = defaultBlockRange
+	}
+	if v := envUint("DM_START_BLOCK", 0); v > 0 {
+		d.startBlock = &v
+	}
+	return d
+}
+
+// confirmedHead is the newest block with enough confirmations at head.
+func (d *dropIndexer) confirmedHead(head uint64) (uint64, bool) {
+	if d.confirmations <= 1 {
+		return head, true
+	}
+	if head+1 < d.confirmations {
+		return 0, false
+	}
+	return head + 1 - d.confirmations, true
+}
+
+// blockTime is when the block with hash was mined, in unix seconds.
+func (d *dropIndexer) blockTime(ctx context.Context, hash common.Hash) (int64, error) {
+	if hash == d.timedBlock {
+		return d.blockAt, nil
+	}
+	header, err := d.backend.HeaderByHash(ctx, hash)
+	if err != nil {
+		return 0, err
+	}
+	d.timedBlock, d.blockAt = hash, int64(header.Time)
+	return d.blockAt, nil
+}
+
+// apply records the event in the drop's history and updates the prize.
+func (d *dropIndexer) apply(ctx context.Context, e dropEvent) error {
+	at, err := d.blockTime(ctx, e.Raw.BlockHash)
+	if err != nil {
+		return err
+	}
+	log := e.record(at)
+	if err := d.store.insertDropLog(log); err != nil {
+		return err
+	}
+
+	var pType string
+	var hashedPassword common.Hash
+	if e.Added != nil {
+		pType, hashedPassword = e.Added.PrizeType, e.Added.HashedPassword
+	} else {
+		pType, hashedPassword = e.Unlocked.PrizeType, e.Unlocked.HashedPassword
+	}
+	if err := d.store.updatePrizeLockFields(pType, log.Sender, strings.ToLower(hashedPassword.Hex()), log.DropID, e.active(), log.Receiver, e.Raw.BlockNumber, e.Raw.Index); err != nil {
+		return err
+	}
+	d.notifyChanged()
+	return nil
+}
+
+func (d *dropIndexer) notifyChanged() {
+	if d.changed != nil {
+		d.changed()
+	}
+}
+
+// pend adds a new log to pending or takes a removed one back out, reporting
+// false for a removed log that wasn't there as it has been applied already.
+func (d *dropIndexer) pend(e dropEvent) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, p := range d.pending {
+		if p.sameLog(e.Raw) {
+			if e.Raw.Removed {
+				d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			}
+			return true
+		}
+	}
+	if e.Raw.Removed {
+		return false
+	}
+	d.pending = append(d.pending, e)
+	return true
+}
+
+// handleLog takes a log from a live subscription. New logs wait in pending
+// for confirmations, removed ones are taken back out of pending or, if they
+// had already been applied, reverted.
+func (d *dropIndexer) handleLog(ctx context.Context, e dropEvent) error {
+	if !d.pend(e) {
+		Sugar.Warnf("reverting %s for drop %s removed from block %d", e.Raw.TxHash.Hex(), e.id(), e.Raw.BlockNumber)
+		if err := d.store.deleteDropLog(e.Raw.BlockNumber, e.Raw.Index, strings.ToLower(e.Raw.TxHash.Hex())); err != nil {
+			return err
+		}
+		if err := d.store.revertPrizeLockFields(e.id(), !e.active(), e.Raw.BlockNumber, e.Raw.Index); err != nil {
+			return err
+		}
+		d.notifyChanged()
+		return nil
+	}
+	if e.Raw.Removed {
+		return nil
+	}
+
+	// heads come in on their own subscription, so the one confirming this
+	// log may have been handled before it arrived
+	d.mu.Lock()
+	head := d.status.HeadBlock
+	d.mu.Unlock()
+	if confirmed, ok := d.confirmedHead(head); ok && e.Raw.BlockNumber <= confirmed {
+		return d.handleHead(ctx, head)
+	}
+	return nil
+}
+
+// handleHead applies the pending logs that head has confirmed and moves the
+// cursor up to the confirmed block.
+func (d *dropIndexer) handleHead(ctx context.Context, head uint64) error {
+	confirmed, ok := d.confirmedHead(head)
+
+	d.mu.Lock()
+	d.status.HeadBlock = head
+	var ready, waiting []dropEvent
+	if ok {
+		sortDropEvents(d.pending)
+		for _, e := range d.pending {
+			if e.Raw.BlockNumber > confirmed {
+				waiting = append(waiting, e)
+			} else {
+				ready = append(ready, e)
+			}
+		}
+		d.pending = waiting
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	for i, e := range ready {
+		if err := d.apply(ctx, e); err != nil {
+			d.mu.Lock()
+			d.pending = append(ready[i:], d.pending...)
+			d.mu.Unlock()
+			return err
+		}
+	}
+
+	if err := d.store.setIndexedBlock(dropManagerCursor, confirmed); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.status.ConfirmedBlock = confirmed
+	d.mu.Unlock()
+	return nil
+}
+
+// fetchDropEvents returns every DropAdded/DropUnlocked log in [from, to], in chain order.
+func (d *dropIndexer) fetchDropEvents(ctx context.Context, from, to uint64) ([]dropEvent, error) {
+	opts := &bind.FilterOpts{Start: from, End: &to, Context: ctx}
+	var events []dropEvent
+
+	added, err := d.contract.FilterDropAdded(opts, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for added.Next() {
+		events = append(events, dropEvent{Raw: added.Event.Raw, Added: added.Event})
+	}
+	added.Close()
+	if err := added.Error(); err != nil {
+		return nil, err
+	}
+
+	unlocked, err := d.contract.FilterDropUnlocked(opts, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for unlocked.Next() {
+		events = append(events, dropEvent{Raw: unlocked.Event.Raw, Unlocked: unlocked.Event})
+	}
+	unlocked.Close()
+	if err := unlocked.Error(); err != nil {
+		return nil, err
+	}
+
+	sortDropEvents(events)
+	return events, nil
+}
+
+// catchUp replays the logs emitted since the stored cursor up to the newest
+// confirmed block, in blockRange sized chunks. With no cursor yet it starts
+// from DM_START_BLOCK, or from the head if that isn't set either.
+func (d *dropIndexer) catchUp(ctx context.Context) error {
+	head, err := d.backend.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	confirmed, ok := d.confirmedHead(head)
+	if !ok {
+		return nil
+	}
+
+	from, ok, err := d.store.getIndexedBlock(dropManagerCursor)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ok && from > replayBlocks:
+		from -= replayBlocks
+	case ok:
+		from = 0
+	case d.startBlock != nil:
+		from = *d.startBlock
+	default:
+		from = confirmed
+	}
+
+	Sugar.Infof("catching up on drop events from block %d to %d", from, confirmed)
+
+	for start := from; start <= confirmed; start += d.blockRange {
+		end := start + d.blockRange - 1
+		if end > confirmed {
+			end = confirmed
+		}
+
+		events, err := d.fetchDropEvents(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := d.apply(ctx, e); err != nil {
+				return err
+			}
+		}
+
+		if err := d.store.setIndexedBlock(dropManagerCursor, end); err != nil {
+			return err
+		}
+		d.mu.Lock()
+		d.status.ConfirmedBlock = end
+		d.mu.Unlock()
+	}
+
+	Sugar.Infof("caught up on drop events to block %d", confirmed)
+	return nil
+}
+
+// replay covers the blocks mined between catching up and subscribing: the
+// confirmed ones are caught up on again and the logs in the rest go into
+// pending, as if the subscriptions had seen them.
+func (d *dropIndexer) replay(ctx context.Context) error {
+	if err := d.catchUp(ctx); err != nil {
+		return err
+	}
+
+	head, err := d.backend.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	from := uint64(0)
+	if cursor, ok, err := d.store.getIndexedBlock(dropManagerCursor); err != nil {
+		return err
+	} else if ok {
+		from = cursor + 1
+	}
+	if from > head {
+		return nil
+	}
+
+	events, err := d.fetchDropEvents(ctx, from, head)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		d.pend(e)
+	}
+	return nil
+}
+
+func (d *dropIndexer) setState(state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.status.State = state
+	if state == indexerLive {
+		now := time.Now()
+		d.status.LiveSince = &now
+	} else {
+		d.status.LiveSince = nil
+	}
+}
+
+func (d *dropIndexer) recordError(err error) {
+	Sugar.Error(err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.status.LastError = err.Error()
+	d.status.LastErrorAt = &now
+}
+
+func (d *dropIndexer) snapshot() indexerStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.status
+	s.Confirmations = d.confirmations
+	s.PendingLogs = len(d.pending)
+	return s
+}
+
+// nextReconnectDelay doubles the delay up to maxReconnectDelay.
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+	return delay
+}
+
+// jitter spreads reconnects over [delay/2, delay) so replicas don't retry in step.
+func jitter(delay time.Duration) time.Duration {
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// run is the indexer's supervisor. It keeps a session going until ctx is
+// cancelled, reconnecting with exponential backoff whenever one fails.
+func (d *dropIndexer) run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		d.setState(indexerConnecting)
+		started := time.Now()
+		err := d.session(ctx)
+		if ctx.Err() != nil {
+			d.setState(indexerStopped)
+			Sugar.Info("indexer stopped")
+			return
+		}
+		d.recordError(err)
+
+		// a session that stayed up for a while resets the backoff
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+
+		d.setState(indexerBackoff)
+		select {
+		case <-ctx.Done():
+			d.setState(indexerStopped)
+			Sugar.Info("indexer stopped")
+			return
+		case <-time.After(jitter(delay)):
+		}
+		delay = nextReconnectDelay(delay)
+
+		d.mu.Lock()
+		d.status.Reconnects++
+		d.mu.Unlock()
+	}
+}
+
+// session catches up on what was missed while disconnected, subscribes to
+// both drop events and new heads, then follows the chain until a
+// subscription fails or ctx is cancelled. Nothing is subscribed while a long
+// catch-up runs, so nothing backs up behind it; what was mined meanwhile is
+// replayed once subscribed, and anything seen twice is ignored by the store.
+func (d *dropIndexer) session(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.setState(indexerCatchingUp)
+	if err := d.catchUp(ctx); err != nil {
+		return err
+	}
+
+	added := make(chan *dropmanager.DropmanagerDropAdded, subscriptionBuffer)
+	addedSub, err := d.contract.WatchDropAdded(&bind.WatchOpts{Context: ctx}, added, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer addedSub.Unsubscribe()
+
+	unlocked := make(chan *dropmanager.DropmanagerDropUnlocked, subscriptionBuffer)
+	unlockedSub, err := d.contract.WatchDropUnlocked(&bind.WatchOpts{Context: ctx}, unlocked, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unlockedSub.Unsubscribe()
+
+	heads := make(chan *types.Header, subscriptionBuffer)
+	headSub, err := d.backend.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer headSub.Unsubscribe()
+
+	if err := d.replay(ctx); err != nil {
+		return err
+	}
+	d.setState(indexerLive)
+	Sugar.Info("indexer live")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-addedSub.Err():
+			return subscriptionErr(err)
+		case err := <-unlockedSub.Err():
+			return subscriptionErr(err)
+		case err := <-headSub.Err():
+			return subscriptionErr(err)
+		case log := <-added:
+			if err := d.handleLog(ctx, dropEvent{Raw: log.Raw, Added: log}); err != nil {
+				d.recordError(err)
+			}
+		case log := <-unlocked:
+			if err := d.handleLog(ctx, dropEvent{Raw: log.Raw, Unlocked: log}); err != nil {
+				d.recordError(err)
+			}
+		case head := <-heads:
+			if err := d.handleHead(ctx, head.Number.Uint64()); err != nil {
+				d.recordError(err)
+			}
+		}
+	}
+}
+
+// subscriptionErr is why a subscription ended. Its Err channel is closed
+// without one when it ends quietly, which still needs a reconnect.
+func subscriptionErr(err error) error {
+	if err == nil {
+		return errors.New("subscription closed")
+	}
+	return err
+}
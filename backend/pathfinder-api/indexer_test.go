@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"pathfinder-api/contracts/dropmanager"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+// logEmitterRuntime stands in for DropManager on the simulated chain. The
+// calldata is [topic count (3 or 4)][topics...][log data] and it emits that
+// log verbatim, so the tests can raise DropAdded/DropUnlocked without a
+// compiled copy of the real contract.
+const logEmitterRuntime = "" +
+	"600035600414602157" + // if calldata[0:32] == 4 jump to LOG4
+	"6080360380608060003760603560403560203583" + "6000a300" + // LOG3(topics from calldata[32:128])
+	"5b60a036038060a060003760803560603560403560203584" + "6000a400" // LOG4(topics from calldata[32:160])
+
+// initCodeFor wraps runtime code in a constructor that just returns it.
+func initCodeFor(runtime []byte) []byte {
+	return append([]byte{0x60, byte(len(runtime)), 0x80, 0x60, 0x0b, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3}, runtime...)
+}
+
+type testChain struct {
+	t        *testing.T
+	sim      *simulated.Backend
+	auth     *bind.TransactOpts
+	abi      abi.ABI
+	address  common.Address
+	emitter  *bind.BoundContract
+	contract *dropmanager.Dropmanager
+}
+
+func newTestChain(t *testing.T) *testChain {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sim := simulated.NewBackend(types.GenesisAlloc{from: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)}})
+	t.Cleanup(func() { sim.Close() })
+
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, _ := hex.DecodeString(logEmitterRuntime)
+	address, _, emitter, err := bind.DeployContract(auth, abi.ABI{}, initCodeFor(runtime), sim.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Commit()
+
+	contract, err := dropmanager.NewDropmanager(address, sim.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := dropmanager.DropmanagerMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testChain{t: t, sim: sim, auth: auth, abi: *parsed, address: address, emitter: emitter, contract: contract}
+}
+
+func (c *testChain) head() uint64 {
+	n, err := c.sim.Client().BlockNumber(context.Background())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return n
+}
+
+func (c *testChain) emit(event string, topics []common.Hash, args ...interface{}) {
+	data, err := c.abi.Events[event].Inputs.NonIndexed().Pack(args...)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	calldata := common.LeftPadBytes(big.NewInt(int64(len(topics)+1)).Bytes(), 32)
+	calldata = append(calldata, c.abi.Events[event].ID.Bytes()...)
+	for _, topic := range topics {
+		calldata = append(calldata, topic.Bytes()...)
+	}
+	if _, err := c.emitter.RawTransact(c.auth, append(calldata, data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testChain) dropAdded(id [32]byte, sender common.Address) {
+	c.emit("DropAdded", []common.Hash{id, common.BytesToHash(sender.Bytes())},
+		[32]byte{1}, "erc20", common.Address{2}, big.NewInt(100), big.NewInt(time.Now().Add(time.Hour).Unix()))
+}
+
+func (c *testChain) dropUnlocked(id [32]byte, sender, receiver common.Address) {
+	c.emit("DropUnlocked", []common.Hash{id, common.BytesToHash(sender.Bytes()), common.BytesToHash(receiver.Bytes())},
+		[32]byte{1}, "erc20", common.Address{2}, big.NewInt(100), big.NewInt(time.Now().Add(time.Hour).Unix()))
+}
+
+// watch forwards the live DropAdded/DropUnlocked subscriptions into events.
+func (c *testChain) watch() chan dropEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.t.Cleanup(cancel)
+
+	events := make(chan dropEvent, 16)
+	added := make(chan *dropmanager.DropmanagerDropAdded)
+	unlocked := make(chan *dropmanager.DropmanagerDropUnlocked)
+	if _, err := c.contract.WatchDropAdded(&bind.WatchOpts{Context: ctx}, added, nil, nil); err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.contract.WatchDropUnlocked(&bind.WatchOpts{Context: ctx}, unlocked, nil, nil, nil); err != nil {
+		c.t.Fatal(err)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case log := <-added:
+				events <- dropEvent{Raw: log.Raw, Added: log}
+			case log := <-unlocked:
+				events <- dropEvent{Raw: log.Raw, Unlocked: log}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events chan dropEvent) dropEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a log")
+		return dropEvent{}
+	}
+}
+
+// newIndexerStore is a memoryStore holding a stored drop for each id, as sent
+// by the address and with the password hash testChain's logs carry.
+func newIndexerStore(t *testing.T, ids ...[32]byte) *memoryStore {
+	store := newMemoryStore()
+	for _, id := range ids {
+		err := store.upsertPrizeLock(Prize{
+			ID:              dropID(id),
+			Sender:          normalizeAddress(common.Address{9}.Hex()),
+			HashedPassword:  strings.ToLower(common.Hash{1}.Hex()),
+			Type:            "erc20",
+			ContractAddress: normalizeAddress(common.Address{2}.Hex()),
+			Amount:          big.NewInt(100),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func prizeActive(t *testing.T, store *memoryStore, id [32]byte) bool {
+	prize, found, err := store.getPrizeLockByID(dropID(id))
+	if err != nil || !found {
+		t.Fatalf("getPrizeLockByID(%s) = %v, %v", dropID(id), found, err)
+	}
+	return prize.Active
+}
+
+func newTestIndexer(chain *testChain, store *memoryStore, confirmations uint64) *dropIndexer {
+	return &dropIndexer{
+		contract:      chain.contract,
+		backend:       chain.sim.Client(),
+		store:         store,
+		confirmations: confirmations,
+		blockRange:    2,
+	}
+}
+
+func TestIndexerWaitsForConfirmations(t *testing.T) {
+	chain := newTestChain(t)
+	events := chain.watch()
+	id := [32]byte{0xaa}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 3)
+
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+			t.Fatal(err)
+		}
+		if prizeActive(t, store, id) {
+			t.Fatalf("prize activated with %d confirmations; want 3", i+1)
+		}
+		chain.sim.Commit()
+	}
+
+	if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+		t.Fatal(err)
+	}
+	if !prizeActive(t, store, id) {
+		t.Errorf("prize not activated after 3 confirmations")
+	}
+}
+
+// statusCheckingBackend fails t if the indexer's status can't be read while
+// a block header is being fetched.
+type statusCheckingBackend struct {
+	chainBackend
+	t   *testing.T
+	idx *dropIndexer
+}
+
+func (b *statusCheckingBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	done := make(chan struct{})
+	go func() {
+		b.idx.snapshot()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		b.t.Error("snapshot() blocked while a header was fetched")
+	}
+	return b.chainBackend.HeaderByHash(ctx, hash)
+}
+
+func TestIndexerFetchesHeadersUnlocked(t *testing.T) {
+	chain := newTestChain(t)
+	events := chain.watch()
+	id := [32]byte{0xab}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 1)
+	idx.backend = &statusCheckingBackend{chainBackend: chain.sim.Client(), t: t, idx: idx}
+
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+		t.Fatal(err)
+	}
+	if !prizeActive(t, store, id) {
+		t.Errorf("prize not activated")
+	}
+}
+
+func TestIndexerRevertsRemovedLogs(t *testing.T) {
+	chain := newTestChain(t)
+	events := chain.watch()
+	id := [32]byte{0xbb}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 1)
+
+	parent := chain.sim.Commit()
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+		t.Fatal(err)
+	}
+	if !prizeActive(t, store, id) {
+		t.Fatalf("prize not activated")
+	}
+
+	if err := chain.sim.Fork(parent); err != nil {
+		t.Fatal(err)
+	}
+	chain.sim.Rollback()
+	chain.sim.Commit()
+	chain.sim.Commit()
+
+	removed := nextEvent(t, events)
+	if !removed.Raw.Removed {
+		t.Fatalf("expected a removed log after the reorg")
+	}
+	if err := idx.handleLog(context.Background(), removed); err != nil {
+		t.Fatal(err)
+	}
+	if prizeActive(t, store, id) {
+		t.Errorf("prize still active after its DropAdded was reorged out")
+	}
+	if logs, err := store.getDropLogs(dropID(id)); err != nil || len(logs) != 0 {
+		t.Errorf("drop events = %+v, %v after the reorg; want none", logs, err)
+	}
+}
+
+func TestIndexerDropsRemovedPendingLogs(t *testing.T) {
+	chain := newTestChain(t)
+	events := chain.watch()
+	id := [32]byte{0xcc}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 3)
+
+	// activate the prize, then lose its unlock to a reorg before it confirms
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+	chain.sim.Commit()
+	parent := chain.sim.Commit()
+	if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.dropUnlocked(id, common.Address{9}, common.Address{10})
+	chain.sim.Commit()
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.sim.Fork(parent); err != nil {
+		t.Fatal(err)
+	}
+	chain.sim.Rollback()
+	for i := 0; i < 4; i++ {
+		chain.sim.Commit()
+	}
+	if err := idx.handleLog(context.Background(), nextEvent(t, events)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.handleHead(context.Background(), chain.head()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !prizeActive(t, store, id) {
+		t.Errorf("prize deactivated by an unlock that was reorged out")
+	}
+	if len(idx.pending) != 0 {
+		t.Errorf("pending = %d logs; want 0", len(idx.pending))
+	}
+}
+
+func TestIndexerCatchUp(t *testing.T) {
+	chain := newTestChain(t)
+	claimed, open := [32]byte{0xdd}, [32]byte{0xee}
+	store := newIndexerStore(t, claimed, open)
+	idx := newTestIndexer(chain, store, 2)
+	start := chain.head()
+	idx.startBlock = &start
+
+	chain.dropAdded(claimed, common.Address{9})
+	chain.sim.Commit()
+	chain.dropAdded(open, common.Address{9})
+	chain.sim.Commit()
+	chain.dropUnlocked(claimed, common.Address{9}, common.Address{10})
+	chain.sim.Commit()
+	chain.sim.Commit()
+
+	if err := idx.catchUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if prizeActive(t, store, claimed) {
+		t.Errorf("claimed prize active after catch up")
+	}
+	receiver := normalizeAddress(common.Address{10}.Hex())
+	if prize, _, err := store.getPrize(dropID(claimed)); err != nil || prize.ClaimedBy != receiver {
+		t.Errorf("claimed by %q, %v after catch up; want the receiver %q", prize.ClaimedBy, err, receiver)
+	}
+	logs, err := store.getDropLogs(dropID(claimed))
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("claimed drop events = %+v, %v; want its add and unlock", logs, err)
+	}
+	unlock := logs[1]
+	if unlock.DropID != dropID(claimed) || unlock.Receiver != receiver || unlock.kind() != "claimed" || unlock.Timestamp == 0 || unlock.TxHash == "" {
+		t.Errorf("unlock recorded as %+v; want a timed claim by %s", unlock, receiver)
+	}
+	if !prizeActive(t, store, open) {
+		t.Errorf("open prize inactive after catch up")
+	}
+	if logs, err := store.getDropLogs(dropID(open)); err != nil || len(logs) != 1 {
+		t.Errorf("open drop events = %+v, %v; want its add", logs, err)
+	}
+	if cursor, _, _ := store.getIndexedBlock(dropManagerCursor); cursor != chain.head()-1 {
+		t.Errorf("cursor = %d; want %d", cursor, chain.head()-1)
+	}
+}
+
+func TestIndexerRunFollowsChainAndStops(t *testing.T) {
+	chain := newTestChain(t)
+	id := [32]byte{0xff}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		idx.run(ctx)
+		close(done)
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor("the indexer to go live", func() bool { return idx.snapshot().State == indexerLive })
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+	waitFor("the prize to activate", func() bool { return prizeActive(t, store, id) })
+
+	// events are no longer throttled, so a burst is applied straight away
+	chain.dropUnlocked(id, common.Address{9}, common.Address{10})
+	chain.sim.Commit()
+	waitFor("the prize to deactivate", func() bool { return !prizeActive(t, store, id) })
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("indexer did not stop after cancel")
+	}
+	if state := idx.snapshot().State; state != indexerStopped {
+		t.Errorf("state = %s; want %s", state, indexerStopped)
+	}
+}
+
+func TestIndexerRunReplaysUnconfirmedLogs(t *testing.T) {
+	chain := newTestChain(t)
+	id := [32]byte{0xac}
+	store := newIndexerStore(t, id)
+	idx := newTestIndexer(chain, store, 2)
+
+	// mined before the subscriptions exist and not confirmed by catch-up
+	chain.dropAdded(id, common.Address{9})
+	chain.sim.Commit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go idx.run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for idx.snapshot().State != indexerLive {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the indexer to go live")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if prizeActive(t, store, id) {
+		t.Fatalf("prize activated with 1 confirmation; want 2")
+	}
+
+	chain.sim.Commit()
+	for !prizeActive(t, store, id) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the prize to activate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNextReconnectDelay(t *testing.T) {
+	delay := minReconnectDelay
+	for i := 0; i < 20; i++ {
+		delay = nextReconnectDelay(delay)
+	}
+	if delay != maxReconnectDelay {
+		t.Errorf("nextReconnectDelay() = %s after 20 failures; want %s", delay, maxReconnectDelay)
+	}
+	if d := jitter(time.Second); d < time.Second/2 || d > time.Second {
+		t.Errorf("jitter(1s) = %s; want within [500ms, 1s]", d)
+	}
+}
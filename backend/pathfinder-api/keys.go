@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyProvider hands out and unwraps the data keys prize passwords are
+// encrypted with, so the key that protects them never sits in the database.
+type KeyProvider interface {
+	// KeyID names the key new data keys are wrapped with.
+	KeyID() string
+	// GenerateDataKey returns a fresh 256 bit data key and the same key
+	// wrapped by the key named by the returned id.
+	GenerateDataKey(ctx context.Context) (key, wrapped []byte, keyID string, err error)
+	// DecryptDataKey unwraps a data key wrapped by keyID.
+	DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+const dataKeySize = 32
+
+var passwordKeys KeyProvider
+
+// initKeys sets up the KeyProvider chosen by KEY_PROVIDER:
+//
+//	local  master keys from PASSWORD_KEYS or the file at PASSWORD_KEY_FILE
+//	kms    the in-process KMS stand-in, master keys from KMS_STANDIN_KEYS
+//
+// Keys are listed as id=base64key separated by commas or newlines, the first
+// is the one new passwords are encrypted with and the rest are kept so older
+// passwords can still be read until they're re-encrypted.
+func initKeys() {
+	var err error
+	switch provider := os.Getenv("KEY_PROVIDER"); provider {
+	case "", "local":
+		spec := os.Getenv("PASSWORD_KEYS")
+		if path := os.Getenv("PASSWORD_KEY_FILE"); path != "" {
+			var raw []byte
+			raw, err = os.ReadFile(path)
+			spec = string(raw)
+		}
+		if err == nil {
+			passwordKeys, err = newLocalKeyProvider(spec)
+		}
+	case "kms":
+		Sugar.Warn("using the in-process KMS stand-in for password keys")
+		var kms *memoryKMS
+		kms, err = newMemoryKMS(os.Getenv("KMS_STANDIN_KEYS"))
+		if err == nil {
+			passwordKeys = newKMSKeyProvider(kms, kms.current)
+		}
+	default:
+		err = fmt.Errorf("unknown KEY_PROVIDER %q", provider)
+	}
+	if err != nil {
+		Sugar.Fatalf("password keys: %s", err.Error())
+	}
+	Sugar.Infof("password keys initialized, current key %s", passwordKeys.KeyID())
+}
+
+// parseMasterKeys reads id=base64key pairs, returning them and the first id.
+func parseMasterKeys(spec string) (map[string][]byte, string, error) {
+	keys := map[string][]byte{}
+	var current string
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, "", fmt.Errorf("invalid key entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != dataKeySize {
+			return nil, "", fmt.Errorf("key %s must be %d base64 encoded bytes", id, dataKeySize)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, "", fmt.Errorf("key %s listed twice", id)
+		}
+		if current == "" {
+			current = id
+		}
+		keys[id] = key
+	}
+	if current == "" {
+		return nil, "", errors.New("no keys configured")
+	}
+	return keys, current, nil
+}
+
+func newDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// gcmSeal encrypts plaintext with AES-256-GCM, prefixing the random nonce.
+// additional is authenticated but not encrypted.
+func gcmSeal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func gcmOpen(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// localKeyProvider wraps data keys with master keys held in process memory.
+type localKeyProvider struct {
+	keys    map[string][]byte
+	current string
+}
+
+func newLocalKeyProvider(spec string) (*localKeyProvider, error) {
+	keys, current, err := parseMasterKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &localKeyProvider{keys: keys, current: current}, nil
+}
+
+func (p *localKeyProvider) KeyID() string {
+	return p.current
+}
+
+func (p *localKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	key, err := newDataKey()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	wrapped, err := gcmSeal(p.keys[p.current], key, []byte(p.current))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return key, wrapped, p.current, nil
+}
+
+func (p *localKeyProvider) DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	return gcmOpen(master, wrapped, []byte(keyID))
+}
+
+// kmsClient is the slice of a KMS API envelope encryption needs, shaped like
+// AWS KMS / GCP Cloud KMS: the service generates data keys under a named
+// master key and the ciphertext blob it returns is all Decrypt needs.
+type kmsClient interface {
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext, ciphertextBlob []byte, err error)
+	Decrypt(ctx context.Context, ciphertextBlob []byte) (plaintext []byte, keyID string, err error)
+}
+
+// kmsKeyProvider leaves the master keys to a KMS; they never enter this
+// process.
+type kmsKeyProvider struct {
+	kms   kmsClient
+	keyID string
+}
+
+func newKMSKeyProvider(kms kmsClient, keyID string) *kmsKeyProvider {
+	return &kmsKeyProvider{kms: kms, keyID: keyID}
+}
+
+func (p *kmsKeyProvider) KeyID() string {
+	return p.keyID
+}
+
+func (p *kmsKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	key, blob, err := p.kms.GenerateDataKey(ctx, p.keyID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return key, blob, p.keyID, nil
+}
+
+func (p *kmsKeyProvider) DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, blobKeyID, err := p.kms.Decrypt(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if blobKeyID != keyID {
+		return nil, fmt.Errorf("data key was wrapped by %s, not %s", blobKeyID, keyID)
+	}
+	return key, nil
+}
+
+// memoryKMS stands in for a real KMS in development and tests. Its
+// ciphertext blobs carry the master key id the way a real service's do.
+type memoryKMS struct {
+	keys    map[string][]byte
+	current string
+}
+
+func newMemoryKMS(spec string) (*memoryKMS, error) {
+	keys, current, err := parseMasterKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryKMS{keys: keys, current: current}, nil
+}
+
+func (k *memoryKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	key, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed, err := gcmSeal(master, key, []byte(keyID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, append([]byte(keyID+":"), sealed...), nil
+}
+
+func (k *memoryKMS) Decrypt(ctx context.Context, blob []byte) ([]byte, string, error) {
+	keyID, sealed, ok := strings.Cut(string(blob), ":")
+	if !ok {
+		return nil, "", errors.New("malformed ciphertext blob")
+	}
+	master, found := k.keys[keyID]
+	if !found {
+		return nil, "", fmt.Errorf("unknown key %s", keyID)
+	}
+	key, err := gcmOpen(master, []byte(sealed), []byte(keyID))
+	if err != nil {
+		return nil, "", err
+	}
+	return key, keyID, nil
+}
@@ -1,20 +1,34 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethmath "github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
+const shutdownTimeout = time.Second * 10
+
+// how long a sender has to sign and post a message or prize with an issued nonce
+const signatureNonceTTL = time.Minute * 10
+
 type Prize struct {
     ID              string      `json:"id,omitempty"` // will be keccak256(sender, nonce)
     Sender          string      `json:"sender"`
@@ -29,16 +43,27 @@ type Prize struct {
     Amount          *big.Int    `json:"amount,omitempty"`
     Expires         int64       `json:"expires"`
     Active          bool        `json:"active,omitempty"`
+    // ClaimedBy is who unlocked the drop, from its DropUnlocked log
+    ClaimedBy       string      `json:"claimedBy,omitempty"`
+    // Hidden is set on reads when a moderator hid the prize or banned its sender
+    Hidden          bool        `json:"-"`
 }
 
 type Message struct {
     ID              int64       `json:"id,omitempty"`
     Sender          string      `json:"sender"`
     Text            []int16     `json:"text"`
+    // TemplateVersion is the catalogue version Text indexes into
+    TemplateVersion int         `json:"templateVersion,omitempty"`
     Latitude        float64     `json:"latitude"`
     Longitude       float64     `json:"longitude"`
     Expires         int64       `json:"expires,omitempty"`
     Active          bool        `json:"active,omitempty"`
+    // TTL is how long the sender wants the message to last, in seconds
+    TTL             int64       `json:"ttl,omitempty"`
+    // Helpful and Unhelpful count the appraisals the message has had
+    Helpful         int64       `json:"helpful"`
+    Unhelpful       int64       `json:"unhelpful"`
 }
 
 func normalizeAddress(address string) string {
@@ -106,6 +131,12 @@ type Delta struct {
     ID              string      `json:"id"`
     Direction       float64     `json:"direction"`
     Proximity       string      `json:"proximity"`
+    DistanceMeters  float64     `json:"distanceMeters"`
+    // Precise is set within the precise radius, where distance and direction
+    // are real, and InReach within the reveal distance. Clients go by these
+    // rather than the bucket labels, which are configurable.
+    Precise         bool        `json:"precise"`
+    InReach         bool        `json:"inReach"`
     Sender          string      `json:"sender"`
     Password        string      `json:"password,omitempty"`
     Message         []int8      `json:"message,omitempty"`
@@ -116,6 +147,11 @@ type Delta struct {
     Symbol          string      `json:"symbol,omitempty"`
     Amount          *big.Int    `json:"amount,omitempty"`
     Text            []int16     `json:"text,omitempty"`
+    TemplateVersion int         `json:"templateVersion,omitempty"`
+    // Rendered is Text written out from its catalogue
+    Rendered        string      `json:"rendered,omitempty"`
+    Helpful         int64       `json:"helpful,omitempty"`
+    Unhelpful       int64       `json:"unhelpful,omitempty"`
 } 
 
 type UserLocation struct {
@@ -123,100 +159,88 @@ type UserLocation struct {
     Longitude float64 `json:"longitude"`
 }
 
-func filterPrizeDeltas(userLocation UserLocation, prizes []Prize) []Delta{
+// filterPrizeDeltas builds the deltas for prizes within their type's radius
+// of userLocation, as seen by viewer, only revealing passwords within the
+// reveal distance when reveal is set.
+func filterPrizeDeltas(ctx context.Context, userLocation UserLocation, viewer string, prizes []Prize, reveal bool) []Delta{
     var deltas []Delta
 
     for _, prize := range prizes {
+        // drops already in a zone when it was drawn stay out of sight
+        if exclusionZoneAt(prize.Latitude, prize.Longitude) != nil {
+            continue
+        }
         distance, direction := getDistanceAndDirection(userLocation.Latitude, userLocation.Longitude, prize.Latitude, prize.Longitude)
-        proximity := "10km"
-        switch true {
-        case distance <= 0.01:
-            proximity = "<10m"
-        case distance <= 0.1:
-            proximity = "<100m"
-        case distance <= 0.25:
-            proximity = "<250m"
-        case distance <= 0.5:
-            proximity = "<500m"
-        case distance <= 1:
-            proximity = "<1km"
-        case distance <= 3:
-            proximity = "<3km"
-        case distance <= 5:
-            proximity = "<5km"
-        case distance <= 8:
-            proximity = "<8km"
-        case distance <= 10:
-            proximity = "<10km"
+        meters := distance * 1000
+        if meters > proximity.radius(prize.Type) {
+            continue
+        }
+        bucket, rounded, ok := proximity.place(meters)
+        if !ok {
+            continue
+        }
+
+        delta := Delta{
+            ID:        prize.ID,
+            Direction: proximity.direction(directionSecret, prize.ID, viewer, userLocation.Latitude, userLocation.Longitude, direction, meters, bucket),
+            Proximity: bucket.Label,
+            DistanceMeters: rounded,
+            Precise: meters <= proximity.PreciseMeters,
+            InReach: meters <= proximity.RevealMeters,
+            HashedPassword: prize.HashedPassword,
+            Sender: prize.Sender,
+            Type: prize.Type,
+            ContractAddress: prize.ContractAddress,
+            Name: prize.Name,
+            Symbol: prize.Symbol,
+            Amount: prize.Amount,
         }
 
-        if isWithinDistance(userLocation.Latitude, userLocation.Longitude, prize.Latitude, prize.Longitude, 0.01) {
-            deltas = append(deltas, Delta{
-                ID:        prize.ID,
-                Direction: direction,
-                Proximity: proximity,
-                Password: prize.Password,
-                HashedPassword: prize.HashedPassword,
-                Sender: prize.Sender,
-                Type: prize.Type,
-                ContractAddress: prize.ContractAddress,
-                Name: prize.Name,
-                Symbol: prize.Symbol,
-                Amount: prize.Amount,
-            })
-        } else {
-            deltas = append(deltas, Delta{
-                ID:        prize.ID,
-                Direction: direction,
-                Proximity: proximity,
-                HashedPassword: prize.HashedPassword,
-                Sender: prize.Sender,
-                Type: prize.Type,
-                ContractAddress: prize.ContractAddress,
-                Name: prize.Name,
-                Symbol: prize.Symbol,
-                Amount: prize.Amount,
-            })
+        if reveal && meters <= proximity.RevealMeters {
+            // passwords stay sealed until someone is standing on the prize
+            password, err := openPassword(ctx, passwordKeys, prize.ID, prize.Password)
+            if err != nil {
+                Sugar.Errorf("can't open password for prize %s: %s", prize.ID, err.Error())
+            }
+            delta.Password = password
         }
 
+        deltas = append(deltas, delta)
     }
     return deltas
 }
 
-func filterMessages(userLocation UserLocation, messages []Message) []Delta{
+// filterMessages builds the deltas for messages, rendered in the language
+// that best suits acceptLanguage.
+func filterMessages(userLocation UserLocation, viewer, acceptLanguage string, messages []Message) []Delta{
     var deltas []Delta
 
     for _, message := range messages {
+        if exclusionZoneAt(message.Latitude, message.Longitude) != nil {
+            continue
+        }
         distance, direction := getDistanceAndDirection(userLocation.Latitude, userLocation.Longitude, message.Latitude, message.Longitude)
-        proximity := "10km"
-        switch true {
-        case distance <= 0.01:
-            proximity = "<10m"
-        case distance <= 0.1:
-            proximity = "<100m"
-        case distance <= 0.25:
-            proximity = "<250m"
-        case distance <= 0.5:
-            proximity = "<500m"
-        case distance <= 1:
-            proximity = "<1km"
-        case distance <= 3:
-            proximity = "<3km"
-        case distance <= 5:
-            proximity = "<5km"
-        case distance <= 8:
-            proximity = "<8km"
-        case distance <= 10:
-            proximity = "<10km"
+        meters := distance * 1000
+        bucket, rounded, ok := proximity.place(meters)
+        if !ok {
+            continue
         }
 
+        id := strconv.Itoa(int(message.ID))
         deltas = append(deltas, Delta{
-            ID:        strconv.Itoa(int(message.ID)),
-            Direction: direction,
-            Proximity: proximity,
+            ID:        id,
+            Direction: proximity.direction(directionSecret, "message:"+id, viewer, userLocation.Latitude, userLocation.Longitude, direction, meters, bucket),
+            Proximity: bucket.Label,
+            DistanceMeters: rounded,
+            Precise: meters <= proximity.PreciseMeters,
+            InReach: meters <= proximity.RevealMeters,
             Type: "message",
             ContractAddress: message.Sender,
             Text: message.Text,
+            TemplateVersion: message.TemplateVersion,
+            Rendered: renderMessage(message, acceptLanguage),
+            Helpful: message.Helpful,
+            Unhelpful: message.Unhelpful,
         })
 
     }
@@ -225,7 +249,57 @@ func filterMessages(userLocation UserLocation, messages []Message) []Delta{
 }
 
 
-func getDelta(w http.ResponseWriter, r *http.Request) {
+// validateLocation checks a location report before anything is done with it.
+func validateLocation(userLocation UserLocation) error {
+    if !validCoordinates(userLocation.Latitude, userLocation.Longitude) {
+        return fmt.Errorf("Invalid coordinates")
+    }
+    return nil
+}
+
+// reportLocation feeds a location to the movement tracker and returns whether
+// passwords may be revealed there. Only signed in users are tracked, by
+// wallet; anyone else could start a fresh track wherever they liked, so
+// they never see passwords.
+func reportLocation(ctx context.Context, userLocation UserLocation, at time.Time) (bool, error) {
+    caller, ok := callerAddress(ctx)
+    if !ok {
+        return false, nil
+    }
+    return movement.report("address:"+normalizeAddress(caller.Hex()), userLocation.Latitude, userLocation.Longitude, at)
+}
+
+// viewerKey is who deltas are being built for: the signed in address, or the
+// client's IP for anyone else, which unlike a session id can't be changed at
+// will to get fresh direction noise.
+func viewerKey(r *http.Request) string {
+    if caller, ok := callerAddress(r.Context()); ok {
+        return "address:" + normalizeAddress(caller.Hex())
+    }
+    return "ip:" + limiter.clientIP(r)
+}
+
+// deltasAt builds every prize and message delta in range of userLocation,
+// with messages in the caller's Accept-Language.
+func (s *server) deltasAt(ctx context.Context, userLocation UserLocation, viewer, acceptLanguage string, reveal bool) ([]Delta, error) {
+    prizes, err := s.store.getPrizeLocksWithinRadius(userLocation.Latitude, userLocation.Longitude, proximity.prizeRadius()/1000)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve prize deltas: %w", err)
+    }
+
+    prizeDeltas := filterPrizeDeltas(ctx, userLocation, viewer, prizes, reveal)
+
+    messages, err := s.store.getMessagesWithinRadius(userLocation.Latitude, userLocation.Longitude, proximity.radius("message")/1000, messageHideScore)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve message deltas: %w", err)
+    }
+
+    messageDeltas := filterMessages(userLocation, viewer, acceptLanguage, messages)
+
+    return append(prizeDeltas, messageDeltas...), nil
+}
+
+func (s *server) getDelta(w http.ResponseWriter, r *http.Request) {
     var userLocation UserLocation
 
     if err := json.NewDecoder(r.Body).Decode(&userLocation); err != nil {
@@ -234,47 +308,248 @@ func getDelta(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if err := validateLocation(userLocation); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    prizes, err := getPrizeLocksWithinRadius(userLocation.Latitude, userLocation.Longitude, 10) // 10km (could be configurable)
+    reveal, err := reportLocation(r.Context(), userLocation, time.Now())
     if err != nil {
-        http.Error(w, "Failed to retrieve prize deltas", http.StatusInternalServerError)
-        Sugar.Error(err)
+        http.Error(w, fmt.Sprintf("Location rejected: %s", err.Error()), http.StatusUnprocessableEntity)
         return
     }
 
-   prizeDeltas := filterPrizeDeltas(userLocation, prizes)
-
-   messages, err := getMessagesWithinRadius(userLocation.Latitude, userLocation.Longitude, 8) //8km for messages
+    deltas, err := s.deltasAt(r.Context(), userLocation, viewerKey(r), r.Header.Get("Accept-Language"), reveal)
     if err != nil {
-        http.Error(w, "Failed to retrieve message deltas", http.StatusInternalServerError)
+        http.Error(w, "Failed to retrieve deltas", http.StatusInternalServerError)
         Sugar.Error(err)
         return
     }
 
-    messageDeltas := filterMessages(userLocation, messages)
-
-    deltas := append(prizeDeltas, messageDeltas...)
-
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(deltas)
 }
 
-func storePrizeLockHandler(w http.ResponseWriter, r *http.Request) {
-    var prize Prize
-    if err := json.NewDecoder(r.Body).Decode(&prize); err != nil {
+type PrizeInput struct {
+    Prize       Prize       `json:"prize"`
+    Nonce       string      `json:"nonce"`
+    Deadline    int64       `json:"deadline"`
+    Signature   string      `json:"signature"`
+}
+
+// contractAddress is the address the contract records; eth drops are posted
+// with "ETH" and stored on-chain with the zero address.
+func (p *Prize) contractAddress() common.Address {
+    if p.Type == "eth" {
+        return common.Address{}
+    }
+    return common.HexToAddress(p.ContractAddress)
+}
+
+// validate checks the fields a prize needs before its signature is worth
+// checking. The password must be the preimage the UI hashed with the sender.
+func (p *Prize) validate() error {
+    if !validCoordinates(p.Latitude, p.Longitude) {
+        return fmt.Errorf("invalid coordinates")
+    }
+    if len(common.FromHex(p.ID)) != 32 {
+        return fmt.Errorf("invalid id")
+    }
+    if !common.IsHexAddress(p.Sender) {
+        return fmt.Errorf("invalid sender")
+    }
+    if p.Type != "eth" && !common.IsHexAddress(p.ContractAddress) {
+        return fmt.Errorf("invalid contract address")
+    }
+    if p.Amount == nil || p.Amount.Sign() < 0 {
+        return fmt.Errorf("invalid amount")
+    }
+
+    password := common.FromHex(p.Password)
+    if len(password) != 32 {
+        return fmt.Errorf("invalid password")
+    }
+    hashed := crypto.Keccak256Hash(password, common.HexToAddress(p.Sender).Bytes())
+    if hashed != common.HexToHash(p.HashedPassword) {
+        return fmt.Errorf("password doesn't match hashed password")
+    }
+    return nil
+}
+
+// matchesDrop compares a prize with the drop the contract holds for its id.
+func (p *Prize) matchesDrop(drop dropLock) error {
+    if drop.Sender != common.HexToAddress(p.Sender) {
+        return fmt.Errorf("sender doesn't match")
+    }
+    if drop.HashedPassword != common.HexToHash(p.HashedPassword) {
+        return fmt.Errorf("hashed password doesn't match")
+    }
+    if drop.PrizeType != p.Type {
+        return fmt.Errorf("prize type doesn't match")
+    }
+    if drop.ContractAddress != p.contractAddress() {
+        return fmt.Errorf("contract address doesn't match")
+    }
+    if drop.Amount.Cmp(p.Amount) != 0 {
+        return fmt.Errorf("amount doesn't match")
+    }
+    return nil
+}
+
+// prizeTypedData is the EIP-712 payload a sender signs to store or edit a
+// prize. The password isn't in it, validate ties it to the hashed password.
+func prizeTypedData(prizeInput PrizeInput) apitypes.TypedData {
+    prize := prizeInput.Prize
+    return apitypes.TypedData{
+        Types: apitypes.Types{
+            "EIP712Domain": eip712DomainType,
+            "Prize": {
+                {Name: "id", Type: "bytes32"},
+                {Name: "sender", Type: "address"},
+                {Name: "latitude", Type: "int32"},
+                {Name: "longitude", Type: "int32"},
+                {Name: "hashedPassword", Type: "bytes32"},
+                {Name: "prizeType", Type: "string"},
+                {Name: "contractAddress", Type: "address"},
+                {Name: "name", Type: "string"},
+                {Name: "symbol", Type: "string"},
+                {Name: "amount", Type: "uint256"},
+                {Name: "expires", Type: "uint256"},
+                {Name: "nonce", Type: "bytes32"},
+                {Name: "deadline", Type: "uint256"},
+            },
+        },
+        PrimaryType: "Prize",
+        Domain: typedDataDomain(),
+        Message: apitypes.TypedDataMessage{
+            "id":              prize.ID,
+            "sender":          prize.Sender,
+            "latitude":        strconv.FormatInt(microdegrees(prize.Latitude), 10),
+            "longitude":       strconv.FormatInt(microdegrees(prize.Longitude), 10),
+            "hashedPassword":  prize.HashedPassword,
+            "prizeType":       prize.Type,
+            "contractAddress": prize.contractAddress().Hex(),
+            "name":            prize.Name,
+            "symbol":          prize.Symbol,
+            "amount":          prize.Amount.String(),
+            "expires":         strconv.FormatInt(prize.Expires, 10),
+            "nonce":           prizeInput.Nonce,
+            "deadline":        strconv.FormatInt(prizeInput.Deadline, 10),
+        },
+    }
+}
+
+func (s *server) storePrizeLockHandler(w http.ResponseWriter, r *http.Request) {
+    var prizeInput PrizeInput
+    if err := json.NewDecoder(r.Body).Decode(&prizeInput); err != nil {
         http.Error(w, "Invalid request payload", http.StatusBadRequest)
         Sugar.Error(err)
         return
     }
 
+    prize := prizeInput.Prize
+    if err := prize.validate(); err != nil {
+        http.Error(w, fmt.Sprintf("Invalid prize: %s", err.Error()), http.StatusBadRequest)
+        return
+    }
+
+    if !checkNotExcluded(w, prize.Latitude, prize.Longitude) {
+        return
+    }
+
+    if prizeInput.Deadline < time.Now().Unix() {
+        http.Error(w, "Signature expired", http.StatusBadRequest)
+        return
+    }
+
+    verifyResult, err := verifyTypedDataSignature(r.Context(), s.chain, common.HexToAddress(prize.Sender), prizeTypedData(prizeInput), common.FromHex(prizeInput.Signature))
+    if err != nil {
+        http.Error(w, "Invalid signature", http.StatusBadRequest)
+        Sugar.Error(err)
+        return
+    }
+
+    if !verifyResult {
+        http.Error(w, "Signature must be signed by sender", http.StatusBadRequest)
+        return
+    }
+
+    if !s.checkNotBanned(w, prize.Sender) {
+        return
+    }
+
+    // the UI posts before sending the lock tx, so the drop may not exist yet;
+    // once it does it has to agree with what's stored here
+    drop, err := s.chain.getDropLock(r.Context(), common.HexToHash(prize.ID))
+    if err != nil {
+        http.Error(w, "Failed to read drop", http.StatusInternalServerError)
+        Sugar.Error(err)
+        return
+    }
+
+    onChain := drop.Sender != (common.Address{})
+    if onChain {
+        if err := prize.matchesDrop(drop); err != nil {
+            http.Error(w, fmt.Sprintf("Prize doesn't match on-chain drop: %s", err.Error()), http.StatusBadRequest)
+            return
+        }
+    }
+
     prize.normalizePrizeAddresses()
-    prize.Active = false
+    prize.Sender = normalizeAddress(prize.Sender)
+    prize.HashedPassword = normalizeAddress(prize.HashedPassword)
 
-    if err := upsertPrizeLockToDB(prize); err != nil {
+    existing, found, err := s.store.getPrizeLockByID(prize.ID)
+    if err != nil {
+        http.Error(w, "Failed to read prize", http.StatusInternalServerError)
+        Sugar.Error(err)
+        return
+    }
+
+    // drop ids are predictable, so someone else may have posted this one
+    // first; once it's on-chain the chain says whose it is and their row goes
+    if found && existing.Sender != prize.Sender {
+        if !onChain {
+            http.Error(w, "Prize belongs to another sender", http.StatusForbidden)
+            return
+        }
+        found = false
+    }
+
+    if found && existing.Active && (existing.Latitude != prize.Latitude || existing.Longitude != prize.Longitude) {
+        http.Error(w, "Can't move an active prize", http.StatusConflict)
+        return
+    }
+
+    used, err := s.store.useSignatureNonce(prize.Sender, prizeInput.Nonce)
+    if err != nil {
+        http.Error(w, "Nonce error", http.StatusInternalServerError)
+        Sugar.Error(err)
+        return
+    }
+
+    if !used {
+        http.Error(w, "Nonce already used or expired", http.StatusBadRequest)
+        return
+    }
+
+    // a drop that's already on-chain may have had its DropAdded applied
+    // before this row existed
+    prize.Active = onChain
+
+    prize.Password, err = sealPassword(r.Context(), passwordKeys, prize.ID, prize.Password)
+    if err != nil {
+        http.Error(w, "Failed to store prize", http.StatusInternalServerError)
+        Sugar.Error(err)
+        return
+    }
+
+    if err := s.store.upsertPrizeLock(prize); err != nil {
         http.Error(w, "Failed to store prize", http.StatusInternalServerError)
         Sugar.Error(err)
         return
     }
+    deltaUpdates.notify()
 
     w.WriteHeader(http.StatusCreated)
     w.Write([]byte("Prize stored successfully"))
@@ -282,110 +557,349 @@ func storePrizeLockHandler(w http.ResponseWriter, r *http.Request) {
 
 type MessageInput struct {
     Message     Message     `json:"message"`
+    Nonce       string      `json:"nonce"`
+    Deadline    int64       `json:"deadline"`
     Signature   string      `json:"signature"`
 }
 
-type VerifyResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+type SignatureNonce struct {
+    Nonce       string      `json:"nonce"`
+    Deadline    int64       `json:"deadline"`
 }
 
-func verifySig(msgInput MessageInput) (bool, error) {
-    msg := fmt.Sprintf("%s%.3f%.3f", msgInput.Message.Sender, msgInput.Message.Latitude, msgInput.Message.Longitude)
+// coordinates are signed as integer microdegrees, the UI rounds them to 6dp
+// before signing so both sides agree on the value
+func microdegrees(coordinate float64) int64 {
+    return int64(math.Round(coordinate * 1e6))
+}
 
-	reqBody := struct{
-        Address string `json:"address"`
-        Message string `json:"message"`
-        Signature string `json:"signature"`
-        }{
-		Address:   msgInput.Message.Sender,
-		Message:   msg,
-		Signature: msgInput.Signature,
-	}
+var eip712DomainType = []apitypes.Type{
+    {Name: "name", Type: "string"},
+    {Name: "version", Type: "string"},
+    {Name: "chainId", Type: "uint256"},
+}
 
-	jsonReqBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return false, err
-	}
+func typedDataDomain() apitypes.TypedDataDomain {
+    return apitypes.TypedDataDomain{
+        Name:    "dropwhere",
+        Version: "1",
+        ChainId: (*ethmath.HexOrDecimal256)(chainID),
+    }
+}
 
-	resp, err := http.Post(fmt.Sprintf("%s/verify", os.Getenv("SIG_VERIFY_HOST")), "application/json", bytes.NewBuffer(jsonReqBody))
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
+// messageTypedData is the EIP-712 payload a sender signs to drop a message.
+// It covers the text and location, plus a nonce issued by GET /messages/nonce
+// and a deadline so a signature can only be used once and only for a while.
+// Integers go in as decimal strings, apitypes rewrites negative *big.Int
+// values in place while hashing.
+func messageTypedData(msgInput MessageInput) apitypes.TypedData {
+    text := make([]interface{}, len(msgInput.Message.Text))
+    for i, t := range msgInput.Message.Text {
+        text[i] = strconv.Itoa(int(t))
+    }
 
-	var verifyResp VerifyResponse
-	err = json.NewDecoder(resp.Body).Decode(&verifyResp)
-	if err != nil {
-		return false, err
-	}
+    return apitypes.TypedData{
+        Types: apitypes.Types{
+            "EIP712Domain": eip712DomainType,
+            "Message": {
+                {Name: "sender", Type: "address"},
+                {Name: "text", Type: "int16[]"},
+                {Name: "templateVersion", Type: "uint256"},
+                {Name: "latitude", Type: "int32"},
+                {Name: "longitude", Type: "int32"},
+                {Name: "ttl", Type: "uint256"},
+                {Name: "nonce", Type: "bytes32"},
+                {Name: "deadline", Type: "uint256"},
+            },
+        },
+        PrimaryType: "Message",
+        Domain: typedDataDomain(),
+        Message: apitypes.TypedDataMessage{
+            "sender":    msgInput.Message.Sender,
+            "text":      text,
+            "templateVersion": strconv.Itoa(msgInput.Message.TemplateVersion),
+            "latitude":  strconv.FormatInt(microdegrees(msgInput.Message.Latitude), 10),
+            "longitude": strconv.FormatInt(microdegrees(msgInput.Message.Longitude), 10),
+            "ttl":       strconv.FormatInt(msgInput.Message.TTL, 10),
+            "nonce":     msgInput.Nonce,
+            "deadline":  strconv.FormatInt(msgInput.Deadline, 10),
+        },
+    }
+}
 
-	if verifyResp.Success {
-		return true, nil
-	} else {
-		return false, nil
-	}
+func (s *server) nonceHandler(w http.ResponseWriter, r *http.Request) {
+    sender := r.URL.Query().Get("sender")
+    if !common.IsHexAddress(sender) {
+        http.Error(w, "Invalid sender", http.StatusBadRequest)
+        return
+    }
 
-}
+    nonce := make([]byte, 32)
+    if _, err := rand.Read(nonce); err != nil {
+        http.Error(w, "Nonce error", http.StatusInternalServerError)
+        Sugar.Error(err)
+        return
+    }
 
-func storeMessageHandler(w http.ResponseWriter, r *http.Request) {
-    var msgInput MessageInput
-    if err := json.NewDecoder(r.Body).Decode(&msgInput); err != nil {
-        http.Error(w, "Invalid request payload", http.StatusBadRequest)
+    issued := SignatureNonce{
+        Nonce:    hexutil.Encode(nonce),
+        Deadline: time.Now().Add(signatureNonceTTL).Unix(),
+    }
+
+    if err := s.store.insertSignatureNonce(normalizeAddress(sender), issued.Nonce, issued.Deadline); err != nil {
+        http.Error(w, "Nonce error", http.StatusInternalServerError)
         Sugar.Error(err)
         return
     }
-    
-    if msgInput.Signature == "" {
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(issued)
+}
+
+// checkMessageSignature checks msgInput's EIP-712 signature and uses up its
+// nonce, writing the error response and returning false if either fails.
+func (s *server) checkMessageSignature(w http.ResponseWriter, r *http.Request, msgInput MessageInput) bool {
+    return s.checkSignature(w, r, msgInput.Message.Sender, messageTypedData(msgInput), msgInput.Nonce, msgInput.Deadline, msgInput.Signature)
+}
+
+// checkSignature checks sender signed typedData and uses up the nonce it
+// carries, writing the error response and returning false if either fails.
+func (s *server) checkSignature(w http.ResponseWriter, r *http.Request, sender string, typedData apitypes.TypedData, nonce string, deadline int64, signature string) bool {
+    if signature == "" {
         http.Error(w, "Signature can't be empty", http.StatusBadRequest)
-        return
+        return false
     }
 
-    verifyResult, err := verifySig(msgInput) 
+    if deadline < time.Now().Unix() {
+        http.Error(w, "Signature expired", http.StatusBadRequest)
+        return false
+    }
+
+    if !common.IsHexAddress(sender) {
+        http.Error(w, "Invalid sender", http.StatusBadRequest)
+        return false
+    }
+
+    verifyResult, err := verifyTypedDataSignature(r.Context(), s.chain, common.HexToAddress(sender), typedData, common.FromHex(signature))
     if err != nil {
         http.Error(w, "Invalid signature", http.StatusBadRequest)
         Sugar.Error(err)
-        return
+        return false
     }
 
     if !verifyResult {
         http.Error(w, "Signature must be signed by sender", http.StatusBadRequest)
+        return false
+    }
+
+    used, err := s.store.useSignatureNonce(normalizeAddress(sender), nonce)
+    if err != nil {
+        http.Error(w, "Nonce error", http.StatusInternalServerError)
         Sugar.Error(err)
+        return false
+    }
+
+    if !used {
+        http.Error(w, "Nonce already used or expired", http.StatusBadRequest)
+        return false
+    }
+    return true
+}
+
+func (s *server) storeMessageHandler(w http.ResponseWriter, r *http.Request) {
+    var msgInput MessageInput
+    if err := json.NewDecoder(r.Body).Decode(&msgInput); err != nil {
+        http.Error(w, "Invalid request payload", http.StatusBadRequest)
+        Sugar.Error(err)
+        return
+    }
+
+    if !validCoordinates(msgInput.Message.Latitude, msgInput.Message.Longitude) {
+        http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+        return
+    }
+
+    if !checkNotExcluded(w, msgInput.Message.Latitude, msgInput.Message.Longitude) {
+        return
+    }
+
+    ttl, err := messageTTLs.resolve(msgInput.Message.TTL)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    version, err := checkMessageText(msgInput.Message.Text, msgInput.Message.TemplateVersion)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Invalid message: %s", err.Error()), http.StatusBadRequest)
+        return
+    }
+
+    // a signed in sender doesn't have to sign every message
+    caller, signedIn := callerAddress(r.Context())
+    if !signedIn || !common.IsHexAddress(msgInput.Message.Sender) || common.HexToAddress(msgInput.Message.Sender) != caller {
+        if !s.checkMessageSignature(w, r, msgInput) {
+            return
+        }
+    }
+
+    if !s.checkNotBanned(w, msgInput.Message.Sender) {
         return
     }
 
     msgInput.Message.Sender = normalizeAddress(msgInput.Message.Sender)
+    msgInput.Message.Expires = time.Now().Add(ttl).Unix()
+    msgInput.Message.TemplateVersion = version
 
-    id, err := insertMessageToDB(msgInput.Message)
+    id, err := s.store.insertMessage(msgInput.Message)
     if err != nil {
         http.Error(w, "Insert Message error", http.StatusInternalServerError)
         Sugar.Error(err)
         return
     }
+    deltaUpdates.notify()
 
     w.WriteHeader(http.StatusCreated)
     w.Write([]byte(fmt.Sprintf("id: %d", id)))
 }
 
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Indexer indexerStatus `json:"indexer"`
+    }{
+        Indexer: indexer.snapshot(),
+    })
+}
+
+// reencrypt seals any plaintext passwords and moves the rest onto the
+// current key, run it after adding a key to the front of the key list.
+func reencrypt(store Store) {
+    n, err := reencryptPasswords(context.Background(), store, passwordKeys)
+    if err != nil {
+        Sugar.Fatal(err)
+    }
+    Sugar.Infof("re-encrypted %d passwords with key %s", n, passwordKeys.KeyID())
+}
+
+// routes is every endpoint, behind the session and rate limit middleware.
+func (s *server) routes(origins []string) *mux.Router {
+    r := mux.NewRouter()
+    r.HandleFunc("/delta", s.getDelta).Methods("POST")
+    r.Handle("/delta/stream", &deltaStream{origins: origins, deltas: s.deltasAt, viewer: viewerKey}).Methods("GET")
+    r.HandleFunc("/prizes", s.storePrizeLockHandler).Methods("POST")
+    r.HandleFunc("/prizes", s.listPrizesHandler).Methods("GET")
+    r.HandleFunc("/prizes/nonce", s.nonceHandler).Methods("GET")
+    r.HandleFunc("/prizes/{id:0x[0-9a-fA-F]{64}}", s.getPrizeHandler).Methods("GET")
+    r.HandleFunc("/prizes/{id:0x[0-9a-fA-F]{64}}/events", s.listDropLogsHandler).Methods("GET")
+    r.HandleFunc("/messages", s.storeMessageHandler).Methods("POST")
+    r.HandleFunc("/messages", s.listMessagesHandler).Methods("GET")
+    r.HandleFunc("/messages/{id:[0-9]+}", s.editMessageHandler).Methods("PUT")
+    r.HandleFunc("/messages/{id:[0-9]+}", s.deleteMessageHandler).Methods("DELETE")
+    r.HandleFunc("/messages/{id:[0-9]+}/appraisals", s.appraiseMessageHandler).Methods("POST")
+    r.HandleFunc("/messages/nonce", s.nonceHandler).Methods("GET")
+    r.HandleFunc("/status", statusHandler).Methods("GET")
+    r.HandleFunc("/templates", templatesHandler).Methods("GET")
+    r.HandleFunc("/reports", s.reportHandler).Methods("POST")
+    r.HandleFunc("/auth/nonce", s.loginNonceHandler).Methods("GET")
+    r.HandleFunc("/auth/login", s.loginHandler).Methods("POST")
+    r.HandleFunc("/auth/logout", logoutHandler).Methods("POST")
+    r.HandleFunc("/auth/session", sessionHandler).Methods("GET")
+
+    admin := r.PathPrefix("/admin").Subrouter()
+    admin.Use(adminMiddleware)
+    admin.HandleFunc("/reports", s.listReportsHandler).Methods("GET")
+    admin.HandleFunc("/moderation", s.moderateHandler).Methods("PUT")
+    admin.HandleFunc("/bans", s.listBansHandler).Methods("GET")
+    admin.HandleFunc("/bans/{address}", s.banHandler).Methods("PUT")
+    admin.HandleFunc("/bans/{address}", s.unbanHandler).Methods("DELETE")
+    admin.HandleFunc("/zones", s.listZonesHandler).Methods("GET")
+    admin.HandleFunc("/zones", s.importZonesHandler).Methods("POST")
+    admin.HandleFunc("/zones/{id:[0-9]+}", s.deleteZoneHandler).Methods("DELETE")
+
+    r.Use(sessionMiddleware)
+    r.Use(limiter.middleware)
+    return r
+}
+
 func main() {
     initLogger()
-    initDB()
-    initClient()
+
+    // migrate runs before the store opens, which would migrate up itself
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        migrate(os.Args[2:])
+        return
+    }
+
+    store := openStore()
+    initKeys()
+
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "reencrypt":
+            reencrypt(store)
+        default:
+            Sugar.Fatalf("unknown command %s", os.Args[1])
+        }
+        store.close()
+        return
+    }
+
+    initClient(store)
+    movement = newMovementTracker(movementConfigFromEnv(), store)
+    initSessions()
+    initProximity()
+    initMessages()
+    initModeration()
+    limiter = newRateLimiterFromEnv(store)
+    deltaUpdates = newDeltaHub()
+    indexer.changed = deltaUpdates.notify
     port := os.Getenv("SERVER_PORT")
     allowedHosts := os.Getenv("ALLOWED_HOSTS")
     origins := strings.Split(allowedHosts, ",")
-    r := mux.NewRouter()
-    r.HandleFunc("/delta", getDelta).Methods("POST")
-    r.HandleFunc("/prizes", storePrizeLockHandler).Methods("POST")
-    r.HandleFunc("/messages", storeMessageHandler).Methods("POST")
+    s := &server{store: store, chain: nodeChain{Client: client, contract: dmContract}}
+    r := s.routes(origins)
 
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
 	originsOk := handlers.AllowedOrigins(origins)
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
+    exposedOk := handlers.ExposedHeaders([]string{"Retry-After"})
 
-    go listenForLocks()
-    go listenForUnlocks()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    indexerDone := make(chan struct{})
+    go func() {
+        indexer.run(ctx)
+        close(indexerDone)
+    }()
+
+    go messageExpirerFromEnv(store).run(ctx)
+    if err := loadExclusionZones(store); err != nil {
+        Sugar.Fatal(err)
+    }
+    go zoneRefresherFromEnv(store).run(ctx)
 
-    Sugar.Infof("Server is running on port %s", port)
-    Sugar.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), handlers.CORS(originsOk, headersOk, methodsOk)(r)))
+    srv := &http.Server{
+        Addr:    fmt.Sprintf(":%s", port),
+        Handler: handlers.CORS(originsOk, headersOk, methodsOk, exposedOk, handlers.AllowCredentials())(r),
+    }
+    srv.RegisterOnShutdown(deltaUpdates.close)
+    go func() {
+        Sugar.Infof("Server is running on port %s", port)
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            Sugar.Fatal(err)
+        }
+    }()
+
+    <-ctx.Done()
+    Sugar.Info("shutting down")
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        Sugar.Error(err)
+    }
+    <-indexerDone
+    store.close()
 }
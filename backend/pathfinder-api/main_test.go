@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
 	"math/big"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"go.uber.org/zap"
 )
 
 func TestNormalizeAddress(t *testing.T) {
@@ -81,7 +92,7 @@ func TestFilterPrizeDeltas(t *testing.T) {
 		Active:          true,
 	}
 	prizes := []Prize{prize}
-	deltas := filterPrizeDeltas(userLocation, prizes)
+	deltas := filterPrizeDeltas(context.Background(), userLocation, "", prizes, true)
 	if len(deltas) != 1 {
 		t.Errorf("filterPrizeDeltas() = %d; want %d", len(deltas), 1)
 	}
@@ -90,3 +101,188 @@ func TestFilterPrizeDeltas(t *testing.T) {
 	}
 }
 
+
+func TestMessageTypedData(t *testing.T) {
+	chainID = big.NewInt(1337)
+	chain := newTestChain(t)
+	key, _ := crypto.GenerateKey()
+	msgInput := MessageInput{
+		Message: Message{
+			Sender:          crypto.PubkeyToAddress(key.PublicKey).Hex(),
+			Text:            []int16{3, 0, 12},
+			TemplateVersion: 1,
+			Latitude:        51.469123,
+			Longitude:       -0.040456,
+			TTL:             3600,
+		},
+		Nonce:    "0x" + strings.Repeat("ab", 32),
+		Deadline: 1700000000,
+	}
+
+	typedData := messageTypedData(msgInput)
+	wantType := "Message(address sender,int16[] text,uint256 templateVersion,int32 latitude,int32 longitude,uint256 ttl,bytes32 nonce,uint256 deadline)"
+	if got := typedData.TypeHash("Message"); !bytes.Equal(got, crypto.Keccak256([]byte(wantType))) {
+		t.Errorf("messageTypedData() type hash doesn't match %s", wantType)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := common.HexToAddress(msgInput.Message.Sender)
+	ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, typedData, sig)
+	if err != nil || !ok {
+		t.Fatalf("verifyTypedDataSignature() = %v, %v; want true", ok, err)
+	}
+
+	tampered := []func(m *MessageInput){
+		func(m *MessageInput) { m.Message.Text = []int16{3, 0, 13} },
+		func(m *MessageInput) { m.Message.Latitude += 0.00001 },
+		func(m *MessageInput) { m.Message.Longitude -= 0.00001 },
+		func(m *MessageInput) { m.Message.TTL = 7200 },
+		func(m *MessageInput) { m.Message.TemplateVersion = 2 },
+		func(m *MessageInput) { m.Nonce = "0x" + strings.Repeat("cd", 32) },
+		func(m *MessageInput) { m.Deadline++ },
+	}
+	for i, tamper := range tampered {
+		changed := msgInput
+		tamper(&changed)
+		ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, messageTypedData(changed), sig)
+		if err != nil || ok {
+			t.Errorf("verifyTypedDataSignature() with change %d = %v, %v; want false", i, ok, err)
+		}
+	}
+}
+
+func testPrize() (Prize, *ecdsa.PrivateKey) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	password := crypto.Keccak256([]byte("password"))
+	return Prize{
+		ID:              crypto.Keccak256Hash(sender.Bytes(), common.LeftPadBytes(nil, 32)).Hex(),
+		Sender:          sender.Hex(),
+		Latitude:        51.469123,
+		Longitude:       -0.040456,
+		Password:        hexutil.Encode(password),
+		HashedPassword:  crypto.Keccak256Hash(password, sender.Bytes()).Hex(),
+		Type:            "erc20",
+		ContractAddress: "0xDef4567890abcdef1234567890abcdef12345678",
+		Name:            "SampleToken",
+		Symbol:          "STK",
+		Amount:          big.NewInt(1000),
+		Expires:         1700000000000,
+	}, key
+}
+
+func TestPrizeValidate(t *testing.T) {
+	prize, _ := testPrize()
+	if err := prize.validate(); err != nil {
+		t.Fatalf("validate() = %v; want nil", err)
+	}
+
+	invalid := []func(p *Prize){
+		func(p *Prize) { p.Latitude = 91 },
+		func(p *Prize) { p.ID = "0x1234" },
+		func(p *Prize) { p.Sender = "me" },
+		func(p *Prize) { p.ContractAddress = "ETH" },
+		func(p *Prize) { p.Amount = nil },
+		func(p *Prize) { p.Password = hexutil.Encode(crypto.Keccak256([]byte("guess"))) },
+	}
+	for i, change := range invalid {
+		changed := prize
+		change(&changed)
+		if err := changed.validate(); err == nil {
+			t.Errorf("validate() with change %d = nil; want error", i)
+		}
+	}
+
+	eth := prize
+	eth.Type, eth.ContractAddress = "eth", "ETH"
+	if err := eth.validate(); err != nil {
+		t.Errorf("validate() of an eth prize = %v; want nil", err)
+	}
+}
+
+func TestPrizeMatchesDrop(t *testing.T) {
+	prize, _ := testPrize()
+	drop := dropLock{
+		Sender:          common.HexToAddress(prize.Sender),
+		HashedPassword:  common.HexToHash(prize.HashedPassword),
+		PrizeType:       "erc20",
+		ContractAddress: common.HexToAddress(prize.ContractAddress),
+		Amount:          big.NewInt(1000),
+	}
+	if err := prize.matchesDrop(drop); err != nil {
+		t.Fatalf("matchesDrop() = %v; want nil", err)
+	}
+
+	mismatched := []func(d *dropLock){
+		func(d *dropLock) { d.Sender = common.Address{1} },
+		func(d *dropLock) { d.HashedPassword = common.Hash{1} },
+		func(d *dropLock) { d.PrizeType = "erc721" },
+		func(d *dropLock) { d.ContractAddress = common.Address{} },
+		func(d *dropLock) { d.Amount = big.NewInt(999) },
+	}
+	for i, change := range mismatched {
+		changed := drop
+		change(&changed)
+		if err := prize.matchesDrop(changed); err == nil {
+			t.Errorf("matchesDrop() with change %d = nil; want error", i)
+		}
+	}
+
+	eth := prize
+	eth.Type, eth.ContractAddress = "eth", "ETH"
+	drop.PrizeType, drop.ContractAddress = "eth", common.Address{}
+	if err := eth.matchesDrop(drop); err != nil {
+		t.Errorf("matchesDrop() of an eth prize = %v; want nil", err)
+	}
+}
+
+func TestPrizeTypedData(t *testing.T) {
+	chainID = big.NewInt(1337)
+	chain := newTestChain(t)
+	prize, key := testPrize()
+	prizeInput := PrizeInput{Prize: prize, Nonce: "0x" + strings.Repeat("ab", 32), Deadline: 1700000000}
+
+	hash, _, err := apitypes.TypedDataAndHash(prizeTypedData(prizeInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := common.HexToAddress(prize.Sender)
+	ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, prizeTypedData(prizeInput), sig)
+	if err != nil || !ok {
+		t.Fatalf("verifyTypedDataSignature() = %v, %v; want true", ok, err)
+	}
+
+	tampered := []func(p *PrizeInput){
+		func(p *PrizeInput) { p.Prize.Latitude += 0.00001 },
+		func(p *PrizeInput) { p.Prize.HashedPassword = common.Hash{1}.Hex() },
+		func(p *PrizeInput) { p.Prize.ContractAddress = common.Address{1}.Hex() },
+		func(p *PrizeInput) { p.Prize.Amount = big.NewInt(1001) },
+		func(p *PrizeInput) { p.Nonce = "0x" + strings.Repeat("cd", 32) },
+	}
+	for i, tamper := range tampered {
+		changed := prizeInput
+		tamper(&changed)
+		ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, prizeTypedData(changed), sig)
+		if err != nil || ok {
+			t.Errorf("verifyTypedDataSignature() with change %d = %v, %v; want false", i, ok, err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	Sugar = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store held in process, mirroring the SQL postgresStore
+// runs statement for statement.
+type memoryStore struct {
+	mu sync.Mutex
+
+	prizes  map[string]*memoryPrize
+	cursors map[string]uint64
+	tracks  map[string]movementTrack
+	// dropLogs are by block number, then log index
+	dropLogs        map[[2]uint64]DropLog
+	signatureNonces map[string]memoryNonce
+	loginNonces     map[string]memoryNonce
+
+	messages      map[int64]*memoryMessage
+	nextMessageID int64
+	// appraisals are by message, then appraiser
+	appraisals map[int64]map[string]bool
+
+	reports      []*Report
+	nextReportID int64
+	moderation   map[memoryTarget]bool
+	bans         map[string]Ban
+
+	zones      []exclusionZone
+	nextZoneID int64
+}
+
+type memoryPrize struct {
+	Prize
+	// the indexer's last applied log, as event_block and event_index
+	block *uint64
+	index uint
+}
+
+type memoryNonce struct {
+	sender  string
+	expires int64
+	used    bool
+}
+
+type memoryMessage struct {
+	Message
+	deletedAt int64
+}
+
+type memoryTarget struct {
+	targetType, targetID string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		prizes:          map[string]*memoryPrize{},
+		cursors:         map[string]uint64{},
+		tracks:          map[string]movementTrack{},
+		dropLogs:        map[[2]uint64]DropLog{},
+		signatureNonces: map[string]memoryNonce{},
+		loginNonces:     map[string]memoryNonce{},
+		messages:        map[int64]*memoryMessage{},
+		appraisals:      map[int64]map[string]bool{},
+		moderation:      map[memoryTarget]bool{},
+		bans:            map[string]Ban{},
+	}
+}
+
+func (s *memoryStore) close() error {
+	return nil
+}
+
+func (s *memoryStore) upsertPrizeLock(prize Prize) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prize.Amount = new(big.Int).Set(prize.Amount)
+	// only the indexer says who claimed a prize
+	prize.ClaimedBy = ""
+	if existing, ok := s.prizes[prize.ID]; ok {
+		prize.Active = existing.Active || prize.Active
+		prize.ClaimedBy = existing.ClaimedBy
+		existing.Prize = prize
+		return nil
+	}
+	s.prizes[prize.ID] = &memoryPrize{Prize: prize}
+	return nil
+}
+
+func (s *memoryStore) getPrizeLockByID(id string) (Prize, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prizes[id]
+	if !ok {
+		return Prize{}, false, nil
+	}
+	return Prize{ID: p.ID, Sender: p.Sender, Latitude: p.Latitude, Longitude: p.Longitude, Active: p.Active}, true, nil
+}
+
+func (s *memoryStore) getPrize(id string) (Prize, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prizes[id]
+	if !ok {
+		return Prize{}, false, nil
+	}
+	prize := p.Prize
+	prize.Amount = new(big.Int).Set(p.Amount)
+	prize.Hidden = s.hidden("prize", p.ID, p.Sender)
+	return prize, true, nil
+}
+
+func (s *memoryStore) getPrizesBySender(sender, after string, limit int) ([]Prize, error) {
+	return s.pagePrizes(func(p *memoryPrize) bool { return p.Sender == sender }, after, limit), nil
+}
+
+func (s *memoryStore) getPrizesClaimedBy(claimer, after string, limit int) ([]Prize, error) {
+	return s.pagePrizes(func(p *memoryPrize) bool { return p.ClaimedBy == claimer }, after, limit), nil
+}
+
+// pagePrizes is up to limit of the prizes matching keep with ids after
+// after, in id order.
+func (s *memoryStore) pagePrizes(keep func(*memoryPrize) bool, after string, limit int) []Prize {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prizes []Prize
+	for _, p := range s.prizes {
+		if p.ID > after && keep(p) {
+			prize := p.Prize
+			prize.Amount = new(big.Int).Set(p.Amount)
+			prize.Hidden = s.hidden("prize", p.ID, p.Sender)
+			prizes = append(prizes, prize)
+		}
+	}
+	sort.Slice(prizes, func(i, j int) bool { return prizes[i].ID < prizes[j].ID })
+	if len(prizes) > limit {
+		prizes = prizes[:limit]
+	}
+	return prizes
+}
+
+// hidden is the moderated filter: hidden by an admin or from a banned sender.
+func (s *memoryStore) hidden(targetType, targetID, sender string) bool {
+	_, banned := s.bans[sender]
+	return banned || s.moderation[memoryTarget{targetType, targetID}]
+}
+
+func (s *memoryStore) getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	var prizes []Prize
+	for _, p := range s.prizes {
+		if !p.Active || p.Expires <= now || s.hidden("prize", p.ID, p.Sender) {
+			continue
+		}
+		if distance, _ := haversine(lat, lon, p.Latitude, p.Longitude); distance <= radius {
+			prize := p.Prize
+			prize.Amount = new(big.Int).Set(p.Amount)
+			prizes = append(prizes, prize)
+		}
+	}
+	return prizes, nil
+}
+
+func (s *memoryStore) getStoredPasswords() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	passwords := map[string]string{}
+	for id, p := range s.prizes {
+		if p.Password != "" {
+			passwords[id] = p.Password
+		}
+	}
+	return passwords, nil
+}
+
+func (s *memoryStore) replaceStoredPassword(id, old, new string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prizes[id]
+	if !ok || p.Password != old {
+		return false, nil
+	}
+	p.Password = new
+	return true, nil
+}
+
+func (s *memoryStore) updatePrizeLockFields(pType, sender, hashedPassword, id string, active bool, claimedBy string, block uint64, index uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prizes[id]
+	if !ok {
+		return nil
+	}
+	if active && (p.Sender != sender || strings.ToLower(p.HashedPassword) != hashedPassword) {
+		return nil
+	}
+	if p.block == nil || *p.block < block || (*p.block == block && p.index < index) {
+		p.Type, p.Sender, p.Active, p.ClaimedBy, p.block, p.index = pType, sender, active, claimedBy, &block, index
+	}
+	return nil
+}
+
+func (s *memoryStore) revertPrizeLockFields(id string, active bool, block uint64, index uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prizes[id]
+	if ok && p.block != nil && *p.block == block && p.index == index {
+		p.Active, p.ClaimedBy, p.block, p.index = active, "", nil, 0
+	}
+	return nil
+}
+
+func (s *memoryStore) insertDropLog(log DropLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLogs[[2]uint64{log.Block, uint64(log.LogIndex)}] = log
+	return nil
+}
+
+func (s *memoryStore) deleteDropLog(block uint64, index uint, txHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at := [2]uint64{block, uint64(index)}
+	if log, ok := s.dropLogs[at]; ok && log.TxHash == txHash {
+		delete(s.dropLogs, at)
+	}
+	return nil
+}
+
+func (s *memoryStore) getDropLogs(dropID string) ([]DropLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var logs []DropLog
+	for _, log := range s.dropLogs {
+		if log.DropID == dropID {
+			logs = append(logs, log)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].Block != logs[j].Block {
+			return logs[i].Block < logs[j].Block
+		}
+		return logs[i].LogIndex < logs[j].LogIndex
+	})
+	return logs, nil
+}
+
+func (s *memoryStore) getMovementTrack(key string) (movementTrack, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	track, ok := s.tracks[key]
+	return track, ok, nil
+}
+
+func (s *memoryStore) saveMovementTrack(key string, track movementTrack, prev *time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.tracks[key]
+	if ok != (prev != nil) || (ok && current.last.At.UnixNano() != prev.UnixNano()) {
+		return false, nil
+	}
+	s.tracks[key] = track
+	return true, nil
+}
+
+func (s *memoryStore) deleteMovementTracks(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, track := range s.tracks {
+		if track.last.At.UnixNano() < before.UnixNano() {
+			delete(s.tracks, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) getIndexedBlock(name string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	block, ok := s.cursors[name]
+	return block, ok, nil
+}
+
+func (s *memoryStore) setIndexedBlock(name string, block uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.cursors[name]; !ok || block > current {
+		s.cursors[name] = block
+	}
+	return nil
+}
+
+func (s *memoryStore) insertSignatureNonce(sender, nonce string, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sweepNonces(s.signatureNonces, time.Now().Unix())
+	s.signatureNonces[normalizeAddress(nonce)] = memoryNonce{sender: sender, expires: expires}
+	return nil
+}
+
+func (s *memoryStore) useSignatureNonce(sender, nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return useNonce(s.signatureNonces, normalizeAddress(nonce), &sender, time.Now().Unix()), nil
+}
+
+func (s *memoryStore) insertLoginNonce(nonce string, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sweepNonces(s.loginNonces, time.Now().Unix())
+	s.loginNonces[nonce] = memoryNonce{expires: expires}
+	return nil
+}
+
+func (s *memoryStore) useLoginNonce(nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return useNonce(s.loginNonces, nonce, nil, time.Now().Unix()), nil
+}
+
+func sweepNonces(nonces map[string]memoryNonce, now int64) {
+	for nonce, n := range nonces {
+		if n.expires < now {
+			delete(nonces, nonce)
+		}
+	}
+}
+
+// useNonce marks nonce used if it's there, unused, unexpired and, when
+// sender is given, was issued to sender.
+func useNonce(nonces map[string]memoryNonce, nonce string, sender *string, now int64) bool {
+	n, ok := nonces[nonce]
+	if !ok || n.used || n.expires < now || (sender != nil && n.sender != *sender) {
+		return false
+	}
+	n.used = true
+	nonces[nonce] = n
+	return true
+}
+
+func (s *memoryStore) insertMessage(msg Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextMessageID++
+	msg.ID = s.nextMessageID
+	msg.Active = true
+	msg.TTL = 0
+	msg.Helpful, msg.Unhelpful = 0, 0
+	msg.Text = append([]int16(nil), msg.Text...)
+	s.messages[msg.ID] = &memoryMessage{Message: msg}
+	return msg.ID, nil
+}
+
+// liveMessage is a message that can still be edited or appraised.
+func (s *memoryStore) liveMessage(id int64, now int64) (*memoryMessage, bool) {
+	m, ok := s.messages[id]
+	if !ok || !m.Active || m.Expires <= now || m.deletedAt != 0 {
+		return nil, false
+	}
+	return m, true
+}
+
+func (s *memoryStore) editMessage(id int64, sender string, text []int16, version int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.liveMessage(id, time.Now().Unix())
+	if !ok || m.Sender != sender {
+		return false, nil
+	}
+	m.Text = append([]int16(nil), text...)
+	m.TemplateVersion = version
+	return true, nil
+}
+
+func (s *memoryStore) deleteMessage(id int64, sender string, at int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[id]
+	if !ok || m.Sender != sender || m.deletedAt != 0 {
+		return false, nil
+	}
+	m.Active, m.deletedAt = false, at
+	return true, nil
+}
+
+// withAppraisals copies m out with its appraisal counts.
+func (s *memoryStore) withAppraisals(m *memoryMessage) Message {
+	msg := m.Message
+	msg.Text = append([]int16(nil), m.Text...)
+	for _, helpful := range s.appraisals[m.ID] {
+		if helpful {
+			msg.Helpful++
+		} else {
+			msg.Unhelpful++
+		}
+	}
+	return msg
+}
+
+func (s *memoryStore) getMessagesBySender(sender string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []Message
+	for _, m := range s.messages {
+		if m.Sender == sender && m.deletedAt == 0 {
+			messages = append(messages, s.withAppraisals(m))
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+	return messages, nil
+}
+
+func (s *memoryStore) getMessageSender(id int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.liveMessage(id, time.Now().Unix())
+	if !ok {
+		return "", nil
+	}
+	return m.Sender, nil
+}
+
+func (s *memoryStore) appraiseMessage(id int64, appraiser string, helpful bool, at int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.liveMessage(id, at); !ok {
+		return false, nil
+	}
+	if s.appraisals[id] == nil {
+		s.appraisals[id] = map[string]bool{}
+	}
+	s.appraisals[id][appraiser] = helpful
+	return true, nil
+}
+
+func (s *memoryStore) expireMessages(now int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired int64
+	for _, m := range s.messages {
+		if m.Active && m.Expires <= now {
+			m.Active = false
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (s *memoryStore) purgeMessages(before int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, m := range s.messages {
+		gone := m.Expires
+		if m.deletedAt != 0 {
+			gone = m.deletedAt
+		}
+		if !m.Active && gone < before {
+			delete(s.messages, id)
+			delete(s.appraisals, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *memoryStore) getMessagesWithinRadius(lat, lon, radius float64, hideScore int64) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	var messages []Message
+	for _, m := range s.messages {
+		if !m.Active || m.Expires <= now || s.hidden("message", strconv.FormatInt(m.ID, 10), m.Sender) {
+			continue
+		}
+		msg := s.withAppraisals(m)
+		if msg.Helpful-msg.Unhelpful <= hideScore {
+			continue
+		}
+		if distance, _ := haversine(lat, lon, msg.Latitude, msg.Longitude); distance <= radius {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryStore) insertReport(report ReportInput, reporter string, at int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch report.TargetType {
+	case "message":
+		found := false
+		for id, m := range s.messages {
+			if strconv.FormatInt(id, 10) == report.TargetID && m.deletedAt == 0 {
+				found = true
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	case "prize":
+		if _, ok := s.prizes[report.TargetID]; !ok {
+			return false, nil
+		}
+	}
+
+	for _, r := range s.reports {
+		if r.TargetType == report.TargetType && r.TargetID == report.TargetID && r.Reporter == reporter {
+			r.Reason, r.CreatedAt, r.ResolvedAt = report.Reason, at, 0
+			return true, nil
+		}
+	}
+	s.nextReportID++
+	s.reports = append(s.reports, &Report{
+		ID:         s.nextReportID,
+		TargetType: report.TargetType,
+		TargetID:   report.TargetID,
+		Reporter:   reporter,
+		Reason:     report.Reason,
+		CreatedAt:  at,
+	})
+	return true, nil
+}
+
+func (s *memoryStore) getReports(resolved bool) ([]Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var reports []Report
+	for _, r := range s.reports {
+		if (r.ResolvedAt != 0) == resolved {
+			reports = append(reports, *r)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].CreatedAt > reports[j].CreatedAt })
+	if len(reports) > 500 {
+		reports = reports[:500]
+	}
+	return reports, nil
+}
+
+func (s *memoryStore) setModeration(input ModerationInput, moderator string, at int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.reports {
+		if r.TargetType == input.TargetType && r.TargetID == input.TargetID && r.ResolvedAt == 0 {
+			r.ResolvedAt = at
+		}
+	}
+	s.moderation[memoryTarget{input.TargetType, input.TargetID}] = input.Hidden
+	return nil
+}
+
+func (s *memoryStore) getBans() ([]Ban, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bans []Ban
+	for _, ban := range s.bans {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].BannedAt > bans[j].BannedAt })
+	return bans, nil
+}
+
+func (s *memoryStore) insertBan(ban Ban) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans[ban.Address] = ban
+	return nil
+}
+
+func (s *memoryStore) deleteBan(address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bans[address]
+	delete(s.bans, address)
+	return ok, nil
+}
+
+func (s *memoryStore) isBanned(address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bans[address]
+	return ok, nil
+}
+
+func (s *memoryStore) getExclusionZones() ([]exclusionZone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]exclusionZone(nil), s.zones...), nil
+}
+
+func (s *memoryStore) insertExclusionZones(zones []exclusionZone) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []int64
+	for _, zone := range zones {
+		s.nextZoneID++
+		zone.ID = s.nextZoneID
+		s.zones = append(s.zones, zone)
+		ids = append(ids, zone.ID)
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) deleteExclusionZone(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, zone := range s.zones {
+		if zone.ID == id {
+			s.zones = append(s.zones[:i], s.zones[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/gorilla/mux"
+
+	"pathfinder-api/templates"
+)
+
+// messageTTLConfig bounds how long a sender may ask for a message to last.
+type messageTTLConfig struct {
+	Min     time.Duration
+	Max     time.Duration
+	Default time.Duration
+}
+
+var messageTTLs = messageTTLConfig{Min: time.Hour, Max: time.Hour * 24 * 7, Default: time.Hour * 24}
+
+// messageHideScore hides messages once their helpful appraisals less their
+// unhelpful ones fall to it.
+var messageHideScore int64 = -5
+
+// initMessages reads MESSAGE_MIN_TTL, MESSAGE_MAX_TTL, MESSAGE_DEFAULT_TTL and
+// MESSAGE_HIDE_SCORE.
+func initMessages() {
+	messageHideScore = envInt("MESSAGE_HIDE_SCORE", messageHideScore)
+	messageTTLs = messageTTLConfig{
+		Min:     envDuration("MESSAGE_MIN_TTL", messageTTLs.Min),
+		Max:     envDuration("MESSAGE_MAX_TTL", messageTTLs.Max),
+		Default: envDuration("MESSAGE_DEFAULT_TTL", messageTTLs.Default),
+	}
+	if messageTTLs.Min > messageTTLs.Max || messageTTLs.Default < messageTTLs.Min || messageTTLs.Default > messageTTLs.Max {
+		Sugar.Fatalf("MESSAGE_DEFAULT_TTL must be between MESSAGE_MIN_TTL and MESSAGE_MAX_TTL")
+	}
+}
+
+// resolve turns the TTL a message was sent with, in seconds, into how long
+// it lasts; 0 means the default.
+func (c messageTTLConfig) resolve(seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		return c.Default, nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if seconds < 0 || ttl < c.Min || ttl > c.Max {
+		return 0, fmt.Errorf("TTL must be between %d and %d seconds", int64(c.Min.Seconds()), int64(c.Max.Seconds()))
+	}
+	return ttl, nil
+}
+
+// checkMessageText checks text indexes into catalogue version, or the latest
+// catalogue when version is 0, and returns the version it was checked against.
+func checkMessageText(text []int16, version int) (int, error) {
+	catalogue := templates.Latest()
+	if version != 0 {
+		var err error
+		if catalogue, err = templates.Version(version); err != nil {
+			return 0, err
+		}
+	}
+	if err := catalogue.Validate(text); err != nil {
+		return 0, err
+	}
+	return catalogue.Version, nil
+}
+
+// renderMessage writes msg's text out in the language that best suits
+// acceptLanguage, or returns "" for the client to do it if it can't be;
+// messages from before validation may not render.
+func renderMessage(msg Message, acceptLanguage string) string {
+	catalogue, err := templates.Localized(msg.TemplateVersion, acceptLanguage)
+	if err != nil {
+		Sugar.Warnf("can't render message %d: %s", msg.ID, err.Error())
+		return ""
+	}
+	rendered, err := catalogue.Render(msg.Text)
+	if err != nil {
+		Sugar.Warnf("can't render message %d: %s", msg.ID, err.Error())
+		return ""
+	}
+	return rendered
+}
+
+// templatesHandler serves a catalogue, the latest or ?version=, in the
+// language ?lang= or Accept-Language asks for. Anything not translated is in
+// English.
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	version := templates.Latest().Version
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	acceptLanguage := r.URL.Query().Get("lang")
+	if acceptLanguage == "" {
+		acceptLanguage = r.Header.Get("Accept-Language")
+	}
+	catalogue, err := templates.Localized(version, acceptLanguage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", catalogue.Language)
+	w.Header().Add("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(catalogue)
+}
+
+// MessageChangeInput edits or deletes a message. Text is only used by edits.
+type MessageChangeInput struct {
+	Sender    string  `json:"sender"`
+	Text      []int16 `json:"text,omitempty"`
+	Nonce     string  `json:"nonce"`
+	Deadline  int64   `json:"deadline"`
+	Signature string  `json:"signature"`
+}
+
+// messageEditTypedData is what a sender signs to change a message's text.
+func messageEditTypedData(id int64, input MessageChangeInput) apitypes.TypedData {
+	text := make([]interface{}, len(input.Text))
+	for i, t := range input.Text {
+		text[i] = strconv.Itoa(int(t))
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"MessageEdit": {
+				{Name: "id", Type: "uint256"},
+				{Name: "sender", Type: "address"},
+				{Name: "text", Type: "int16[]"},
+				{Name: "nonce", Type: "bytes32"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "MessageEdit",
+		Domain:      typedDataDomain(),
+		Message: apitypes.TypedDataMessage{
+			"id":       strconv.FormatInt(id, 10),
+			"sender":   input.Sender,
+			"text":     text,
+			"nonce":    input.Nonce,
+			"deadline": strconv.FormatInt(input.Deadline, 10),
+		},
+	}
+}
+
+// messageDeleteTypedData is what a sender signs to delete a message.
+func messageDeleteTypedData(id int64, input MessageChangeInput) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"MessageDelete": {
+				{Name: "id", Type: "uint256"},
+				{Name: "sender", Type: "address"},
+				{Name: "nonce", Type: "bytes32"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "MessageDelete",
+		Domain:      typedDataDomain(),
+		Message: apitypes.TypedDataMessage{
+			"id":       strconv.FormatInt(id, 10),
+			"sender":   input.Sender,
+			"nonce":    input.Nonce,
+			"deadline": strconv.FormatInt(input.Deadline, 10),
+		},
+	}
+}
+
+// readMessageChange decodes an edit or delete and checks it's from the
+// message's sender, either signed in or by signing typedData. It writes the
+// error response and returns false if anything's wrong.
+func (s *server) readMessageChange(w http.ResponseWriter, r *http.Request, typedData func(int64, MessageChangeInput) apitypes.TypedData) (int64, MessageChangeInput, bool) {
+	var input MessageChangeInput
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return 0, input, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		Sugar.Error(err)
+		return 0, input, false
+	}
+
+	if !common.IsHexAddress(input.Sender) {
+		http.Error(w, "Invalid sender", http.StatusBadRequest)
+		return 0, input, false
+	}
+
+	caller, signedIn := callerAddress(r.Context())
+	if !signedIn || common.HexToAddress(input.Sender) != caller {
+		if !s.checkSignature(w, r, input.Sender, typedData(id, input), input.Nonce, input.Deadline, input.Signature) {
+			return 0, input, false
+		}
+	}
+
+	input.Sender = normalizeAddress(input.Sender)
+	return id, input, true
+}
+
+func (s *server) editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, input, ok := s.readMessageChange(w, r, messageEditTypedData)
+	if !ok {
+		return
+	}
+
+	// edits are written with the latest catalogue
+	version, err := checkMessageText(input.Text, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid message: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	edited, err := s.store.editMessage(id, input.Sender, input.Text, version)
+	if err != nil {
+		http.Error(w, "Edit Message error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !edited {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, input, ok := s.readMessageChange(w, r, messageDeleteTypedData)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.store.deleteMessage(id, input.Sender, time.Now().Unix())
+	if err != nil {
+		http.Error(w, "Delete Message error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MessageAppraisalInput rates someone else's message helpful or not.
+type MessageAppraisalInput struct {
+	Appraiser string `json:"appraiser"`
+	Helpful   bool   `json:"helpful"`
+	Nonce     string `json:"nonce"`
+	Deadline  int64  `json:"deadline"`
+	Signature string `json:"signature"`
+}
+
+// messageAppraisalTypedData is what an appraiser signs to rate a message.
+func messageAppraisalTypedData(id int64, input MessageAppraisalInput) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": eip712DomainType,
+			"MessageAppraisal": {
+				{Name: "id", Type: "uint256"},
+				{Name: "appraiser", Type: "address"},
+				{Name: "helpful", Type: "bool"},
+				{Name: "nonce", Type: "bytes32"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "MessageAppraisal",
+		Domain:      typedDataDomain(),
+		Message: apitypes.TypedDataMessage{
+			"id":        strconv.FormatInt(id, 10),
+			"appraiser": input.Appraiser,
+			"helpful":   input.Helpful,
+			"nonce":     input.Nonce,
+			"deadline":  strconv.FormatInt(input.Deadline, 10),
+		},
+	}
+}
+
+// appraiseMessageHandler serves POST /messages/{id}/appraisals. Each wallet
+// gets one appraisal per message, appraising again changes it, and senders
+// can't appraise their own.
+func (s *server) appraiseMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var input MessageAppraisalInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		Sugar.Error(err)
+		return
+	}
+
+	if !common.IsHexAddress(input.Appraiser) {
+		http.Error(w, "Invalid appraiser", http.StatusBadRequest)
+		return
+	}
+
+	caller, signedIn := callerAddress(r.Context())
+	if !signedIn || common.HexToAddress(input.Appraiser) != caller {
+		if !s.checkSignature(w, r, input.Appraiser, messageAppraisalTypedData(id, input), input.Nonce, input.Deadline, input.Signature) {
+			return
+		}
+	}
+	appraiser := normalizeAddress(input.Appraiser)
+
+	sender, err := s.store.getMessageSender(id)
+	if err != nil {
+		http.Error(w, "Appraise Message error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if sender == "" {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if sender == appraiser {
+		http.Error(w, "Can't appraise your own message", http.StatusForbidden)
+		return
+	}
+
+	appraised, err := s.store.appraiseMessage(id, appraiser, input.Helpful, time.Now().Unix())
+	if err != nil {
+		http.Error(w, "Appraise Message error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !appraised {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listMessagesHandler serves GET /messages?sender=, the sender's messages
+// that haven't been deleted, expired ones included until they're purged,
+// with the appraisals they've had.
+// They say where each message is, so only the sender, signed in, can list
+// them.
+func (s *server) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	sender := r.URL.Query().Get("sender")
+	if !common.IsHexAddress(sender) {
+		http.Error(w, "Invalid sender", http.StatusBadRequest)
+		return
+	}
+
+	caller, signedIn := callerAddress(r.Context())
+	if !signedIn {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	if caller != common.HexToAddress(sender) {
+		http.Error(w, "Can only list your own messages", http.StatusForbidden)
+		return
+	}
+
+	messages, err := s.store.getMessagesBySender(normalizeAddress(sender))
+	if err != nil {
+		http.Error(w, "Failed to retrieve messages", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if messages == nil {
+		messages = []Message{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// messageExpirer marks expired messages inactive every Interval and purges
+// inactive ones Retention after they expired or were deleted. Every replica
+// runs it; the statements don't mind.
+type messageExpirer struct {
+	Interval  time.Duration
+	Retention time.Duration
+	store     Store
+}
+
+func messageExpirerFromEnv(store Store) messageExpirer {
+	return messageExpirer{
+		Interval:  envDuration("MESSAGE_EXPIRY_INTERVAL", time.Minute),
+		Retention: envDuration("MESSAGE_RETENTION", time.Hour*24*30),
+		store:     store,
+	}
+}
+
+func (e messageExpirer) run(ctx context.Context) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	for {
+		e.sweep(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e messageExpirer) sweep(now time.Time) {
+	expired, err := e.store.expireMessages(now.Unix())
+	if err != nil {
+		Sugar.Error(err)
+		return
+	}
+	if expired > 0 {
+		Sugar.Infof("expired %d messages", expired)
+		deltaUpdates.notify()
+	}
+
+	purged, err := e.store.purgeMessages(now.Add(-e.Retention).Unix())
+	if err != nil {
+		Sugar.Error(err)
+		return
+	}
+	if purged > 0 {
+		Sugar.Infof("purged %d messages", purged)
+	}
+}
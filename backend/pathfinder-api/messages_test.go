@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/gorilla/mux"
+
+	"pathfinder-api/templates"
+)
+
+func TestMessageTTLResolve(t *testing.T) {
+	ttls := messageTTLConfig{Min: time.Hour, Max: time.Hour * 48, Default: time.Hour * 24}
+
+	if ttl, err := ttls.resolve(0); err != nil || ttl != time.Hour*24 {
+		t.Errorf("resolve(0) = %s, %v; want the default", ttl, err)
+	}
+	if ttl, err := ttls.resolve(7200); err != nil || ttl != time.Hour*2 {
+		t.Errorf("resolve(7200) = %s, %v; want 2h", ttl, err)
+	}
+	for _, seconds := range []int64{-1, 60, 3600*48 + 1} {
+		if _, err := ttls.resolve(seconds); err == nil {
+			t.Errorf("resolve(%d) = nil error", seconds)
+		}
+	}
+}
+
+func TestMessageChangeTypedData(t *testing.T) {
+	chainID = big.NewInt(1337)
+	chain := newTestChain(t)
+	key, _ := crypto.GenerateKey()
+	input := MessageChangeInput{
+		Sender:   crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		Text:     []int16{4, 1, 9},
+		Nonce:    "0x" + strings.Repeat("ab", 32),
+		Deadline: 1700000000,
+	}
+
+	types := map[string]func(int64, MessageChangeInput) apitypes.TypedData{
+		"MessageEdit(uint256 id,address sender,int16[] text,bytes32 nonce,uint256 deadline)": messageEditTypedData,
+		"MessageDelete(uint256 id,address sender,bytes32 nonce,uint256 deadline)":            messageDeleteTypedData,
+	}
+	for wantType, typedDataFor := range types {
+		typedData := typedDataFor(42, input)
+		if got := typedData.TypeHash(typedData.PrimaryType); !bytes.Equal(got, crypto.Keccak256([]byte(wantType))) {
+			t.Errorf("%s type hash doesn't match %s", typedData.PrimaryType, wantType)
+		}
+
+		hash, _, err := apitypes.TypedDataAndHash(typedData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := crypto.Sign(hash, key)
+		signer := common.HexToAddress(input.Sender)
+		if ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, typedData, sig); err != nil || !ok {
+			t.Errorf("verifyTypedDataSignature() of %s = %v, %v; want true", typedData.PrimaryType, ok, err)
+		}
+		// a signature for one message can't change another
+		if ok, _ := verifyTypedDataSignature(context.Background(), chain.sim.Client(), signer, typedDataFor(43, input), sig); ok {
+			t.Errorf("verifyTypedDataSignature() of %s for another id = true", typedData.PrimaryType)
+		}
+	}
+}
+
+func TestListMessagesHandlerRequiresSender(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	token := issueSessionToken(sessionSecret, common.HexToAddress(fixtureSigner), time.Now().Add(time.Hour))
+	other := "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"
+
+	cases := []struct {
+		sender, token string
+		want          int
+	}{
+		{"nope", token, http.StatusBadRequest},
+		{fixtureSigner, "", http.StatusUnauthorized},
+		{other, token, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/messages?sender="+c.sender, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		sessionMiddleware(http.HandlerFunc((&server{store: newMemoryStore()}).listMessagesHandler)).ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("GET /messages?sender=%s = %d; want %d", c.sender, w.Code, c.want)
+		}
+	}
+}
+
+func TestDeleteMessageHandlerRequiresSignature(t *testing.T) {
+	s := &server{store: newMemoryStore()}
+	r := mux.NewRouter()
+	r.HandleFunc("/messages/{id:[0-9]+}", s.deleteMessageHandler).Methods("DELETE")
+
+	body := `{"sender": "` + fixtureSigner + `", "nonce": "0x` + strings.Repeat("ab", 32) + `", "deadline": 1}`
+	req := httptest.NewRequest(http.MethodDelete, "/messages/7", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Signature") {
+		t.Errorf("unsigned DELETE = %d %q; want 400 about the signature", w.Code, w.Body.String())
+	}
+}
+
+func TestMessageAppraisalTypedData(t *testing.T) {
+	chainID = big.NewInt(1337)
+	chain := newTestChain(t)
+	key, _ := crypto.GenerateKey()
+	input := MessageAppraisalInput{
+		Appraiser: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		Helpful:   true,
+		Nonce:     "0x" + strings.Repeat("ab", 32),
+		Deadline:  1700000000,
+	}
+
+	typedData := messageAppraisalTypedData(42, input)
+	wantType := "MessageAppraisal(uint256 id,address appraiser,bool helpful,bytes32 nonce,uint256 deadline)"
+	if got := typedData.TypeHash(typedData.PrimaryType); !bytes.Equal(got, crypto.Keccak256([]byte(wantType))) {
+		t.Errorf("type hash doesn't match %s", wantType)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := crypto.Sign(hash, key)
+	appraiser := common.HexToAddress(input.Appraiser)
+	if ok, err := verifyTypedDataSignature(context.Background(), chain.sim.Client(), appraiser, typedData, sig); err != nil || !ok {
+		t.Errorf("verifyTypedDataSignature() = %v, %v; want true", ok, err)
+	}
+	// a helpful appraisal can't be passed off as an unhelpful one
+	input.Helpful = false
+	if ok, _ := verifyTypedDataSignature(context.Background(), chain.sim.Client(), appraiser, messageAppraisalTypedData(42, input), sig); ok {
+		t.Errorf("verifyTypedDataSignature() with helpful flipped = true")
+	}
+}
+
+func TestAppraiseMessageHandlerRequiresSignature(t *testing.T) {
+	s := &server{store: newMemoryStore()}
+	r := mux.NewRouter()
+	r.HandleFunc("/messages/{id:[0-9]+}/appraisals", s.appraiseMessageHandler).Methods("POST")
+
+	cases := map[string]string{
+		`{"appraiser": "nope", "helpful": true}`: "appraiser",
+		`{"appraiser": "` + fixtureSigner + `", "helpful": true, "nonce": "0x` + strings.Repeat("ab", 32) + `", "deadline": 1}`: "Signature",
+	}
+	for body, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/messages/7/appraisals", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("POST %s = %d %q; want 400 about the %s", body, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestCheckMessageText(t *testing.T) {
+	if version, err := checkMessageText([]int16{0, 0, 0}, 0); err != nil || version != templates.Latest().Version {
+		t.Errorf("checkMessageText() without a version = %d, %v; want the latest", version, err)
+	}
+	if _, err := checkMessageText([]int16{0, 0, 0}, 999); err == nil {
+		t.Errorf("checkMessageText() with an unknown version = nil error")
+	}
+	if _, err := checkMessageText([]int16{0, 0}, 1); err == nil {
+		t.Errorf("checkMessageText() of two indices = nil error")
+	}
+
+	if got := renderMessage(Message{Text: []int16{0, 0, 0}, TemplateVersion: 1}, ""); got != "Enemy ahead" {
+		t.Errorf("renderMessage() = %q; want Enemy ahead", got)
+	}
+	if got := renderMessage(Message{Text: []int16{0, 0, 0}, TemplateVersion: 1}, "es-MX,es;q=0.9,en;q=0.5"); got != "Enemigo adelante" {
+		t.Errorf("renderMessage() in Spanish = %q; want Enemigo adelante", got)
+	}
+	if got := renderMessage(Message{Text: []int16{0, 0, 0}, TemplateVersion: 1}, "ja"); got != "Enemy ahead" {
+		t.Errorf("renderMessage() in an untranslated language = %q; want Enemy ahead", got)
+	}
+	if got := renderMessage(Message{Text: []int16{999, 0, 0}, TemplateVersion: 1}, ""); got != "" {
+		t.Errorf("renderMessage() of a bad message = %q; want nothing", got)
+	}
+}
+
+func TestTemplatesHandler(t *testing.T) {
+	cases := []struct {
+		target, acceptLanguage, language string
+		status                           int
+	}{
+		{"/templates", "", "en", http.StatusOK},
+		{"/templates", "es-ES,es;q=0.9", "es", http.StatusOK},
+		{"/templates?lang=es", "en", "es", http.StatusOK},
+		{"/templates?version=1", "fr", "en", http.StatusOK},
+		{"/templates?version=999", "", "", http.StatusNotFound},
+		{"/templates?version=x", "", "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.target, nil)
+		req.Header.Set("Accept-Language", c.acceptLanguage)
+		rr := httptest.NewRecorder()
+		templatesHandler(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("%s in %q = %d; want %d", c.target, c.acceptLanguage, rr.Code, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var catalogue templates.Catalogue
+		if err := json.NewDecoder(rr.Body).Decode(&catalogue); err != nil {
+			t.Fatal(err)
+		}
+		if catalogue.Language != c.language || rr.Header().Get("Content-Language") != c.language {
+			t.Errorf("%s in %q = %s; want %s", c.target, c.acceptLanguage, catalogue.Language, c.language)
+		}
+	}
+}
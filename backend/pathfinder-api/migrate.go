@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFiles are the schema, as numbered pairs of
+// NNNN_name.up.sql and NNNN_name.down.sql, Postgres's in migrations and
+// SQLite's in migrations/sqlite. Add new ones at the end, never change one
+// that's been released. Postgres's from before schema_migrations existed are
+// written IF NOT EXISTS so databases created by older builds pick the
+// history up where they are.
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+}
+
+var migrationPattern = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// schemaDialect is how one kind of database is migrated.
+type schemaDialect struct {
+	name       string
+	migrations []migration
+	// lock is run first in the migration transaction to keep replicas
+	// starting together from migrating at once, if it's needed
+	lock string
+	// exists says whether schema_migrations has been created
+	exists         string
+	insert, delete string
+}
+
+var postgresSchema = schemaDialect{
+	name:       "postgres",
+	migrations: mustLoadMigrations("migrations"),
+	lock:       `SELECT pg_advisory_xact_lock(1685221232)`,
+	exists:     `SELECT to_regclass('schema_migrations') IS NOT NULL`,
+	insert:     `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+	delete:     `DELETE FROM schema_migrations WHERE version = $1`,
+}
+
+// sqliteSchema needs no lock, SQLite is only ever opened by one process and
+// its transactions are begun immediate.
+var sqliteSchema = schemaDialect{
+	name:       "sqlite",
+	migrations: mustLoadMigrations("migrations/sqlite"),
+	exists:     `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`,
+	insert:     `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?1, ?2, ?3)`,
+	delete:     `DELETE FROM schema_migrations WHERE version = ?1`,
+}
+
+func mustLoadMigrations(dir string) []migration {
+	sub, err := fs.Sub(migrationFiles, dir)
+	if err != nil {
+		panic(err)
+	}
+	loaded, err := loadMigrations(sub)
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", dir, err))
+	}
+	return loaded
+}
+
+// loadMigrations reads the migrations in fsys, checking they're numbered
+// 1, 2, 3... and each has an up and a down.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s isn't named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	loaded := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		loaded = append(loaded, *m)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+	for i, m := range loaded {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down", m.Version)
+		}
+	}
+	return loaded, nil
+}
+
+func (d schemaDialect) latest() int {
+	return len(d.migrations)
+}
+
+// errSchemaTooNew is a database migrated by a newer build than this one,
+// whose schema it can't be trusted with.
+type errSchemaTooNew struct {
+	version, latest int
+}
+
+func (e errSchemaTooNew) Error() string {
+	return fmt.Sprintf("database schema is at version %d, this build only knows up to %d", e.version, e.latest)
+}
+
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at BIGINT NOT NULL
+)`
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// version is the newest migration applied, 0 for a new database.
+func (d schemaDialect) version(q queryRower) (int, error) {
+	var exists bool
+	if err := q.QueryRow(d.exists).Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err := q.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// checkVersion returns an error unless the database is exactly at the
+// latest version.
+func (d schemaDialect) checkVersion(q queryRower) error {
+	version, err := d.version(q)
+	if err != nil {
+		return err
+	}
+	if version > d.latest() {
+		return errSchemaTooNew{version: version, latest: d.latest()}
+	}
+	if version < d.latest() {
+		return fmt.Errorf("database schema is at version %d, run migrate to bring it up to %d", version, d.latest())
+	}
+	return nil
+}
+
+// migrateTo runs the up or down migrations between the database's version
+// and target in one transaction, returning what it ran. It won't touch a
+// database newer than this build.
+func (d schemaDialect) migrateTo(db *sql.DB, target int) ([]migration, error) {
+	if target < 0 || target > d.latest() {
+		return nil, fmt.Errorf("no schema version %d", target)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if d.lock != "" {
+		if _, err := tx.Exec(d.lock); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(createSchemaMigrations); err != nil {
+		return nil, err
+	}
+	current, err := d.version(tx)
+	if err != nil {
+		return nil, err
+	}
+	if current > d.latest() {
+		return nil, errSchemaTooNew{version: current, latest: d.latest()}
+	}
+
+	var ran []migration
+	for _, m := range d.migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+		if _, err := tx.Exec(m.Up); err != nil {
+			return nil, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(d.insert, m.Version, m.Name, time.Now().Unix()); err != nil {
+			return nil, err
+		}
+		ran = append(ran, m)
+	}
+	for i := len(d.migrations) - 1; i >= 0; i-- {
+		m := d.migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		if _, err := tx.Exec(m.Down); err != nil {
+			return nil, fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(d.delete, m.Version); err != nil {
+			return nil, err
+		}
+		ran = append(ran, m)
+	}
+
+	return ran, tx.Commit()
+}
+
+// prepare brings a database being opened up to date, unless
+// MIGRATE_ON_START=false, when it has to be migrated already.
+func (d schemaDialect) prepare(db *sql.DB) error {
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		return d.checkVersion(db)
+	}
+	ran, err := d.migrateTo(db, d.latest())
+	for _, m := range ran {
+		Sugar.Infof("ran %s migration %d %s", d.name, m.Version, m.Name)
+	}
+	return err
+}
+
+// migrateCommand is the migrate subcommand:
+//
+//	migrate [up]     apply every pending migration
+//	migrate down [n] revert the last n migrations, 1 by default
+//	migrate to <n>   move to version n, up or down
+//	migrate status   print the database's version
+func migrateCommand(db *sql.DB, d schemaDialect, args []string) {
+	current, err := d.version(db)
+	if err != nil {
+		Sugar.Fatal(err)
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	count := func(fallback int) int {
+		if len(args) < 2 {
+			return fallback
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			Sugar.Fatalf("invalid migration count %q", args[1])
+		}
+		return n
+	}
+
+	if action == "status" {
+		Sugar.Infof("%s schema version %d, latest %d", d.name, current, d.latest())
+		return
+	}
+	if current > d.latest() {
+		Sugar.Fatal(errSchemaTooNew{version: current, latest: d.latest()})
+	}
+
+	var target int
+	switch action {
+	case "up":
+		target = d.latest()
+	case "down":
+		target = current - count(1)
+	case "to":
+		if len(args) < 2 {
+			Sugar.Fatal("migrate to needs a version")
+		}
+		target = count(0)
+	default:
+		Sugar.Fatalf("unknown migrate action %s, use up, down, to or status", action)
+	}
+
+	ran, err := d.migrateTo(db, target)
+	if err != nil {
+		Sugar.Fatal(err)
+	}
+	for _, m := range ran {
+		Sugar.Infof("ran %s migration %d %s", d.name, m.Version, m.Name)
+	}
+	Sugar.Infof("%s schema version %d", d.name, target)
+}
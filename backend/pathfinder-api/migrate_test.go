@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, d := range []schemaDialect{postgresSchema, sqliteSchema} {
+		if d.latest() == 0 {
+			t.Errorf("no %s migrations embedded", d.name)
+		}
+		for i, m := range d.migrations {
+			if m.Version != i+1 || m.Name == "" || m.Up == "" || m.Down == "" {
+				t.Errorf("%s migration %d = %+v; want version %d with a name, up and down", d.name, i, m, i+1)
+			}
+		}
+	}
+}
+
+func TestSQLiteMigrateDownAndUp(t *testing.T) {
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "migrate.db"))
+	db := connectSQLite()
+	defer db.Close()
+
+	if _, err := sqliteSchema.migrateTo(db, sqliteSchema.latest()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteSchema.checkVersion(db); err != nil {
+		t.Errorf("checkVersion() after migrating up = %v", err)
+	}
+	if _, err := sqliteSchema.migrateTo(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteSchema.checkVersion(db); err == nil {
+		t.Errorf("checkVersion() after migrating down = nil error")
+	}
+
+	// a newer build's migration
+	if _, err := sqliteSchema.migrateTo(db, sqliteSchema.latest()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?1, 'future', 0)`, sqliteSchema.latest()+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqliteSchema.migrateTo(db, sqliteSchema.latest()); !errors.As(err, new(errSchemaTooNew)) {
+		t.Errorf("migrateTo() on a newer schema = %v; want errSchemaTooNew", err)
+	}
+	if err := sqliteSchema.checkVersion(db); !errors.As(err, new(errSchemaTooNew)) {
+		t.Errorf("checkVersion() on a newer schema = %v; want errSchemaTooNew", err)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+
+	loaded, err := loadMigrations(fstest.MapFS{
+		"0002_b.up.sql":   file("CREATE TABLE b ()"),
+		"0002_b.down.sql": file("DROP TABLE b"),
+		"0001_a.up.sql":   file("CREATE TABLE a ()"),
+		"0001_a.down.sql": file("DROP TABLE a"),
+	})
+	if err != nil || len(loaded) != 2 || loaded[0].Name != "a" || loaded[1].Down != "DROP TABLE b" {
+		t.Fatalf("loadMigrations() = %+v, %v; want a then b", loaded, err)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"no down": {"0001_a.up.sql": file("x")},
+		"gap":     {"0001_a.up.sql": file("x"), "0001_a.down.sql": file("x"), "0003_c.up.sql": file("x"), "0003_c.down.sql": file("x")},
+		"name":    {"1_a.up.sql": file("x"), "1_a.down.sql": file("x")},
+		"renamed": {"0001_a.up.sql": file("x"), "0001_b.down.sql": file("x")},
+	}
+	for name, fsys := range invalid {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("loadMigrations() with bad %s = nil error", name)
+		}
+	}
+}
@@ -1,4 +1,14 @@
-CREATE TABLE prizes (
+CREATE TABLE IF NOT EXISTS messages (
+    id SERIAL PRIMARY KEY,
+    sender TEXT,
+    text SMALLINT[],
+    latitude FLOAT8,
+    longitude FLOAT8,
+    expires BIGINT,
+    active BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS prizes (
     id TEXT PRIMARY KEY,
     sender TEXT,
     latitude DOUBLE PRECISION,
@@ -12,4 +22,4 @@ CREATE TABLE prizes (
     amount NUMERIC,
     expires BIGINT,
     active BOOLEAN
-);
\ No newline at end of file
+);
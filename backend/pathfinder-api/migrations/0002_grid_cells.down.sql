@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_cell_idx;
+DROP INDEX IF EXISTS prizes_cell_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS cell;
+ALTER TABLE prizes DROP COLUMN IF EXISTS cell;
@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS cell BIGINT;
+ALTER TABLE prizes ADD COLUMN IF NOT EXISTS cell BIGINT;
+CREATE INDEX IF NOT EXISTS messages_cell_idx ON messages (cell, expires) WHERE active = TRUE;
+CREATE INDEX IF NOT EXISTS prizes_cell_idx ON prizes (cell, expires) WHERE active = TRUE;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS indexer_state;
+ALTER TABLE prizes DROP COLUMN IF EXISTS event_block;
+ALTER TABLE prizes DROP COLUMN IF EXISTS event_index;
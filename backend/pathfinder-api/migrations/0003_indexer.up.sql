@@ -0,0 +1,7 @@
+ALTER TABLE prizes ADD COLUMN IF NOT EXISTS event_block BIGINT;
+ALTER TABLE prizes ADD COLUMN IF NOT EXISTS event_index INTEGER;
+
+CREATE TABLE IF NOT EXISTS indexer_state (
+    name TEXT PRIMARY KEY,
+    block BIGINT NOT NULL
+);
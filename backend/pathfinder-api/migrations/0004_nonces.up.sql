@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS signature_nonces (
+    nonce TEXT PRIMARY KEY,
+    sender TEXT NOT NULL,
+    expires BIGINT NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS login_nonces (
+    nonce TEXT PRIMARY KEY,
+    expires BIGINT NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS messages_sender_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS template_version;
@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS template_version INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS messages_sender_idx ON messages (sender);
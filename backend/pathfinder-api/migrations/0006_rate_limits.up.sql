@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limits_updated_idx ON rate_limits (updated);
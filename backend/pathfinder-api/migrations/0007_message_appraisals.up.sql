@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS message_appraisals (
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    appraiser TEXT NOT NULL,
+    helpful BOOLEAN NOT NULL,
+    appraised_at BIGINT NOT NULL,
+    PRIMARY KEY (message_id, appraiser)
+);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL PRIMARY KEY,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    reporter TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    resolved_at BIGINT,
+    UNIQUE (target_type, target_id, reporter)
+);
+CREATE INDEX IF NOT EXISTS reports_open_idx ON reports (created_at) WHERE resolved_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS moderation (
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    hidden BOOLEAN NOT NULL,
+    note TEXT,
+    moderator TEXT NOT NULL,
+    updated_at BIGINT NOT NULL,
+    PRIMARY KEY (target_type, target_id)
+);
+
+CREATE TABLE IF NOT EXISTS banned_senders (
+    address TEXT PRIMARY KEY,
+    reason TEXT,
+    banned_by TEXT NOT NULL,
+    banned_at BIGINT NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS exclusion_zones (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    polygons JSONB NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at BIGINT NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS prizes_claimed_by_idx;
+DROP INDEX IF EXISTS prizes_sender_idx;
+ALTER TABLE prizes DROP COLUMN IF EXISTS claimed_by;
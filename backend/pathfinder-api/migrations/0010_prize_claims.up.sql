@@ -0,0 +1,4 @@
+ALTER TABLE prizes ADD COLUMN IF NOT EXISTS claimed_by TEXT;
+
+CREATE INDEX IF NOT EXISTS prizes_sender_idx ON prizes (sender, id);
+CREATE INDEX IF NOT EXISTS prizes_claimed_by_idx ON prizes (claimed_by, id) WHERE claimed_by IS NOT NULL;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS drop_events (
+    block_number BIGINT NOT NULL,
+    log_index INTEGER NOT NULL,
+    drop_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    tx_hash TEXT NOT NULL,
+    sender TEXT NOT NULL,
+    receiver TEXT,
+    block_time BIGINT NOT NULL,
+    PRIMARY KEY (block_number, log_index)
+);
+CREATE INDEX IF NOT EXISTS drop_events_drop_idx ON drop_events (drop_id, block_number, log_index);
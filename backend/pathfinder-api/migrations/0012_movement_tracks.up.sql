@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS movement_tracks (
+    key TEXT PRIMARY KEY,
+    last_latitude DOUBLE PRECISION NOT NULL,
+    last_longitude DOUBLE PRECISION NOT NULL,
+    last_at BIGINT NOT NULL,
+    anchor_latitude DOUBLE PRECISION NOT NULL,
+    anchor_longitude DOUBLE PRECISION NOT NULL,
+    anchor_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS movement_tracks_last_at_idx ON movement_tracks (last_at);
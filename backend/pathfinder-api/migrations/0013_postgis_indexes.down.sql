@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_geog_idx;
+DROP INDEX IF EXISTS prizes_geog_idx;
@@ -0,0 +1,11 @@
+-- only where postgis is already installed; the grid cells cover everyone else
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis') THEN
+        CREATE INDEX IF NOT EXISTS messages_geog_idx ON messages
+            USING GIST (geography(ST_SetSRID(ST_MakePoint(longitude, latitude), 4326))) WHERE active = TRUE;
+        CREATE INDEX IF NOT EXISTS prizes_geog_idx ON prizes
+            USING GIST (geography(ST_SetSRID(ST_MakePoint(longitude, latitude), 4326))) WHERE active = TRUE;
+    END IF;
+END
+$$;
@@ -0,0 +1,10 @@
+DROP TABLE exclusion_zones;
+DROP TABLE banned_senders;
+DROP TABLE moderation;
+DROP TABLE reports;
+DROP TABLE message_appraisals;
+DROP TABLE login_nonces;
+DROP TABLE signature_nonces;
+DROP TABLE indexer_state;
+DROP TABLE prizes;
+DROP TABLE messages;
@@ -0,0 +1,99 @@
+-- text is a JSON array of template indices and amount a decimal string,
+-- SQLite having neither arrays nor arbitrary precision numbers
+CREATE TABLE messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender TEXT,
+    text TEXT NOT NULL DEFAULT '[]',
+    latitude REAL,
+    longitude REAL,
+    expires INTEGER,
+    active BOOLEAN,
+    cell INTEGER,
+    deleted_at INTEGER,
+    template_version INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX messages_cell_idx ON messages (cell, expires) WHERE active = TRUE;
+CREATE INDEX messages_sender_idx ON messages (sender);
+
+CREATE TABLE prizes (
+    id TEXT PRIMARY KEY,
+    sender TEXT,
+    latitude REAL,
+    longitude REAL,
+    password TEXT,
+    hashed_password TEXT,
+    type TEXT,
+    contract_address TEXT,
+    name TEXT,
+    symbol TEXT,
+    amount TEXT,
+    expires INTEGER,
+    active BOOLEAN,
+    cell INTEGER,
+    event_block INTEGER,
+    event_index INTEGER
+);
+CREATE INDEX prizes_cell_idx ON prizes (cell, expires) WHERE active = TRUE;
+
+CREATE TABLE indexer_state (
+    name TEXT PRIMARY KEY,
+    block INTEGER NOT NULL
+);
+
+CREATE TABLE signature_nonces (
+    nonce TEXT PRIMARY KEY,
+    sender TEXT NOT NULL,
+    expires INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE login_nonces (
+    nonce TEXT PRIMARY KEY,
+    expires INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE message_appraisals (
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    appraiser TEXT NOT NULL,
+    helpful BOOLEAN NOT NULL,
+    appraised_at INTEGER NOT NULL,
+    PRIMARY KEY (message_id, appraiser)
+);
+
+CREATE TABLE reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    reporter TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    resolved_at INTEGER,
+    UNIQUE (target_type, target_id, reporter)
+);
+CREATE INDEX reports_open_idx ON reports (created_at) WHERE resolved_at IS NULL;
+
+CREATE TABLE moderation (
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    hidden BOOLEAN NOT NULL,
+    note TEXT,
+    moderator TEXT NOT NULL,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (target_type, target_id)
+);
+
+CREATE TABLE banned_senders (
+    address TEXT PRIMARY KEY,
+    reason TEXT,
+    banned_by TEXT NOT NULL,
+    banned_at INTEGER NOT NULL
+);
+
+CREATE TABLE exclusion_zones (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    polygons TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
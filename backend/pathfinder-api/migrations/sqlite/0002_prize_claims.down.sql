@@ -0,0 +1,3 @@
+DROP INDEX prizes_claimed_by_idx;
+DROP INDEX prizes_sender_idx;
+ALTER TABLE prizes DROP COLUMN claimed_by;
@@ -0,0 +1 @@
+DROP TABLE drop_events;
@@ -0,0 +1,12 @@
+CREATE TABLE drop_events (
+    block_number INTEGER NOT NULL,
+    log_index INTEGER NOT NULL,
+    drop_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    tx_hash TEXT NOT NULL,
+    sender TEXT NOT NULL,
+    receiver TEXT,
+    block_time INTEGER NOT NULL,
+    PRIMARY KEY (block_number, log_index)
+);
+CREATE INDEX drop_events_drop_idx ON drop_events (drop_id, block_number, log_index);
@@ -0,0 +1 @@
+DROP TABLE movement_tracks;
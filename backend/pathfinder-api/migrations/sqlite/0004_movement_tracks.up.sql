@@ -0,0 +1,10 @@
+CREATE TABLE movement_tracks (
+    key TEXT PRIMARY KEY,
+    last_latitude REAL NOT NULL,
+    last_longitude REAL NOT NULL,
+    last_at INTEGER NOT NULL,
+    anchor_latitude REAL NOT NULL,
+    anchor_longitude REAL NOT NULL,
+    anchor_at INTEGER NOT NULL
+);
+CREATE INDEX movement_tracks_last_at_idx ON movement_tracks (last_at);
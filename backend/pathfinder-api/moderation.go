@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+const maxReportReasonLength = 500
+
+// admins can use /admin, signed in. They're set by ADMIN_ADDRESSES, a comma
+// separated list of addresses.
+var admins map[common.Address]bool
+
+var prizeIDPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+func initModeration() {
+	var err error
+	admins, err = adminsFrom(os.Getenv("ADMIN_ADDRESSES"))
+	if err != nil {
+		Sugar.Fatalf("invalid ADMIN_ADDRESSES: %s", err.Error())
+	}
+	if len(admins) == 0 {
+		Sugar.Warn("ADMIN_ADDRESSES not set, nobody can moderate")
+	}
+}
+
+func adminsFrom(list string) (map[common.Address]bool, error) {
+	addresses := map[common.Address]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("%q isn't an address", entry)
+		}
+		addresses[common.HexToAddress(entry)] = true
+	}
+	return addresses, nil
+}
+
+// adminMiddleware only lets signed in admins through.
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, signedIn := callerAddress(r.Context())
+		if !signedIn {
+			http.Error(w, "Not signed in", http.StatusUnauthorized)
+			return
+		}
+		if !admins[caller] {
+			http.Error(w, "Admins only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReportInput reports a message or prize, TargetType "message" or "prize".
+type ReportInput struct {
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Reason     string `json:"reason"`
+}
+
+// Report is a ReportInput as the moderation queue has it. Reporter is the
+// signed in address, or the IP for anyone else.
+type Report struct {
+	ID         int64  `json:"id"`
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Reporter   string `json:"reporter"`
+	Reason     string `json:"reason"`
+	CreatedAt  int64  `json:"createdAt"`
+	ResolvedAt int64  `json:"resolvedAt,omitempty"`
+}
+
+// validateTarget checks targetID looks like an id of targetType, and
+// normalizes prize ids.
+func validateTarget(targetType, targetID string) (string, error) {
+	switch targetType {
+	case "message":
+		if _, err := strconv.ParseInt(targetID, 10, 64); err != nil {
+			return "", fmt.Errorf("Invalid message id")
+		}
+		return targetID, nil
+	case "prize":
+		if !prizeIDPattern.MatchString(targetID) {
+			return "", fmt.Errorf("Invalid prize id")
+		}
+		return normalizeAddress(targetID), nil
+	}
+	return "", fmt.Errorf("Target type must be message or prize")
+}
+
+func (in *ReportInput) validate() error {
+	targetID, err := validateTarget(in.TargetType, in.TargetID)
+	if err != nil {
+		return err
+	}
+	in.TargetID = targetID
+
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.Reason == "" || len(in.Reason) > maxReportReasonLength {
+		return fmt.Errorf("Reason must be 1 to %d characters", maxReportReasonLength)
+	}
+	return nil
+}
+
+// reportHandler serves POST /reports. Reporting the same thing again updates
+// the report, and reopens it if it was resolved.
+func (s *server) reportHandler(w http.ResponseWriter, r *http.Request) {
+	var input ReportInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		Sugar.Error(err)
+		return
+	}
+
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reported, err := s.store.insertReport(input, viewerKey(r), time.Now().Unix())
+	if err != nil {
+		http.Error(w, "Report error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !reported {
+		http.Error(w, fmt.Sprintf("%s not found", input.TargetType), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// listReportsHandler serves GET /admin/reports, the open reports or, with
+// ?resolved=true, the resolved ones, newest first.
+func (s *server) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	resolved := r.URL.Query().Get("resolved") == "true"
+	reports, err := s.store.getReports(resolved)
+	if err != nil {
+		http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if reports == nil {
+		reports = []Report{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// ModerationInput hides or restores a message or prize.
+type ModerationInput struct {
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Hidden     bool   `json:"hidden"`
+	Note       string `json:"note,omitempty"`
+}
+
+// moderateHandler serves PUT /admin/moderation. Either way the target's open
+// reports are resolved.
+func (s *server) moderateHandler(w http.ResponseWriter, r *http.Request) {
+	var input ModerationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		Sugar.Error(err)
+		return
+	}
+
+	targetID, err := validateTarget(input.TargetType, input.TargetID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.TargetID = targetID
+
+	moderator, _ := callerAddress(r.Context())
+	if err := s.store.setModeration(input, normalizeAddress(moderator.Hex()), time.Now().Unix()); err != nil {
+		http.Error(w, "Moderation error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	Sugar.Infof("%s set %s %s hidden=%t", moderator.Hex(), input.TargetType, input.TargetID, input.Hidden)
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ban stops an address dropping anything, and hides what it already has.
+type Ban struct {
+	Address  string `json:"address"`
+	Reason   string `json:"reason"`
+	BannedBy string `json:"bannedBy"`
+	BannedAt int64  `json:"bannedAt"`
+}
+
+func (s *server) listBansHandler(w http.ResponseWriter, r *http.Request) {
+	bans, err := s.store.getBans()
+	if err != nil {
+		http.Error(w, "Failed to retrieve bans", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if bans == nil {
+		bans = []Ban{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+// banHandler serves PUT /admin/bans/{address} with an optional reason.
+func (s *server) banHandler(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	moderator, _ := callerAddress(r.Context())
+	ban := Ban{
+		Address:  normalizeAddress(address),
+		Reason:   input.Reason,
+		BannedBy: normalizeAddress(moderator.Hex()),
+		BannedAt: time.Now().Unix(),
+	}
+	if err := s.store.insertBan(ban); err != nil {
+		http.Error(w, "Ban error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	Sugar.Infof("%s banned %s", moderator.Hex(), ban.Address)
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	unbanned, err := s.store.deleteBan(normalizeAddress(address))
+	if err != nil {
+		http.Error(w, "Unban error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !unbanned {
+		http.Error(w, "Not banned", http.StatusNotFound)
+		return
+	}
+	moderator, _ := callerAddress(r.Context())
+	Sugar.Infof("%s unbanned %s", moderator.Hex(), normalizeAddress(address))
+	deltaUpdates.notify()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkNotBanned writes a 403 and returns false if sender is banned.
+func (s *server) checkNotBanned(w http.ResponseWriter, sender string) bool {
+	banned, err := s.store.isBanned(normalizeAddress(sender))
+	if err != nil {
+		http.Error(w, "Ban check error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return false
+	}
+	if banned {
+		http.Error(w, "Sender is banned", http.StatusForbidden)
+		return false
+	}
+	return true
+}
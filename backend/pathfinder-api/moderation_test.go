@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAdminsFrom(t *testing.T) {
+	got, err := adminsFrom(" " + fixtureSigner + ",,0x70997970C51812dc3A010C7d01b50e0d17dc79C8 ")
+	if err != nil || len(got) != 2 || !got[common.HexToAddress(fixtureSigner)] {
+		t.Errorf("adminsFrom() = %v, %v; want both addresses", got, err)
+	}
+	if got, err := adminsFrom(""); err != nil || len(got) != 0 {
+		t.Errorf("adminsFrom(\"\") = %v, %v; want nobody", got, err)
+	}
+	if _, err := adminsFrom("nope"); err == nil {
+		t.Errorf("adminsFrom(nope) = nil error")
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	admins = map[common.Address]bool{common.HexToAddress(fixtureSigner): true}
+	defer func() { admins = nil }()
+
+	adminToken := issueSessionToken(sessionSecret, common.HexToAddress(fixtureSigner), time.Now().Add(time.Hour))
+	otherToken := issueSessionToken(sessionSecret, common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8"), time.Now().Add(time.Hour))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := sessionMiddleware(adminMiddleware(ok))
+
+	cases := map[string]int{
+		"":         http.StatusUnauthorized,
+		otherToken: http.StatusForbidden,
+		adminToken: http.StatusOK,
+	}
+	for token, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/admin/reports", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("GET /admin/reports = %d; want %d", w.Code, want)
+		}
+	}
+}
+
+func TestReportInputValidate(t *testing.T) {
+	prizeID := "0x" + strings.Repeat("AB", 32)
+	valid := []ReportInput{
+		{TargetType: "message", TargetID: "12", Reason: "slur"},
+		{TargetType: "prize", TargetID: prizeID, Reason: " on a railway line "},
+	}
+	for _, in := range valid {
+		if err := in.validate(); err != nil {
+			t.Errorf("validate(%+v) = %v", in, err)
+		}
+	}
+
+	in := valid[1]
+	in.validate()
+	if in.TargetID != strings.ToLower(prizeID) || in.Reason != "on a railway line" {
+		t.Errorf("validate() left %+v; want the id lowercased and the reason trimmed", in)
+	}
+
+	invalid := map[string]ReportInput{
+		"type":       {TargetType: "user", TargetID: "12", Reason: "x"},
+		"message id": {TargetType: "message", TargetID: "0x12", Reason: "x"},
+		"prize id":   {TargetType: "prize", TargetID: "12", Reason: "x"},
+		"no reason":  {TargetType: "message", TargetID: "12", Reason: "  "},
+		"long":       {TargetType: "message", TargetID: "12", Reason: strings.Repeat("x", maxReportReasonLength+1)},
+	}
+	for name, in := range invalid {
+		if err := in.validate(); err == nil {
+			t.Errorf("validate() with bad %s = nil error", name)
+		}
+	}
+}
+
+func TestReportHandlerRejectsInvalid(t *testing.T) {
+	s := &server{store: newMemoryStore()}
+	for _, body := range []string{`nope`, `{"targetType": "message", "targetId": "x", "reason": "spam"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.reportHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST /reports %s = %d; want 400", body, w.Code)
+		}
+	}
+}
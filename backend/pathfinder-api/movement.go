@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errImpossibleSpeed = errors.New("moved faster than possible")
+	errTeleport        = errors.New("jumped too far between reports")
+	// errConcurrentReports is returned when reports for one track keep
+	// racing each other
+	errConcurrentReports = errors.New("too many concurrent reports")
+)
+
+// movementConfig holds the thresholds location reports are judged by.
+type movementConfig struct {
+	// MaxSpeed is the fastest a tracked device may move, in m/s.
+	MaxSpeed float64
+	// MaxJump is the furthest apart two consecutive reports may be, in
+	// metres, however long passed between them.
+	MaxJump float64
+	// Jitter is how far GPS noise may move a stationary device, in metres;
+	// smaller moves aren't held to MaxSpeed.
+	Jitter float64
+	// MinDwell is how long a device has to stay within DwellRadius metres
+	// of a spot before passwords there are revealed.
+	MinDwell    time.Duration
+	DwellRadius float64
+	// TrackTTL is how long a track is kept after its last report.
+	TrackTTL time.Duration
+}
+
+func movementConfigFromEnv() movementConfig {
+	return movementConfig{
+		MaxSpeed:    envFloat("MOVEMENT_MAX_SPEED", 50),
+		MaxJump:     envFloat("MOVEMENT_MAX_JUMP", 2000),
+		Jitter:      envFloat("MOVEMENT_JITTER", 25),
+		MinDwell:    envDuration("MOVEMENT_MIN_DWELL", time.Second*20),
+		DwellRadius: envFloat("MOVEMENT_DWELL_RADIUS", 15),
+		TrackTTL:    envDuration("MOVEMENT_TRACK_TTL", time.Minute*10),
+	}
+}
+
+type locationReport struct {
+	Latitude  float64
+	Longitude float64
+	At        time.Time
+}
+
+// movementTrack is what's remembered about one signed in caller: the last
+// accepted report and where, and since when, it has been dwelling.
+type movementTrack struct {
+	last   locationReport
+	anchor locationReport
+}
+
+// movementStore keeps tracks where every replica sees them. saveMovementTrack
+// only writes if the stored track's last report is still at prev, or if
+// there's no track when prev is nil, so racing reports can't undo each other.
+type movementStore interface {
+	getMovementTrack(key string) (movementTrack, bool, error)
+	saveMovementTrack(key string, track movementTrack, prev *time.Time) (bool, error)
+	deleteMovementTracks(before time.Time) error
+}
+
+// how many times a report is retried when another one for the same track
+// got in first
+const movementRetries = 3
+
+// movementTracker checks location reports against a simple movement model
+// so scripted coordinates can't sweep an area for prize passwords. Tracks are
+// keyed by signed in address and kept in the store.
+type movementTracker struct {
+	config movementConfig
+	store  movementStore
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+var movement *movementTracker
+
+func newMovementTracker(config movementConfig, store movementStore) *movementTracker {
+	return &movementTracker{config: config, store: store}
+}
+
+func distanceMeters(a, b locationReport) float64 {
+	d, _ := haversine(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	return d * 1000
+}
+
+// report records a location for key and returns whether it has dwelt long
+// enough to see passwords. Implausible reports are rejected with an error,
+// aren't recorded and restart the dwell timer.
+func (m *movementTracker) report(key string, lat, lon float64, at time.Time) (bool, error) {
+	m.sweep(at)
+
+	next := locationReport{Latitude: lat, Longitude: lon, At: at}
+	for i := 0; i < movementRetries; i++ {
+		track, found, err := m.store.getMovementTrack(key)
+		if err != nil {
+			return false, err
+		}
+		var prev *time.Time
+		if found {
+			lastAt := track.last.At
+			prev = &lastAt
+		}
+
+		track, reveal, rejected := m.judge(track, found, next)
+		saved, err := m.store.saveMovementTrack(key, track, prev)
+		if err != nil {
+			return false, err
+		}
+		if saved {
+			return reveal, rejected
+		}
+	}
+	return false, errConcurrentReports
+}
+
+// judge applies next to track, returning the updated track, whether it
+// reveals passwords and why it was rejected, if it was.
+func (m *movementTracker) judge(track movementTrack, found bool, next locationReport) (movementTrack, bool, error) {
+	if !found || next.At.Sub(track.last.At) > m.config.TrackTTL {
+		return movementTrack{last: next, anchor: next}, m.config.MinDwell <= 0, nil
+	}
+
+	distance := distanceMeters(track.last, next)
+	elapsed := next.At.Sub(track.last.At).Seconds()
+	if distance > m.config.MaxJump {
+		track.anchor = track.last
+		track.anchor.At = next.At
+		return track, false, errTeleport
+	}
+	if distance > m.config.Jitter && (elapsed <= 0 || distance/elapsed > m.config.MaxSpeed) {
+		track.anchor = track.last
+		track.anchor.At = next.At
+		return track, false, errImpossibleSpeed
+	}
+
+	track.last = next
+	if distanceMeters(track.anchor, next) > m.config.DwellRadius {
+		track.anchor = next
+	}
+	return track, next.At.Sub(track.anchor.At) >= m.config.MinDwell, nil
+}
+
+// sweep drops expired tracks, at most once per TTL on each replica.
+func (m *movementTracker) sweep(now time.Time) {
+	m.mu.Lock()
+	if now.Sub(m.lastSweep) < m.config.TrackTTL {
+		m.mu.Unlock()
+		return
+	}
+	m.lastSweep = now
+	m.mu.Unlock()
+
+	if err := m.store.deleteMovementTracks(now.Add(-m.config.TrackTTL)); err != nil {
+		Sugar.Error(err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testMovementConfig = movementConfig{
+	MaxSpeed:    50,
+	MaxJump:     2000,
+	Jitter:      25,
+	MinDwell:    time.Second * 20,
+	DwellRadius: 15,
+	TrackTTL:    time.Minute * 10,
+}
+
+// metres north of 51.5,-0.1, near enough
+func north(metres float64) float64 {
+	return 51.5 + metres/111195
+}
+
+func TestMovementDwell(t *testing.T) {
+	m := newMovementTracker(testMovementConfig, newMemoryStore())
+	start := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		after  time.Duration
+		metres float64
+		reveal bool
+	}{
+		{0, 0, false},
+		{time.Second * 10, 5, false},  // within the dwell radius
+		{time.Second * 20, 3, true},   // held for 20s
+		{time.Second * 25, 40, false}, // walked off, dwell restarts
+		{time.Second * 40, 45, false},
+		{time.Second * 45, 42, true},
+	}
+	for i, step := range steps {
+		reveal, err := m.report("s", north(step.metres), -0.1, start.Add(step.after))
+		if err != nil || reveal != step.reveal {
+			t.Errorf("report() step %d = %v, %v; want %v", i, reveal, err, step.reveal)
+		}
+	}
+}
+
+func TestMovementRejectsImpossibleSpeed(t *testing.T) {
+	m := newMovementTracker(testMovementConfig, newMemoryStore())
+	start := time.Unix(1700000000, 0)
+
+	if _, err := m.report("s", north(0), -0.1, start); err != nil {
+		t.Fatal(err)
+	}
+	// 500m in 2.5s is 200m/s
+	if _, err := m.report("s", north(500), -0.1, start.Add(time.Millisecond*2500)); err != errImpossibleSpeed {
+		t.Errorf("report() = %v; want %v", err, errImpossibleSpeed)
+	}
+	// jitter alone isn't held to the speed limit
+	if _, err := m.report("s", north(20), -0.1, start.Add(time.Millisecond*2600)); err != nil {
+		t.Errorf("report() within jitter = %v; want nil", err)
+	}
+	// a car is fine
+	if _, err := m.report("s", north(120), -0.1, start.Add(time.Millisecond*7600)); err != nil {
+		t.Errorf("report() at 20m/s = %v; want nil", err)
+	}
+}
+
+func TestMovementRejectsTeleport(t *testing.T) {
+	m := newMovementTracker(testMovementConfig, newMemoryStore())
+	start := time.Unix(1700000000, 0)
+
+	if _, err := m.report("s", north(0), -0.1, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.report("s", north(0), -0.1, start.Add(time.Second*30)); err != nil {
+		t.Fatal(err)
+	}
+	// slow enough on average but one jump of 5km
+	if _, err := m.report("s", north(5000), -0.1, start.Add(time.Minute*5)); err != errTeleport {
+		t.Errorf("report() = %v; want %v", err, errTeleport)
+	}
+
+	// the rejected spot wasn't recorded and the dwell restarted
+	reveal, err := m.report("s", north(0), -0.1, start.Add(time.Minute*5+time.Second))
+	if err != nil || reveal {
+		t.Errorf("report() after a teleport = %v, %v; want false", reveal, err)
+	}
+
+	// another session is tracked on its own
+	if _, err := m.report("other", north(5000), -0.1, start.Add(time.Minute*5)); err != nil {
+		t.Errorf("report() for a new session = %v; want nil", err)
+	}
+}
+
+func TestMovementTrackExpires(t *testing.T) {
+	store := newMemoryStore()
+	m := newMovementTracker(testMovementConfig, store)
+	start := time.Unix(1700000000, 0)
+
+	if _, err := m.report("s", north(0), -0.1, start); err != nil {
+		t.Fatal(err)
+	}
+	later := start.Add(testMovementConfig.TrackTTL + time.Second)
+	if _, err := m.report("s", north(5000), -0.1, later); err != nil {
+		t.Errorf("report() after the track expired = %v; want nil", err)
+	}
+
+	m.report("other", north(0), -0.1, later.Add(testMovementConfig.TrackTTL*2))
+	if _, ok, _ := store.getMovementTrack("s"); ok {
+		t.Errorf("sweep() kept an expired track")
+	}
+}
+
+// racingStore is a movementStore where another report always got in first.
+type racingStore struct {
+	*memoryStore
+}
+
+func (racingStore) saveMovementTrack(key string, track movementTrack, prev *time.Time) (bool, error) {
+	return false, nil
+}
+
+func TestMovementRetriesRacingReports(t *testing.T) {
+	m := newMovementTracker(testMovementConfig, racingStore{newMemoryStore()})
+	if _, err := m.report("s", north(0), -0.1, time.Unix(1700000000, 0)); err != errConcurrentReports {
+		t.Errorf("report() losing every race = %v; want %v", err, errConcurrentReports)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prize passwords are stored as
+//
+//	enc:v1:<key id>:<wrapped data key>:<nonce + ciphertext>
+//
+// with each password under its own data key and the prize id authenticated
+// alongside it, so a sealed password can't be moved onto another prize.
+// Anything without the prefix predates encryption and is read as is until
+// the reencrypt command seals it.
+const sealedPasswordPrefix = "enc:v1:"
+
+func sealPassword(ctx context.Context, keys KeyProvider, prizeID, password string) (string, error) {
+	dataKey, wrapped, keyID, err := keys.GenerateDataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := gcmSeal(dataKey, []byte(password), []byte(normalizeAddress(prizeID)))
+	if err != nil {
+		return "", err
+	}
+	return sealedPasswordPrefix + keyID + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openPassword(ctx context.Context, keys KeyProvider, prizeID, stored string) (string, error) {
+	keyID, wrapped, sealed, ok, err := parseSealedPassword(stored)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return stored, nil
+	}
+	if keys == nil {
+		return "", errors.New("no key provider for sealed password")
+	}
+
+	dataKey, err := keys.DecryptDataKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	password, err := gcmOpen(dataKey, sealed, []byte(normalizeAddress(prizeID)))
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// parseSealedPassword splits a stored password, ok is false for plaintext.
+func parseSealedPassword(stored string) (keyID string, wrapped, sealed []byte, ok bool, err error) {
+	rest, found := strings.CutPrefix(stored, sealedPasswordPrefix)
+	if !found {
+		return "", nil, nil, false, nil
+	}
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", nil, nil, false, errors.New("malformed sealed password")
+	}
+	if wrapped, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, false, err
+	}
+	if sealed, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, false, err
+	}
+	return parts[0], wrapped, sealed, true, nil
+}
+
+// reencryptPasswords seals every password that's in plaintext or under a key
+// other than the current one, for rotating keys. Rows changed in the
+// meantime are left for the next run.
+func reencryptPasswords(ctx context.Context, store Store, keys KeyProvider) (int, error) {
+	stored, err := store.getStoredPasswords()
+	if err != nil {
+		return 0, err
+	}
+
+	reencrypted := 0
+	for id, password := range stored {
+		keyID, _, _, sealed, err := parseSealedPassword(password)
+		if err != nil {
+			return reencrypted, fmt.Errorf("prize %s: %w", id, err)
+		}
+		if sealed && keyID == keys.KeyID() {
+			continue
+		}
+
+		plaintext, err := openPassword(ctx, keys, id, password)
+		if err != nil {
+			return reencrypted, fmt.Errorf("prize %s: %w", id, err)
+		}
+		resealed, err := sealPassword(ctx, keys, id, plaintext)
+		if err != nil {
+			return reencrypted, fmt.Errorf("prize %s: %w", id, err)
+		}
+
+		updated, err := store.replaceStoredPassword(id, password, resealed)
+		if err != nil {
+			return reencrypted, fmt.Errorf("prize %s: %w", id, err)
+		}
+		if updated {
+			reencrypted++
+		}
+	}
+	return reencrypted, nil
+}
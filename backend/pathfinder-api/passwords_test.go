@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKeySpec(ids ...string) string {
+	var entries []string
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		entries = append(entries, id+"="+base64.StdEncoding.EncodeToString(key[:]))
+	}
+	return strings.Join(entries, ",")
+}
+
+func testKeyProviders(t *testing.T, ids ...string) map[string]KeyProvider {
+	local, err := newLocalKeyProvider(testKeySpec(ids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kms, err := newMemoryKMS(testKeySpec(ids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]KeyProvider{"local": local, "kms": newKMSKeyProvider(kms, kms.current)}
+}
+
+func TestSealPassword(t *testing.T) {
+	ctx := context.Background()
+	id := "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+	password := "0xa0b1c2d3e4f5a0b1c2d3e4f5a0b1c2d3e4f5a0b1c2d3e4f5a0b1c2d3e4f5a0b1"
+
+	for name, keys := range testKeyProviders(t, "k1") {
+		sealed, err := sealPassword(ctx, keys, id, password)
+		if err != nil {
+			t.Fatalf("%s: sealPassword() error = %v", name, err)
+		}
+		if strings.Contains(sealed, password) || !strings.HasPrefix(sealed, sealedPasswordPrefix+"k1:") {
+			t.Errorf("%s: sealPassword() = %s", name, sealed)
+		}
+
+		opened, err := openPassword(ctx, keys, id, sealed)
+		if err != nil || opened != password {
+			t.Errorf("%s: openPassword() = %s, %v; want %s", name, opened, err, password)
+		}
+
+		if opened, err := openPassword(ctx, keys, strings.ToUpper(id), sealed); err != nil || opened != password {
+			t.Errorf("%s: openPassword() with an upper case id = %s, %v; want %s", name, opened, err, password)
+		}
+		if opened, err := openPassword(ctx, keys, "0x01", sealed); err == nil {
+			t.Errorf("%s: openPassword() for another prize = %s; want error", name, opened)
+		}
+		if _, err := openPassword(ctx, keys, id, sealed[:len(sealed)-4]+"AAAA"); err == nil {
+			t.Errorf("%s: openPassword() of a tampered password succeeded", name)
+		}
+	}
+}
+
+func TestOpenPasswordPlaintext(t *testing.T) {
+	opened, err := openPassword(context.Background(), nil, "0x01", "mySecretPassword")
+	if err != nil || opened != "mySecretPassword" {
+		t.Errorf("openPassword() = %s, %v; want mySecretPassword", opened, err)
+	}
+}
+
+func TestSealPasswordRotation(t *testing.T) {
+	ctx := context.Background()
+	old := testKeyProviders(t, "k1")
+	rotated := testKeyProviders(t, "k2", "k1")
+
+	for name, keys := range old {
+		sealed, err := sealPassword(ctx, keys, "0x01", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opened, err := openPassword(ctx, rotated[name], "0x01", sealed)
+		if err != nil || opened != "password" {
+			t.Errorf("%s: openPassword() after rotation = %s, %v; want password", name, opened, err)
+		}
+
+		resealed, err := sealPassword(ctx, rotated[name], "0x01", opened)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyID, _, _, _, _ := parseSealedPassword(resealed); keyID != "k2" {
+			t.Errorf("%s: sealPassword() after rotation used key %s; want k2", name, keyID)
+		}
+		if _, err := openPassword(ctx, keys, "0x01", resealed); err == nil {
+			t.Errorf("%s: openPassword() with only the old key succeeded", name)
+		}
+	}
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, current, err := parseMasterKeys("# rotated 2024-06\n" + testKeySpec("b", "a") + "\n")
+	if err != nil || current != "b" || len(keys) != 2 {
+		t.Errorf("parseMasterKeys() = %d keys, %s, %v; want 2 keys, b", len(keys), current, err)
+	}
+
+	for _, spec := range []string{
+		"",
+		"k1",
+		"k1=" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k:1=" + base64.StdEncoding.EncodeToString(make([]byte, dataKeySize)),
+		testKeySpec("k1") + "," + testKeySpec("k1"),
+	} {
+		if _, _, err := parseMasterKeys(spec); err == nil {
+			t.Errorf("parseMasterKeys(%q) = nil error; want error", spec)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPrizePage = 50
+	maxPrizePage     = 200
+)
+
+// prizeView is a prize as the read API shows it. Where it is and its
+// password are only there for the sender.
+type prizeView struct {
+	ID              string   `json:"id"`
+	Sender          string   `json:"sender"`
+	Type            string   `json:"type"`
+	ContractAddress string   `json:"contractAddress"`
+	Name            string   `json:"name,omitempty"`
+	Symbol          string   `json:"symbol,omitempty"`
+	Amount          *big.Int `json:"amount,omitempty"`
+	HashedPassword  string   `json:"hashedPassword,omitempty"`
+	Expires         int64    `json:"expires"`
+	Active          bool     `json:"active"`
+	ClaimedBy       string   `json:"claimedBy,omitempty"`
+	// Reclaimed is set when the sender took the drop back after it expired
+	Reclaimed bool     `json:"reclaimed,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Password  string   `json:"password,omitempty"`
+}
+
+// prizePage is one page of a prize listing. Next, if set, is the cursor for
+// the page after it.
+type prizePage struct {
+	Prizes []prizeView `json:"prizes"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// viewPrize shows prize to caller, revealing the private parts only if
+// caller sent it.
+func viewPrize(ctx context.Context, prize Prize, caller string) prizeView {
+	view := prizeView{
+		ID:              prize.ID,
+		Sender:          prize.Sender,
+		Type:            prize.Type,
+		ContractAddress: prize.ContractAddress,
+		Name:            prize.Name,
+		Symbol:          prize.Symbol,
+		Amount:          prize.Amount,
+		HashedPassword:  prize.HashedPassword,
+		Expires:         prize.Expires,
+		Active:          prize.Active,
+		ClaimedBy:       prize.ClaimedBy,
+		Reclaimed:       prize.ClaimedBy != "" && prize.ClaimedBy == prize.Sender,
+	}
+	if caller == "" || caller != prize.Sender {
+		return view
+	}
+
+	view.Latitude, view.Longitude = &prize.Latitude, &prize.Longitude
+	password, err := openPassword(ctx, passwordKeys, prize.ID, prize.Password)
+	if err != nil {
+		Sugar.Errorf("can't open password for prize %s: %s", prize.ID, err.Error())
+	}
+	view.Password = password
+	return view
+}
+
+// visibleTo is whether caller may see prize at all. Like /delta, nobody but
+// the sender sees a prize that's been moderated away or is in an exclusion
+// zone.
+func visibleTo(prize Prize, caller string) bool {
+	if caller != "" && caller == prize.Sender {
+		return true
+	}
+	return !prize.Hidden && exclusionZoneAt(prize.Latitude, prize.Longitude) == nil
+}
+
+// signedInAs is the caller's normalized address, or "" if they aren't
+// signed in.
+func signedInAs(r *http.Request) string {
+	caller, ok := callerAddress(r.Context())
+	if !ok {
+		return ""
+	}
+	return normalizeAddress(caller.Hex())
+}
+
+// getPrizeHandler serves GET /prizes/{id}.
+func (s *server) getPrizeHandler(w http.ResponseWriter, r *http.Request) {
+	id := normalizeAddress(mux.Vars(r)["id"])
+
+	prize, found, err := s.store.getPrize(id)
+	if err != nil {
+		http.Error(w, "Failed to retrieve prize", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	caller := signedInAs(r)
+	if !found || !visibleTo(prize, caller) {
+		http.Error(w, "Prize not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewPrize(r.Context(), prize, caller))
+}
+
+// listPrizesHandler serves GET /prizes?sender= and GET /prizes?claimedBy=,
+// a page of the drops an address sent or claimed. Pages run in id order,
+// limit at a time, and cursor is the previous page's next.
+func (s *server) listPrizesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sender, claimer := query.Get("sender"), query.Get("claimedBy")
+	if (sender == "") == (claimer == "") {
+		http.Error(w, "Need one of sender or claimedBy", http.StatusBadRequest)
+		return
+	}
+	if (sender != "" && !common.IsHexAddress(sender)) || (claimer != "" && !common.IsHexAddress(claimer)) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPrizePage
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPrizePage {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	cursor := normalizeAddress(query.Get("cursor"))
+
+	// one more than the page says whether there's another after it
+	var prizes []Prize
+	var err error
+	if sender != "" {
+		prizes, err = s.store.getPrizesBySender(normalizeAddress(sender), cursor, limit+1)
+	} else {
+		prizes, err = s.store.getPrizesClaimedBy(normalizeAddress(claimer), cursor, limit+1)
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve prizes", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+
+	page := prizePage{Prizes: []prizeView{}}
+	if len(prizes) > limit {
+		prizes = prizes[:limit]
+		page.Next = prizes[limit-1].ID
+	}
+	// the page is cut before moderation, so it can come up short but the
+	// cursor still moves past what was left out
+	caller := signedInAs(r)
+	for _, prize := range prizes {
+		if visibleTo(prize, caller) {
+			page.Prizes = append(page.Prizes, viewPrize(r.Context(), prize, caller))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// dropLogView is a drop event with whether it was a claim or a reclaim.
+type dropLogView struct {
+	DropLog
+	Kind string `json:"kind"`
+}
+
+// listDropLogsHandler serves GET /prizes/{id}/events, the drop's on-chain
+// history oldest first. It's all public on chain anyway.
+func (s *server) listDropLogsHandler(w http.ResponseWriter, r *http.Request) {
+	logs, err := s.store.getDropLogs(normalizeAddress(mux.Vars(r)["id"]))
+	if err != nil {
+		http.Error(w, "Failed to retrieve drop events", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+
+	views := []dropLogView{}
+	for _, log := range logs {
+		views = append(views, dropLogView{DropLog: log, Kind: log.kind()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
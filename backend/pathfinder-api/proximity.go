@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// proximityBucket is one ring around the user: anything up to MaxMeters away
+// that isn't in a smaller ring is labelled Label. Inside the precise radius
+// its distance is given to the nearest RoundMeters; outside it the distance
+// is just MaxMeters and its direction is knocked up to NoiseDegrees either
+// way and then snapped to the nearest multiple of StepDegrees, so it can't be
+// triangulated or trilaterated from afar.
+type proximityBucket struct {
+	Label        string  `json:"label"`
+	MaxMeters    float64 `json:"maxMeters"`
+	RoundMeters  float64 `json:"roundMeters"`
+	NoiseDegrees float64 `json:"noiseDegrees"`
+	StepDegrees  float64 `json:"stepDegrees"`
+}
+
+// proximityConfig drives how deltas are bucketed, how far out each type is
+// searched for ("prize" covers prize types without their own entry) and how
+// close someone has to be to see a prize's password or its exact direction.
+type proximityConfig struct {
+	Buckets       []proximityBucket  `json:"buckets"`
+	RadiusMeters  map[string]float64 `json:"radiusMeters"`
+	RevealMeters  float64            `json:"revealMeters"`
+	PreciseMeters float64            `json:"preciseMeters"`
+}
+
+var proximity = defaultProximityConfig()
+
+// directionSecret seeds direction noise. It has to stay the same across
+// restarts and replicas, or viewers could average the noise away.
+var directionSecret []byte
+
+func defaultProximityConfig() proximityConfig {
+	return proximityConfig{
+		Buckets: []proximityBucket{
+			{Label: "<10m", MaxMeters: 10, RoundMeters: 1},
+			{Label: "<100m", MaxMeters: 100, RoundMeters: 10},
+			{Label: "<250m", MaxMeters: 250, NoiseDegrees: 10},
+			{Label: "<500m", MaxMeters: 500, NoiseDegrees: 15},
+			{Label: "<1km", MaxMeters: 1000, NoiseDegrees: 20},
+			{Label: "<3km", MaxMeters: 3000, NoiseDegrees: 25, StepDegrees: 22.5},
+			{Label: "<5km", MaxMeters: 5000, NoiseDegrees: 30, StepDegrees: 45},
+			{Label: "<8km", MaxMeters: 8000, NoiseDegrees: 30, StepDegrees: 45},
+			{Label: "<10km", MaxMeters: 10000, NoiseDegrees: 30, StepDegrees: 45},
+		},
+		RadiusMeters: map[string]float64{
+			"prize":   10000,
+			"message": 8000,
+		},
+		RevealMeters:  10,
+		PreciseMeters: 100,
+	}
+}
+
+// initProximity reads DIRECTION_SECRET, which is required, and loads
+// PROXIMITY_CONFIG, a JSON file laid out like proximityConfig, if it's set.
+func initProximity() {
+	directionSecret = []byte(os.Getenv("DIRECTION_SECRET"))
+	if len(directionSecret) < 32 {
+		Sugar.Fatal("DIRECTION_SECRET must be set to at least 32 bytes")
+	}
+
+	path := os.Getenv("PROXIMITY_CONFIG")
+	if path == "" {
+		return
+	}
+	config, err := loadProximityConfig(path)
+	if err != nil {
+		Sugar.Fatalf("invalid PROXIMITY_CONFIG: %s", err.Error())
+	}
+	proximity = config
+	Sugar.Infof("loaded %d proximity buckets from %s", len(config.Buckets), path)
+}
+
+func loadProximityConfig(path string) (proximityConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return proximityConfig{}, err
+	}
+	var config proximityConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return proximityConfig{}, err
+	}
+	return config, config.validate()
+}
+
+// validate checks the buckets go outwards and cover every search radius, so
+// nothing that's found ends up without a bucket.
+func (c proximityConfig) validate() error {
+	if len(c.Buckets) == 0 {
+		return fmt.Errorf("no buckets")
+	}
+	labels := map[string]bool{}
+	for i, b := range c.Buckets {
+		if b.Label == "" || labels[b.Label] {
+			return fmt.Errorf("bucket %d needs a unique label", i)
+		}
+		labels[b.Label] = true
+		if b.MaxMeters <= 0 || (i > 0 && b.MaxMeters <= c.Buckets[i-1].MaxMeters) {
+			return fmt.Errorf("bucket %s must be further out than the one before", b.Label)
+		}
+		if b.RoundMeters < 0 {
+			return fmt.Errorf("bucket %s has a negative rounding", b.Label)
+		}
+		if b.NoiseDegrees < 0 || b.NoiseDegrees > 180 || b.StepDegrees < 0 || b.StepDegrees > 360 {
+			return fmt.Errorf("bucket %s has out of range direction fuzzing", b.Label)
+		}
+	}
+
+	outer := c.Buckets[len(c.Buckets)-1].MaxMeters
+	for _, kind := range []string{"prize", "message"} {
+		if _, ok := c.RadiusMeters[kind]; !ok {
+			return fmt.Errorf("no %s radius", kind)
+		}
+	}
+	for kind, radius := range c.RadiusMeters {
+		if radius <= 0 || radius > outer {
+			return fmt.Errorf("%s radius must be within the outermost bucket, %gm", kind, outer)
+		}
+	}
+	if c.RevealMeters <= 0 {
+		return fmt.Errorf("reveal distance must be positive")
+	}
+	if c.PreciseMeters < 0 {
+		return fmt.Errorf("precise direction radius can't be negative")
+	}
+	return nil
+}
+
+// bucket is the innermost bucket meters falls in.
+func (c proximityConfig) bucket(meters float64) (proximityBucket, bool) {
+	for _, b := range c.Buckets {
+		if meters <= b.MaxMeters {
+			return b, true
+		}
+	}
+	return proximityBucket{}, false
+}
+
+// radius is how far out kind, a prize type or "message", is searched for.
+func (c proximityConfig) radius(kind string) float64 {
+	if radius, ok := c.RadiusMeters[kind]; ok {
+		return radius
+	}
+	return c.RadiusMeters["prize"]
+}
+
+// prizeRadius is the furthest out any prize type is searched for.
+func (c proximityConfig) prizeRadius() float64 {
+	furthest := 0.0
+	for kind, radius := range c.RadiusMeters {
+		if kind != "message" && radius > furthest {
+			furthest = radius
+		}
+	}
+	return furthest
+}
+
+// place finds a distance in metres its bucket and rounds it to the bucket,
+// returning false past the outermost bucket. Outside the precise radius the
+// distance is the bucket's edge, rounding alone would still leave enough to
+// trilaterate from.
+func (c proximityConfig) place(meters float64) (proximityBucket, float64, bool) {
+	b, ok := c.bucket(meters)
+	if !ok {
+		return proximityBucket{}, 0, false
+	}
+	if meters > c.PreciseMeters {
+		return b, b.MaxMeters, true
+	}
+	rounded := meters
+	if b.RoundMeters > 0 {
+		rounded = math.Min(math.Round(meters/b.RoundMeters)*b.RoundMeters, b.MaxMeters)
+	}
+	return b, rounded, true
+}
+
+// direction is the bearing shown for something meters away in bucket b, to
+// a viewer at lat, lon. Inside the precise radius that's the real bearing.
+// Further out the noise is seeded by the thing, the viewer and the
+// bucket sized cell they're in: asking again from the same spot gives the
+// same answer, so there's nothing to average, while bearings taken from
+// different spots don't share one offset that could be solved for.
+func (c proximityConfig) direction(secret []byte, id, viewer string, lat, lon, bearing, meters float64, b proximityBucket) float64 {
+	if meters <= c.PreciseMeters {
+		return bearing
+	}
+	if b.NoiseDegrees > 0 {
+		row, column := noiseCell(lat, lon, b.MaxMeters)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(fmt.Sprintf("direction:%s:%s:%d:%d", id, viewer, row, column)))
+		u := float64(binary.BigEndian.Uint64(mac.Sum(nil))) / math.MaxUint64
+		bearing += (u*2 - 1) * b.NoiseDegrees
+	}
+	if b.StepDegrees > 0 {
+		bearing = math.Round(bearing/b.StepDegrees) * b.StepDegrees
+	}
+	// back into atan2's (-180, 180]
+	bearing = math.Mod(bearing, 360)
+	if bearing > 180 {
+		bearing -= 360
+	} else if bearing <= -180 {
+		bearing += 360
+	}
+	return bearing
+}
+
+// noiseCell is the cell of a grid about size metres square that lat, lon
+// falls in.
+func noiseCell(lat, lon, size float64) (int64, int64) {
+	degrees := size / 1000 / (earthRadiusKm * math.Pi / 180)
+	return int64(math.Floor(lat / degrees)), int64(math.Floor(lon / degrees))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProximityPlace(t *testing.T) {
+	config := defaultProximityConfig()
+	cases := []struct {
+		meters float64
+		label  string
+		shown  float64
+	}{
+		{4.4, "<10m", 4},
+		{10, "<10m", 10},
+		{10.1, "<100m", 10},
+		{96, "<100m", 100},
+		// past the precise radius only the bucket is given away
+		{180, "<250m", 250},
+		{2890, "<3km", 3000},
+		{9400, "<10km", 10000},
+	}
+	for _, c := range cases {
+		b, shown, ok := config.place(c.meters)
+		if !ok || b.Label != c.label || shown != c.shown {
+			t.Errorf("place(%g) = %s, %g, %v; want %s, %g", c.meters, b.Label, shown, ok, c.label, c.shown)
+		}
+	}
+	if _, _, ok := config.place(10001); ok {
+		t.Errorf("place() past the outermost bucket = true")
+	}
+}
+
+func TestProximityConfigValidate(t *testing.T) {
+	if err := defaultProximityConfig().validate(); err != nil {
+		t.Fatalf("validate() of the defaults = %v", err)
+	}
+
+	invalid := map[string]func(*proximityConfig){
+		"no buckets":       func(c *proximityConfig) { c.Buckets = nil },
+		"unordered":        func(c *proximityConfig) { c.Buckets[1].MaxMeters = 5 },
+		"duplicate label":  func(c *proximityConfig) { c.Buckets[1].Label = "<10m" },
+		"radius too far":   func(c *proximityConfig) { c.RadiusMeters["erc721"] = 20000 },
+		"no message":       func(c *proximityConfig) { delete(c.RadiusMeters, "message") },
+		"no reveal radius": func(c *proximityConfig) { c.RevealMeters = 0 },
+	}
+	for name, change := range invalid {
+		config := defaultProximityConfig()
+		change(&config)
+		if err := config.validate(); err == nil {
+			t.Errorf("validate() with %s = nil error", name)
+		}
+	}
+}
+
+func TestLoadProximityConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proximity.json")
+	os.WriteFile(path, []byte(`{
+		"buckets": [{"label": "here", "maxMeters": 20}, {"label": "near", "maxMeters": 500, "roundMeters": 100}],
+		"radiusMeters": {"prize": 500, "message": 200, "eth": 100},
+		"revealMeters": 20,
+		"preciseMeters": 20
+	}`), 0o600)
+
+	config, err := loadProximityConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.radius("eth") != 100 || config.radius("erc20") != 500 || config.prizeRadius() != 500 {
+		t.Errorf("loadProximityConfig() radii = %v", config.RadiusMeters)
+	}
+	if b, shown, _ := config.place(13.7); b.Label != "here" || shown != 13.7 {
+		t.Errorf("place() without rounding = %s, %g; want here, 13.7", b.Label, shown)
+	}
+}
+
+func TestFilterPrizeDeltasRadiusByType(t *testing.T) {
+	defer func(saved proximityConfig) { proximity = saved }(proximity)
+	proximity = defaultProximityConfig()
+	proximity.RadiusMeters["eth"] = 1000
+
+	userLocation := UserLocation{Latitude: 51.4687367, Longitude: -0.0399826}
+	// about 1.24km away
+	far := Prize{ID: "far", Latitude: 51.4578328, Longitude: -0.0360868, Amount: big.NewInt(1)}
+	near := Prize{ID: "near", Latitude: 51.46874, Longitude: -0.0399826, Password: "pw", Amount: big.NewInt(1)}
+
+	far.Type, near.Type = "eth", "eth"
+	deltas := filterPrizeDeltas(context.Background(), userLocation, "", []Prize{far, near}, true)
+	if len(deltas) != 1 || deltas[0].ID != "near" || deltas[0].Password != "pw" || deltas[0].Proximity != "<10m" || !deltas[0].Precise || !deltas[0].InReach {
+		t.Errorf("filterPrizeDeltas() with a 1km eth radius = %+v", deltas)
+	}
+
+	far.Type = "erc20"
+	deltas = filterPrizeDeltas(context.Background(), userLocation, "", []Prize{far}, true)
+	if len(deltas) != 1 || deltas[0].Proximity != "<3km" || deltas[0].DistanceMeters != 3000 || deltas[0].Password != "" || deltas[0].Precise || deltas[0].InReach {
+		t.Errorf("filterPrizeDeltas() of an erc20 prize = %+v", deltas)
+	}
+}
+
+func TestProximityDirection(t *testing.T) {
+	config := defaultProximityConfig()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	near, _, _ := config.place(60)
+	if d := config.direction(secret, "a", "ip:1.2.3.4", 51.5, -0.1, 33.3, 60, near); d != 33.3 {
+		t.Errorf("direction() inside the precise radius = %g; want 33.3", d)
+	}
+
+	mid, _, _ := config.place(700)
+	d := config.direction(secret, "a", "ip:1.2.3.4", 51.5, -0.1, 33.3, 700, mid)
+	if d == 33.3 || d < 33.3-mid.NoiseDegrees || d > 33.3+mid.NoiseDegrees {
+		t.Errorf("direction() at 700m = %g; want within %g of 33.3", d, mid.NoiseDegrees)
+	}
+	if again := config.direction(secret, "a", "ip:1.2.3.4", 51.5, -0.1, 33.3, 700, mid); again != d {
+		t.Errorf("direction() asked again = %g; want %g", again, d)
+	}
+	if other := config.direction(secret, "a", "ip:5.6.7.8", 51.5, -0.1, 33.3, 700, mid); other == d {
+		t.Errorf("direction() for another viewer = %g; want different noise", other)
+	}
+	// a few cells over the noise is drawn afresh
+	if moved := config.direction(secret, "a", "ip:1.2.3.4", 51.5+0.04, -0.1, 33.3, 700, mid); moved == d {
+		t.Errorf("direction() from a few km away = %g; want different noise", moved)
+	}
+
+	far, _, _ := config.place(6000)
+	for _, bearing := range []float64{-179, -90, 0, 12, 179} {
+		d := config.direction(secret, "b", "ip:1.2.3.4", 51.5, -0.1, bearing, 6000, far)
+		if math.Mod(d, far.StepDegrees) != 0 || d <= -180 || d > 180 {
+			t.Errorf("direction() of %g at 6km = %g; want a multiple of %g in (-180, 180]", bearing, d, far.StepDegrees)
+		}
+	}
+}
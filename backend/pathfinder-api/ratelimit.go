@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateBudget is a token bucket: Burst tokens, refilled at Rate per second.
+type rateBudget struct {
+	Rate  float64
+	Burst float64
+}
+
+// parseRateBudget reads "<requests>/<period>", e.g. 30/1m, as a bucket that
+// holds that many requests and refills over the period.
+func parseRateBudget(v string) (rateBudget, error) {
+	count, period, ok := strings.Cut(v, "/")
+	if !ok {
+		return rateBudget{}, fmt.Errorf("rate limit %q isn't <requests>/<period>", v)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 1 {
+		return rateBudget{}, fmt.Errorf("rate limit %q needs at least 1 request", v)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return rateBudget{}, fmt.Errorf("rate limit %q has a bad period", v)
+	}
+	return rateBudget{Rate: n / d.Seconds(), Burst: n}, nil
+}
+
+// rateLimitRoutes groups routes, by method and path, into the budgets
+// below, so reading a listing doesn't spend what posting to it would.
+// Anything not listed gets the default budget.
+var rateLimitRoutes = map[string]string{
+	"POST /delta":                           "delta",
+	"GET /delta/stream":                     "delta",
+	"POST /messages":                        "messages",
+	"GET /messages":                         "reads",
+	"PUT /messages/{id:[0-9]+}":             "messages",
+	"DELETE /messages/{id:[0-9]+}":          "messages",
+	"POST /messages/{id:[0-9]+}/appraisals": "messages",
+	"GET /messages/nonce":                   "nonce",
+	"POST /prizes":                          "prizes",
+	"GET /prizes":                           "reads",
+	"GET /prizes/nonce":                     "nonce",
+	"GET /auth/nonce":                       "auth",
+	"POST /auth/login":                      "auth",
+	"POST /auth/logout":                     "auth",
+	"GET /auth/session":                     "auth",
+	"POST /reports":                         "reports",
+}
+
+// defaultRateBudgets apply to each IP and each signed in address separately.
+// They can be overridden with RATE_LIMIT_<BUDGET>, e.g. RATE_LIMIT_DELTA=60/1m.
+var defaultRateBudgets = map[string]string{
+	"delta":    "60/1m", // the UI polls every 2.5s
+	"messages": "10/1m",
+	"prizes":   "10/1m",
+	"reads":    "60/1m",
+	"nonce":    "30/1m",
+	"auth":     "20/1m",
+	"reports":  "10/1h",
+	"default":  "120/1m",
+}
+
+// rateLimitStore keeps the buckets. take spends a token from key's bucket if
+// it has one, otherwise it says how long until it will.
+type rateLimitStore interface {
+	take(ctx context.Context, key string, budget rateBudget, now time.Time) (bool, time.Duration, error)
+}
+
+type rateLimiter struct {
+	store      rateLimitStore
+	budgets    map[string]rateBudget
+	trustProxy bool
+}
+
+var limiter *rateLimiter
+
+// newRateLimiterFromEnv picks the store with RATE_LIMIT_STORE, postgres
+// (shared by every replica, the default when store is postgres) or memory. RATE_LIMIT_TRUST_PROXY
+// keys requests by the address the proxy in front appends to
+// X-Forwarded-For rather than the connection's.
+func newRateLimiterFromEnv(store Store) *rateLimiter {
+	budgets := map[string]rateBudget{}
+	var idle time.Duration
+	for route, fallback := range defaultRateBudgets {
+		v := os.Getenv("RATE_LIMIT_" + strings.ToUpper(route))
+		if v == "" {
+			v = fallback
+		}
+		budget, err := parseRateBudget(v)
+		if err != nil {
+			Sugar.Fatalf("invalid RATE_LIMIT_%s: %s", strings.ToUpper(route), err.Error())
+		}
+		budgets[route] = budget
+		if refilled := time.Duration(budget.Burst / budget.Rate * float64(time.Second)); refilled > idle {
+			idle = refilled
+		}
+	}
+
+	pg, onPostgres := store.(*postgresStore)
+	kind := os.Getenv("RATE_LIMIT_STORE")
+	if kind == "" {
+		kind = "memory"
+		if onPostgres {
+			kind = "postgres"
+		}
+	}
+
+	var buckets rateLimitStore
+	switch kind {
+	case "postgres":
+		if !onPostgres {
+			Sugar.Fatal("RATE_LIMIT_STORE=postgres needs STORE=postgres")
+		}
+		buckets = &postgresRateLimitStore{store: pg, idle: idle}
+	case "memory":
+		buckets = newMemoryRateLimitStore()
+	default:
+		Sugar.Fatalf("unknown RATE_LIMIT_STORE %q", kind)
+	}
+
+	return &rateLimiter{store: buckets, budgets: budgets, trustProxy: os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true"}
+}
+
+// route is the budget a request is charged to.
+func (l *rateLimiter) route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if path, err := current.GetPathTemplate(); err == nil {
+			if route, ok := rateLimitRoutes[r.Method+" "+path]; ok {
+				return route
+			}
+		}
+	}
+	return "default"
+}
+
+// clientIP is the address a request came from. Behind a trusted proxy that's
+// the last X-Forwarded-For entry, the one the proxy added; earlier entries
+// are whatever the client sent.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// middleware limits each route by IP and, for signed in callers, by address
+// too, so neither rotating IPs nor sharing one gets around it. It has to run
+// after sessionMiddleware. Store errors let requests through.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := l.route(r)
+		budget := l.budgets[route]
+
+		keys := []string{fmt.Sprintf("ip:%s:%s", route, l.clientIP(r))}
+		if caller, ok := callerAddress(r.Context()); ok {
+			keys = append(keys, fmt.Sprintf("address:%s:%s", route, normalizeAddress(caller.Hex())))
+		}
+
+		now := time.Now()
+		for _, key := range keys {
+			allowed, retryAfter, err := l.store.take(r.Context(), key, budget, now)
+			if err != nil {
+				Sugar.Error(err)
+				continue
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refill tops a bucket up for the time since it was last touched and spends
+// a token if there's one, returning the new level and how long until a token
+// would be available when there isn't.
+func refill(tokens float64, updated time.Time, budget rateBudget, now time.Time) (float64, bool, time.Duration) {
+	elapsed := now.Sub(updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens = math.Min(budget.Burst, tokens+elapsed*budget.Rate)
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / budget.Rate * float64(time.Second))
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return tokens, false, wait
+}
+
+// memoryRateLimitStore keeps buckets in process, for a single replica.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (s *memoryRateLimitStore) take(ctx context.Context, key string, budget rateBudget, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// buckets that have refilled are the same as no bucket at all
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: budget.Burst, updated: now}
+		s.buckets[key] = b
+	}
+	tokens, allowed, wait := refill(b.tokens, b.updated, budget, now)
+	b.tokens, b.updated = tokens, now
+	b.full = now.Add(time.Duration((budget.Burst - tokens) / budget.Rate * float64(time.Second)))
+	return allowed, wait, nil
+}
+
+// postgresRateLimitStore keeps buckets in the rate_limits table so every
+// replica draws on the same ones. Buckets idle for longer than idle, the
+// longest any budget takes to refill, are swept once a minute.
+type postgresRateLimitStore struct {
+	store *postgresStore
+	idle  time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (s *postgresRateLimitStore) take(ctx context.Context, key string, budget rateBudget, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		if err := s.store.deleteIdleRateLimits(now.Add(-s.idle)); err != nil {
+			Sugar.Error(err)
+		}
+	}
+	s.mu.Unlock()
+
+	tokens, allowed, err := s.store.takeRateLimitToken(key, budget.Rate, budget.Burst, now)
+	if err != nil || allowed {
+		return allowed, 0, err
+	}
+	_, _, wait := refill(tokens, now, budget, now)
+	return false, wait, nil
+}
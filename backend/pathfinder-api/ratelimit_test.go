@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+func TestParseRateBudget(t *testing.T) {
+	budget, err := parseRateBudget("30/1m")
+	if err != nil || budget.Burst != 30 || budget.Rate != 0.5 {
+		t.Errorf("parseRateBudget(30/1m) = %+v, %v", budget, err)
+	}
+	for _, v := range []string{"", "30", "0/1m", "30/soon", "30/-1m"} {
+		if _, err := parseRateBudget(v); err == nil {
+			t.Errorf("parseRateBudget(%q) = nil error", v)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := newMemoryRateLimitStore()
+	budget := rateBudget{Rate: 1, Burst: 3}
+	at := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := store.take(ctx, "a", budget, at); !ok {
+			t.Fatalf("take() %d within the burst = false", i)
+		}
+	}
+	ok, wait, _ := store.take(ctx, "a", budget, at)
+	if ok || wait != time.Second {
+		t.Errorf("take() past the burst = %v, %s; want false, 1s", ok, wait)
+	}
+	if ok, _, _ := store.take(ctx, "b", budget, at); !ok {
+		t.Errorf("take() for another key = false")
+	}
+	if ok, _, _ := store.take(ctx, "a", budget, at.Add(time.Second)); !ok {
+		t.Errorf("take() after a refill = false")
+	}
+
+	// a long idle bucket refills to its burst and no further
+	later := at.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		store.take(ctx, "a", budget, later)
+	}
+	if ok, _, _ := store.take(ctx, "a", budget, later); ok {
+		t.Errorf("take() beyond the burst after idling = true")
+	}
+}
+
+func TestRefillRetryAfter(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	_, ok, wait := refill(0, at, rateBudget{Rate: 0.1, Burst: 5}, at)
+	if ok || wait != time.Second*10 {
+		t.Errorf("refill() of an empty bucket = %v, %s; want false, 10s", ok, wait)
+	}
+}
+
+func testLimitedRouter(l *rateLimiter) *mux.Router {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/delta", ok)
+	r.HandleFunc("/messages", ok)
+	r.Use(sessionMiddleware)
+	r.Use(l.middleware)
+	return r
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	l := &rateLimiter{
+		store: newMemoryRateLimitStore(),
+		budgets: map[string]rateBudget{
+			"delta":    {Rate: 1.0 / 60, Burst: 2},
+			"messages": {Rate: 1.0 / 60, Burst: 1},
+			"reads":    {Rate: 1.0 / 60, Burst: 1},
+			"default":  {Rate: 1, Burst: 1},
+		},
+	}
+	router := testLimitedRouter(l)
+	serveMethod := func(method, path, remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remote
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	serve := func(path, remote, token string) *httptest.ResponseRecorder {
+		return serveMethod(http.MethodPost, path, remote, token)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("/delta", "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d = %d; want 200", i, w.Code)
+		}
+	}
+	w := serve("/delta", "10.0.0.1:1234", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("request past the budget = %d, Retry-After %q; want 429, 60", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve("/messages", "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("another route's budget = %d; want 200", w.Code)
+	}
+	if w := serve("/delta", "10.0.0.2:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("another IP = %d; want 200", w.Code)
+	}
+	// reading the listing doesn't spend the budget for posting to it
+	if w := serveMethod(http.MethodGet, "/messages", "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("GET after the POST budget is spent = %d; want 200", w.Code)
+	}
+
+	// a signed in address is limited across every IP it uses
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	token := issueSessionToken(sessionSecret, common.HexToAddress(fixtureSigner), time.Now().Add(time.Hour))
+	if w := serve("/messages", "10.0.0.3:1234", token); w.Code != http.StatusOK {
+		t.Fatalf("signed in request = %d; want 200", w.Code)
+	}
+	if w := serve("/messages", "10.0.0.4:1234", token); w.Code != http.StatusTooManyRequests {
+		t.Errorf("signed in request from a new IP = %d; want 429", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+
+	if ip := (&rateLimiter{}).clientIP(req); ip != "10.0.0.1" {
+		t.Errorf("clientIP() without a trusted proxy = %s; want 10.0.0.1", ip)
+	}
+	if ip := (&rateLimiter{trustProxy: true}).clientIP(req); ip != "5.6.7.8" {
+		t.Errorf("clientIP() behind a trusted proxy = %s; want 5.6.7.8", ip)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+const otherSigner = "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"
+
+// fakeChain has no contracts deployed, so every signer is an EOA, and only
+// the drops put in it have been locked.
+type fakeChain struct {
+	drops map[common.Hash]dropLock
+}
+
+func (c *fakeChain) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *fakeChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, errors.New("no contracts on the fake chain")
+}
+
+func (c *fakeChain) getDropLock(ctx context.Context, id common.Hash) (dropLock, error) {
+	return c.drops[id], nil
+}
+
+// testServer is every route over a memoryStore and a fakeChain, with
+// fixtureSigner as the admin.
+type testServer struct {
+	t       *testing.T
+	store   *memoryStore
+	chain   *fakeChain
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	directionSecret = []byte("fedcba9876543210fedcba9876543210")
+	chainID = big.NewInt(1337)
+	sessionTTL = time.Hour
+	siweDomains = []string{"dropwhere.xyz"}
+	keys, err := newLocalKeyProvider(testKeySpec("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordKeys = keys
+	budgets := map[string]rateBudget{}
+	for route := range defaultRateBudgets {
+		budgets[route] = rateBudget{Rate: 1000, Burst: 1000}
+	}
+	limiter = &rateLimiter{store: newMemoryRateLimitStore(), budgets: budgets}
+	deltaUpdates = newDeltaHub()
+	admins = map[common.Address]bool{common.HexToAddress(fixtureSigner): true}
+	exclusionZones.Store(nil)
+	t.Cleanup(func() {
+		admins = nil
+		exclusionZones.Store(nil)
+	})
+
+	store := newMemoryStore()
+	chain := &fakeChain{drops: map[common.Hash]dropLock{}}
+	movement = newMovementTracker(movementConfigFromEnv(), store)
+	indexer = newDropIndexer(nil, nil, store)
+	s := &server{store: store, chain: chain}
+	return &testServer{t: t, store: store, chain: chain, handler: s.routes(nil)}
+}
+
+func (ts *testServer) token(address string) string {
+	return issueSessionToken(sessionSecret, common.HexToAddress(address), time.Now().Add(time.Hour))
+}
+
+// do sends a request as whoever token is for, or anonymously without one.
+func (ts *testServer) do(method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *testServer) expect(method, path, token, body string, want int) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	w := ts.do(method, path, token, body)
+	if w.Code != want {
+		ts.t.Fatalf("%s %s = %d %q; want %d", method, path, w.Code, w.Body.String(), want)
+	}
+	return w
+}
+
+func (ts *testServer) deltas(token string, lat, lon float64) []Delta {
+	ts.t.Helper()
+	body, _ := json.Marshal(UserLocation{Latitude: lat, Longitude: lon})
+	w := ts.expect(http.MethodPost, "/delta", token, string(body), http.StatusOK)
+	var deltas []Delta
+	if err := json.NewDecoder(w.Body).Decode(&deltas); err != nil {
+		ts.t.Fatal(err)
+	}
+	return deltas
+}
+
+func storeMessageBody(sender string, lat, lon float64) string {
+	return `{"message": {"sender": "` + sender + `", "text": [0, 0, 0], "latitude": ` +
+		jsonNumber(lat) + `, "longitude": ` + jsonNumber(lon) + `}}`
+}
+
+func jsonNumber(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+func TestServerMessages(t *testing.T) {
+	ts := newTestServer(t)
+	sender, other := ts.token(fixtureSigner), ts.token(otherSigner)
+
+	w := ts.expect(http.MethodPost, "/messages", sender, storeMessageBody(fixtureSigner, 51.5, -0.1), http.StatusCreated)
+	if w.Body.String() != "id: 1" {
+		t.Errorf("POST /messages = %q; want id: 1", w.Body.String())
+	}
+	// anyone else has to sign
+	ts.expect(http.MethodPost, "/messages", other, storeMessageBody(fixtureSigner, 51.5, -0.1), http.StatusBadRequest)
+
+	deltas := ts.deltas("", 51.5, -0.1)
+	if len(deltas) != 1 || deltas[0].ID != "1" || deltas[0].Type != "message" || deltas[0].Rendered == "" {
+		t.Fatalf("POST /delta = %+v; want message 1", deltas)
+	}
+
+	ts.expect(http.MethodPut, "/messages/1", sender, `{"sender": "`+fixtureSigner+`", "text": [1, 0, 0]}`, http.StatusNoContent)
+	ts.expect(http.MethodPut, "/messages/2", sender, `{"sender": "`+fixtureSigner+`", "text": [1, 0, 0]}`, http.StatusNotFound)
+
+	appraisal := `{"appraiser": "` + otherSigner + `", "helpful": true}`
+	ts.expect(http.MethodPost, "/messages/1/appraisals", other, appraisal, http.StatusNoContent)
+	ts.expect(http.MethodPost, "/messages/1/appraisals", sender, `{"appraiser": "`+fixtureSigner+`", "helpful": true}`, http.StatusForbidden)
+
+	w = ts.expect(http.MethodGet, "/messages?sender="+fixtureSigner, sender, "", http.StatusOK)
+	var messages []Message
+	json.NewDecoder(w.Body).Decode(&messages)
+	if len(messages) != 1 || messages[0].Text[0] != 1 || messages[0].Helpful != 1 {
+		t.Errorf("GET /messages = %+v; want the edited message with its appraisal", messages)
+	}
+	ts.expect(http.MethodGet, "/messages?sender="+fixtureSigner, other, "", http.StatusForbidden)
+
+	ts.expect(http.MethodDelete, "/messages/1", sender, `{"sender": "`+fixtureSigner+`"}`, http.StatusNoContent)
+	ts.expect(http.MethodDelete, "/messages/1", sender, `{"sender": "`+fixtureSigner+`"}`, http.StatusNotFound)
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 0 {
+		t.Errorf("POST /delta after delete = %+v; want nothing", deltas)
+	}
+}
+
+func TestServerNonces(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.expect(http.MethodGet, "/messages/nonce?sender=nope", "", "", http.StatusBadRequest)
+	w := ts.expect(http.MethodGet, "/prizes/nonce?sender="+fixtureSigner, "", "", http.StatusOK)
+	var issued SignatureNonce
+	json.NewDecoder(w.Body).Decode(&issued)
+	if used, _ := ts.store.useSignatureNonce(normalizeAddress(fixtureSigner), issued.Nonce); !used {
+		t.Errorf("nonce %s wasn't stored for the sender", issued.Nonce)
+	}
+
+	w = ts.expect(http.MethodGet, "/auth/nonce", "", "", http.StatusOK)
+	var login struct {
+		Nonce string `json:"nonce"`
+	}
+	json.NewDecoder(w.Body).Decode(&login)
+	if used, _ := ts.store.useLoginNonce(login.Nonce); !used {
+		t.Errorf("login nonce %s wasn't stored", login.Nonce)
+	}
+}
+
+// signedPrize is the body to POST /prizes with, signed by key over a nonce
+// fetched for it.
+func (ts *testServer) signedPrize(prize Prize, key *ecdsa.PrivateKey) string {
+	ts.t.Helper()
+	var issued SignatureNonce
+	json.NewDecoder(ts.expect(http.MethodGet, "/prizes/nonce?sender="+prize.Sender, "", "", http.StatusOK).Body).Decode(&issued)
+
+	prizeInput := PrizeInput{Prize: prize, Nonce: issued.Nonce, Deadline: issued.Deadline}
+	hash, _, err := apitypes.TypedDataAndHash(prizeTypedData(prizeInput))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	prizeInput.Signature = hexutil.Encode(sig)
+
+	// amounts are posted as decimal strings
+	body, _ := json.Marshal(prizeInput)
+	return strings.Replace(string(body), `"amount":`+prize.Amount.String(), `"amount":"`+prize.Amount.String()+`"`, 1)
+}
+
+func TestServerStorePrize(t *testing.T) {
+	ts := newTestServer(t)
+	prize, key := testPrize()
+	prize.Latitude, prize.Longitude = 51.5, -0.1
+	prize.Expires = time.Now().Add(time.Hour).Unix()
+	id := normalizeAddress(prize.ID)
+
+	// posted before the lock tx, so not active until the indexer sees it
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, key), http.StatusCreated)
+	stored, found, _ := ts.store.getPrizeLockByID(id)
+	if !found || stored.Active || stored.Sender != normalizeAddress(prize.Sender) || stored.Password == prize.Password {
+		t.Fatalf("stored prize = %+v, %v; want it inactive with the password sealed", stored, found)
+	}
+
+	body := ts.signedPrize(prize, key)
+	ts.expect(http.MethodPost, "/prizes", "", body, http.StatusCreated)
+	// the nonce is used up
+	ts.expect(http.MethodPost, "/prizes", "", body, http.StatusBadRequest)
+
+	ts.chain.drops[common.HexToHash(prize.ID)] = dropLock{
+		Sender:          common.HexToAddress(prize.Sender),
+		HashedPassword:  common.HexToHash(prize.HashedPassword),
+		PrizeType:       prize.Type,
+		ContractAddress: prize.contractAddress(),
+		Amount:          big.NewInt(999),
+		Expiry:          big.NewInt(prize.Expires),
+	}
+	w := ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, key), http.StatusBadRequest)
+	if !strings.Contains(w.Body.String(), "amount") {
+		t.Errorf("POST /prizes not matching the drop = %q; want the amount named", w.Body.String())
+	}
+
+	ts.chain.drops[common.HexToHash(prize.ID)].Amount.Set(prize.Amount)
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, key), http.StatusCreated)
+	if stored, _, _ := ts.store.getPrizeLockByID(id); !stored.Active {
+		t.Errorf("stored prize after its drop is on chain = %+v; want it active", stored)
+	}
+
+	// signed by someone else
+	other, _ := crypto.GenerateKey()
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, other), http.StatusBadRequest)
+}
+
+func TestServerStorePrizeSquatted(t *testing.T) {
+	ts := newTestServer(t)
+	prize, key := testPrize()
+	prize.Latitude, prize.Longitude = 51.5, -0.1
+	prize.Expires = time.Now().Add(time.Hour).Unix()
+	id := normalizeAddress(prize.ID)
+
+	// drop ids are predictable, so someone else posts this one first
+	squatterKey, _ := crypto.GenerateKey()
+	squatter := crypto.PubkeyToAddress(squatterKey.PublicKey)
+	squatted := prize
+	squatted.Sender = squatter.Hex()
+	squatted.HashedPassword = crypto.Keccak256Hash(common.FromHex(prize.Password), squatter.Bytes()).Hex()
+	squatted.Latitude, squatted.Longitude = 40.7, -74
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(squatted, squatterKey), http.StatusCreated)
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, key), http.StatusForbidden)
+
+	// the DropAdded doesn't activate their row
+	must(t, ts.store.updatePrizeLockFields(prize.Type, normalizeAddress(prize.Sender), normalizeAddress(prize.HashedPassword), id, true, "", 10, 0))
+	if stored, _, _ := ts.store.getPrizeLockByID(id); stored.Active {
+		t.Fatalf("squatted prize after the DropAdded = %+v; want it inactive", stored)
+	}
+
+	// and once it's on chain the sender takes it back
+	ts.chain.drops[common.HexToHash(prize.ID)] = dropLock{
+		Sender:          common.HexToAddress(prize.Sender),
+		HashedPassword:  common.HexToHash(prize.HashedPassword),
+		PrizeType:       prize.Type,
+		ContractAddress: prize.contractAddress(),
+		Amount:          prize.Amount,
+		Expiry:          big.NewInt(prize.Expires),
+	}
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(squatted, squatterKey), http.StatusBadRequest)
+	ts.expect(http.MethodPost, "/prizes", "", ts.signedPrize(prize, key), http.StatusCreated)
+	stored, _, _ := ts.store.getPrize(id)
+	if !stored.Active || stored.Sender != normalizeAddress(prize.Sender) || stored.Latitude != 51.5 || stored.HashedPassword != normalizeAddress(prize.HashedPassword) {
+		t.Errorf("prize after the sender posts it on chain = %+v; want theirs and active", stored)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	ts := newTestServer(t)
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	var issued struct {
+		Nonce string `json:"nonce"`
+	}
+	json.NewDecoder(ts.expect(http.MethodGet, "/auth/nonce", "", "", http.StatusOK).Body).Decode(&issued)
+	text := strings.Replace(testSIWEMessage(address, "Sign in to dropwhere"), "3f9a1c7d2b8e4f60", issued.Nonce, 1)
+	login, _ := json.Marshal(LoginInput{Message: text, Signature: hexutil.Encode(signText(t, key, []byte(text)))})
+
+	w := ts.expect(http.MethodPost, "/auth/login", "", string(login), http.StatusOK)
+	var session Session
+	json.NewDecoder(w.Body).Decode(&session)
+	if session.Address != normalizeAddress(address.Hex()) || session.Token == "" {
+		t.Fatalf("POST /auth/login = %+v; want a token for %s", session, address.Hex())
+	}
+	if cookie := w.Result().Cookies(); len(cookie) != 1 || cookie[0].Value != session.Token || cookie[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("POST /auth/login cookies = %+v; want the token, SameSite=Lax", cookie)
+	}
+	ts.expect(http.MethodPost, "/auth/login", "", string(login), http.StatusUnauthorized)
+
+	w = ts.expect(http.MethodGet, "/auth/session", session.Token, "", http.StatusOK)
+	var current Session
+	json.NewDecoder(w.Body).Decode(&current)
+	if current.Address != session.Address || current.Token != "" {
+		t.Errorf("GET /auth/session = %+v; want %s without the token", current, session.Address)
+	}
+	ts.expect(http.MethodGet, "/auth/session", "", "", http.StatusUnauthorized)
+
+	w = ts.expect(http.MethodPost, "/auth/logout", session.Token, "", http.StatusNoContent)
+	if cookie := w.Result().Cookies(); len(cookie) != 1 || cookie[0].MaxAge >= 0 {
+		t.Errorf("POST /auth/logout cookies = %+v; want it cleared", cookie)
+	}
+}
+
+func TestServerStatusAndTemplates(t *testing.T) {
+	ts := newTestServer(t)
+
+	var status struct {
+		Indexer indexerStatus `json:"indexer"`
+	}
+	json.NewDecoder(ts.expect(http.MethodGet, "/status", "", "", http.StatusOK).Body).Decode(&status)
+	if status.Indexer.State != indexerConnecting {
+		t.Errorf("GET /status = %+v; want the indexer connecting", status)
+	}
+
+	ts.expect(http.MethodGet, "/templates", "", "", http.StatusOK)
+	ts.expect(http.MethodGet, "/templates?version=999", "", "", http.StatusNotFound)
+}
+
+func TestServerPrizeModeration(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.token(fixtureSigner)
+
+	prize, _ := testPrize()
+	prize.Latitude, prize.Longitude = 51.5, -0.1
+
+	// refused before the signature is checked; amounts are posted as decimal
+	// strings
+	ts.expect(http.MethodPost, "/prizes", "", `{"prize": {"id": "0x12"}}`, http.StatusBadRequest)
+	late, _ := json.Marshal(PrizeInput{Prize: prize, Deadline: 1})
+	w := ts.expect(http.MethodPost, "/prizes", "", strings.Replace(string(late), `"amount":1000`, `"amount":"1000"`, 1), http.StatusBadRequest)
+	if !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("POST /prizes past its deadline = %q; want expired", w.Body.String())
+	}
+
+	prize.normalizePrizeAddresses()
+	prize.Sender = normalizeAddress(prize.Sender)
+	prize.Expires = time.Now().Add(time.Hour).Unix()
+	prize.Active = true
+	ts.store.upsertPrizeLock(prize)
+
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 1 || deltas[0].ID != prize.ID {
+		t.Fatalf("POST /delta = %+v; want the prize", deltas)
+	}
+
+	ts.expect(http.MethodPost, "/reports", "", `{"targetType": "prize", "targetId": "`+prize.ID+`", "reason": "on a railway"}`, http.StatusAccepted)
+	ts.expect(http.MethodPost, "/reports", "", `{"targetType": "message", "targetId": "9", "reason": "spam"}`, http.StatusNotFound)
+
+	ts.expect(http.MethodGet, "/admin/reports", ts.token(otherSigner), "", http.StatusForbidden)
+	w = ts.expect(http.MethodGet, "/admin/reports", admin, "", http.StatusOK)
+	var reports []Report
+	json.NewDecoder(w.Body).Decode(&reports)
+	if len(reports) != 1 || reports[0].TargetID != prize.ID {
+		t.Fatalf("GET /admin/reports = %+v; want the prize's report", reports)
+	}
+
+	ts.expect(http.MethodPut, "/admin/moderation", admin, `{"targetType": "prize", "targetId": "`+prize.ID+`", "hidden": true}`, http.StatusNoContent)
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 0 {
+		t.Errorf("POST /delta after hiding = %+v; want nothing", deltas)
+	}
+	w = ts.expect(http.MethodGet, "/admin/reports?resolved=true", admin, "", http.StatusOK)
+	reports = nil
+	json.NewDecoder(w.Body).Decode(&reports)
+	if len(reports) != 1 || reports[0].ResolvedAt == 0 {
+		t.Errorf("GET /admin/reports?resolved=true = %+v; want the report resolved", reports)
+	}
+}
+
+func TestServerPrizeReads(t *testing.T) {
+	ts := newTestServer(t)
+	sender := normalizeAddress(fixtureSigner)
+	var ids []string
+	for id := byte(1); id <= 3; id++ {
+		p := conformancePrize(id, 51.5, -0.1)
+		ts.store.upsertPrizeLock(p)
+		ids = append(ids, p.ID)
+	}
+	claimer := normalizeAddress(otherSigner)
+	ts.store.updatePrizeLockFields("erc20", sender, "0xhash", ids[1], false, claimer, 10, 0)
+
+	view := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var v map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&v)
+		return v
+	}
+	public := view(ts.expect(http.MethodGet, "/prizes/"+ids[1], ts.token(otherSigner), "", http.StatusOK))
+	if public["claimedBy"] != claimer || public["password"] != nil || public["latitude"] != nil {
+		t.Errorf("GET /prizes/{id} as someone else = %v; want the claimer without password or coordinates", public)
+	}
+	private := view(ts.expect(http.MethodGet, "/prizes/"+ids[1], ts.token(fixtureSigner), "", http.StatusOK))
+	if private["password"] != "sealed" || private["latitude"] != 51.5 {
+		t.Errorf("GET /prizes/{id} as the sender = %v; want the password and coordinates", private)
+	}
+	ts.expect(http.MethodGet, "/prizes/0x"+strings.Repeat("9", 64), "", "", http.StatusNotFound)
+
+	page := func(path string) prizePage {
+		var p prizePage
+		json.NewDecoder(ts.expect(http.MethodGet, path, "", "", http.StatusOK).Body).Decode(&p)
+		return p
+	}
+	first := page("/prizes?sender=" + fixtureSigner + "&limit=2")
+	if len(first.Prizes) != 2 || first.Next != ids[1] || first.Prizes[0].Latitude != nil {
+		t.Fatalf("GET /prizes?sender= first page = %+v; want 2 without coordinates and a cursor", first)
+	}
+	if rest := page("/prizes?sender=" + fixtureSigner + "&limit=2&cursor=" + first.Next); len(rest.Prizes) != 1 || rest.Next != "" {
+		t.Errorf("GET /prizes?sender= second page = %+v; want the last one", rest)
+	}
+	if claimed := page("/prizes?claimedBy=" + otherSigner); len(claimed.Prizes) != 1 || claimed.Prizes[0].ID != ids[1] {
+		t.Errorf("GET /prizes?claimedBy= = %+v; want the claimed prize", claimed)
+	}
+
+	claim := DropLog{DropID: ids[1], Event: dropLogUnlocked, Sender: sender, Receiver: claimer, Block: 10, TxHash: "0xclaim", Timestamp: 1000}
+	ts.store.insertDropLog(claim)
+	var history []dropLogView
+	json.NewDecoder(ts.expect(http.MethodGet, "/prizes/"+ids[1]+"/events", "", "", http.StatusOK).Body).Decode(&history)
+	if len(history) != 1 || history[0].Kind != "claimed" || history[0].Receiver != claimer {
+		t.Errorf("GET /prizes/{id}/events = %+v; want the claim", history)
+	}
+
+	ts.expect(http.MethodGet, "/prizes", "", "", http.StatusBadRequest)
+	ts.expect(http.MethodGet, "/prizes?sender="+fixtureSigner+"&claimedBy="+otherSigner, "", "", http.StatusBadRequest)
+	ts.expect(http.MethodGet, "/prizes?sender=nope", "", "", http.StatusBadRequest)
+	ts.expect(http.MethodGet, "/prizes?sender="+fixtureSigner+"&limit=0", "", "", http.StatusBadRequest)
+
+	// like /delta, only the sender sees what's moderated away or excluded
+	admin := ts.token(fixtureSigner)
+	ts.expect(http.MethodPut, "/admin/moderation", admin, `{"targetType": "prize", "targetId": "`+ids[0]+`", "hidden": true}`, http.StatusNoContent)
+	ts.expect(http.MethodGet, "/prizes/"+ids[0], ts.token(otherSigner), "", http.StatusNotFound)
+	ts.expect(http.MethodGet, "/prizes/"+ids[0], admin, "", http.StatusOK)
+	if listed := page("/prizes?sender=" + fixtureSigner); len(listed.Prizes) != 2 || listed.Prizes[0].ID != ids[1] {
+		t.Errorf("GET /prizes?sender= with one hidden = %+v; want the other 2", listed)
+	}
+
+	zone := `{"type": "Polygon", "coordinates": [[[-0.11, 51.49], [-0.09, 51.49], [-0.09, 51.51], [-0.11, 51.51], [-0.11, 51.49]]]}`
+	ts.expect(http.MethodPost, "/admin/zones?name=park", admin, zone, http.StatusCreated)
+	ts.expect(http.MethodGet, "/prizes/"+ids[1], "", "", http.StatusNotFound)
+	if claimed := page("/prizes?claimedBy=" + otherSigner); len(claimed.Prizes) != 0 {
+		t.Errorf("GET /prizes?claimedBy= in an exclusion zone = %+v; want nothing", claimed)
+	}
+	var own prizePage
+	json.NewDecoder(ts.expect(http.MethodGet, "/prizes?sender="+fixtureSigner, admin, "", http.StatusOK).Body).Decode(&own)
+	if len(own.Prizes) != 3 {
+		t.Errorf("GET /prizes?sender= as the sender = %+v; want all 3", own)
+	}
+}
+
+func TestServerBansAndZones(t *testing.T) {
+	ts := newTestServer(t)
+	admin, other := ts.token(fixtureSigner), ts.token(otherSigner)
+
+	ts.expect(http.MethodPost, "/messages", other, storeMessageBody(otherSigner, 51.5, -0.1), http.StatusCreated)
+	ts.expect(http.MethodPut, "/admin/bans/"+otherSigner, admin, `{"reason": "spam"}`, http.StatusNoContent)
+	ts.expect(http.MethodPost, "/messages", other, storeMessageBody(otherSigner, 51.5, -0.1), http.StatusForbidden)
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 0 {
+		t.Errorf("POST /delta with the sender banned = %+v; want nothing", deltas)
+	}
+
+	w := ts.expect(http.MethodGet, "/admin/bans", admin, "", http.StatusOK)
+	var bans []Ban
+	json.NewDecoder(w.Body).Decode(&bans)
+	if len(bans) != 1 || bans[0].Address != normalizeAddress(otherSigner) || bans[0].Reason != "spam" {
+		t.Errorf("GET /admin/bans = %+v; want the ban", bans)
+	}
+	ts.expect(http.MethodDelete, "/admin/bans/"+otherSigner, admin, "", http.StatusNoContent)
+	ts.expect(http.MethodDelete, "/admin/bans/"+otherSigner, admin, "", http.StatusNotFound)
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 1 {
+		t.Errorf("POST /delta after unbanning = %+v; want the message back", deltas)
+	}
+
+	zone := `{"type": "Polygon", "coordinates": [[[-0.11, 51.49], [-0.09, 51.49], [-0.09, 51.51], [-0.11, 51.51], [-0.11, 51.49]]]}`
+	ts.expect(http.MethodPost, "/admin/zones?name=park", other, zone, http.StatusForbidden)
+	ts.expect(http.MethodPost, "/admin/zones?name=park", admin, zone, http.StatusCreated)
+	w = ts.expect(http.MethodPost, "/messages", other, storeMessageBody(otherSigner, 51.5, -0.1), http.StatusUnprocessableEntity)
+	if !strings.Contains(w.Body.String(), "park") {
+		t.Errorf("POST /messages in a zone = %q; want it named", w.Body.String())
+	}
+	if deltas := ts.deltas("", 51.5, -0.1); len(deltas) != 0 {
+		t.Errorf("POST /delta in a zone = %+v; want nothing", deltas)
+	}
+
+	w = ts.expect(http.MethodGet, "/admin/zones", admin, "", http.StatusOK)
+	var zones []exclusionZone
+	json.NewDecoder(w.Body).Decode(&zones)
+	if len(zones) != 1 || zones[0].Name != "park" {
+		t.Fatalf("GET /admin/zones = %+v; want the park", zones)
+	}
+	ts.expect(http.MethodDelete, "/admin/zones/1", admin, "", http.StatusNoContent)
+	ts.expect(http.MethodDelete, "/admin/zones/1", admin, "", http.StatusNotFound)
+	ts.expect(http.MethodPost, "/messages", other, storeMessageBody(otherSigner, 51.5, -0.1), http.StatusCreated)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const sessionCookie = "dropwhere_session"
+
+// how long a sign in nonce stays usable
+const loginNonceTTL = time.Minute * 10
+
+var (
+	sessionSecret []byte
+	sessionTTL    time.Duration
+	siweDomains   []string
+)
+
+// initSessions reads SESSION_SECRET, the key session tokens are signed
+// with, SESSION_TTL and SIWE_DOMAINS, the domains login messages may name.
+// The domains default to the hosts in ALLOWED_HOSTS.
+func initSessions() {
+	sessionSecret = []byte(os.Getenv("SESSION_SECRET"))
+	if len(sessionSecret) == 0 {
+		Sugar.Warn("SESSION_SECRET not set, sessions won't survive a restart")
+		sessionSecret = make([]byte, 32)
+		if _, err := rand.Read(sessionSecret); err != nil {
+			Sugar.Fatal(err)
+		}
+	} else if len(sessionSecret) < 32 {
+		Sugar.Fatal("SESSION_SECRET must be at least 32 bytes")
+	}
+
+	sessionTTL = envDuration("SESSION_TTL", time.Hour)
+
+	domains := os.Getenv("SIWE_DOMAINS")
+	if domains == "" {
+		domains = os.Getenv("ALLOWED_HOSTS")
+	}
+	siweDomains = siweDomainsFrom(domains)
+	Sugar.Infof("sign in accepted for %s", strings.Join(siweDomains, ", "))
+}
+
+// siweDomainsFrom turns a comma separated list of hosts or origins into the
+// authorities SIWE messages carry.
+func siweDomainsFrom(list string) []string {
+	var domains []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if u, err := url.Parse(entry); err == nil && u.Host != "" {
+			entry = u.Host
+		}
+		if entry != "" {
+			domains = append(domains, entry)
+		}
+	}
+	return domains
+}
+
+// Session tokens are "<address>.<expiry>.<mac>" with an HMAC-SHA256 over the
+// first two parts; nothing is stored server side, so they can't be revoked
+// and are kept short-lived instead.
+func issueSessionToken(secret []byte, address common.Address, expires time.Time) string {
+	payload := fmt.Sprintf("%s.%d", strings.ToLower(address.Hex()), expires.Unix())
+	return payload + "." + sessionMAC(secret, payload)
+}
+
+func parseSessionToken(secret []byte, token string, now time.Time) (common.Address, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return common.Address{}, errors.New("malformed session token")
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sessionMAC(secret, payload))) {
+		return common.Address{}, errors.New("bad session token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !common.IsHexAddress(parts[0]) {
+		return common.Address{}, errors.New("malformed session token")
+	}
+	if now.Unix() >= expires {
+		return common.Address{}, errors.New("session expired")
+	}
+	return common.HexToAddress(parts[0]), nil
+}
+
+func sessionMAC(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type callerKey struct{}
+
+// sessionMiddleware puts the caller's address in the request context when
+// it carries a valid session, from the cookie or an Authorization: Bearer
+// header. Anything else is served as an anonymous request.
+// The cookie only counts for a mutation sent as application/json: a cross
+// site form or text/plain POST goes out without a CORS preflight, so it
+// could otherwise act as whoever is signed in.
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		} else if cookie, err := r.Cookie(sessionCookie); err == nil && (safeMethod(r.Method) || isJSON(r)) {
+			token = cookie.Value
+		}
+
+		if token != "" {
+			if address, err := parseSessionToken(sessionSecret, token, time.Now()); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), callerKey{}, address))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// callerAddress is the signed in address making the request, if any.
+func callerAddress(ctx context.Context) (common.Address, bool) {
+	address, ok := ctx.Value(callerKey{}).(common.Address)
+	return address, ok
+}
+
+func (s *server) loginNonceHandler(w http.ResponseWriter, r *http.Request) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		http.Error(w, "Nonce error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+
+	issued := struct {
+		Nonce   string `json:"nonce"`
+		Expires int64  `json:"expires"`
+	}{
+		Nonce:   hex.EncodeToString(nonce),
+		Expires: time.Now().Add(loginNonceTTL).Unix(),
+	}
+	if err := s.store.insertLoginNonce(issued.Nonce, issued.Expires); err != nil {
+		http.Error(w, "Nonce error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issued)
+}
+
+type LoginInput struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+type Session struct {
+	Address string `json:"address"`
+	Token   string `json:"token,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	var input LoginInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		Sugar.Error(err)
+		return
+	}
+
+	now := time.Now()
+	msg, err := verifySIWE(r.Context(), s.chain, input.Message, common.FromHex(input.Signature), siweDomains, chainID, now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Sign in failed: %s", err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	used, err := s.store.useLoginNonce(msg.Nonce)
+	if err != nil {
+		http.Error(w, "Nonce error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !used {
+		http.Error(w, "Nonce already used or expired", http.StatusUnauthorized)
+		return
+	}
+
+	// the session never outlives the message's own expiry
+	expires := now.Add(sessionTTL)
+	if msg.ExpirationTime != nil && msg.ExpirationTime.Before(expires) {
+		expires = *msg.ExpirationTime
+	}
+	token := issueSessionToken(sessionSecret, msg.Address, expires)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Session{
+		Address: normalizeAddress(msg.Address.Hex()),
+		Token:   token,
+		Expires: expires.Unix(),
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	address, ok := callerAddress(r.Context())
+	if !ok {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Session{Address: normalizeAddress(address.Hex())})
+}
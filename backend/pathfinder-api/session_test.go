@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var testSessionSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSessionToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	address := common.HexToAddress(fixtureSigner)
+	token := issueSessionToken(testSessionSecret, address, now.Add(time.Hour))
+
+	got, err := parseSessionToken(testSessionSecret, token, now)
+	if err != nil || got != address {
+		t.Fatalf("parseSessionToken() = %s, %v; want %s", got.Hex(), err, address.Hex())
+	}
+
+	if _, err := parseSessionToken(testSessionSecret, token, now.Add(time.Hour)); err == nil {
+		t.Errorf("parseSessionToken() of an expired token = nil error")
+	}
+	if _, err := parseSessionToken([]byte("another secret, another server.."), token, now); err == nil {
+		t.Errorf("parseSessionToken() with another secret = nil error")
+	}
+
+	forged := "0x0000000000000000000000000000000000000001" + token[42:]
+	if _, err := parseSessionToken(testSessionSecret, forged, now); err == nil {
+		t.Errorf("parseSessionToken() of a token for another address = nil error")
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	sessionSecret = testSessionSecret
+	address := common.HexToAddress(fixtureSigner)
+	token := issueSessionToken(sessionSecret, address, time.Now().Add(time.Hour))
+
+	var caller common.Address
+	var signedIn bool
+	handler := sessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, signedIn = callerAddress(r.Context())
+	}))
+
+	requests := map[string]func(r *http.Request){
+		"bearer": func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) },
+		"cookie": func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token}) },
+	}
+	for name, authenticate := range requests {
+		r := httptest.NewRequest("GET", "/auth/session", nil)
+		authenticate(r)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if !signedIn || caller != address {
+			t.Errorf("%s: callerAddress() = %s, %v; want %s", name, caller.Hex(), signedIn, address.Hex())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/auth/session", nil)
+	r.Header.Set("Authorization", "Bearer "+token+"x")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if signedIn {
+		t.Errorf("callerAddress() with a bad token = %s; want anonymous", caller.Hex())
+	}
+
+	// a cookie only signs in a POST that a cross site form couldn't send
+	for contentType, want := range map[string]bool{"text/plain": false, "": false, "application/json; charset=utf-8": true} {
+		r := httptest.NewRequest("POST", "/messages", strings.NewReader("{}"))
+		r.Header.Set("Content-Type", contentType)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		signedIn = false
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if signedIn != want {
+			t.Errorf("cookie POST as %q signed in = %t; want %t", contentType, signedIn, want)
+		}
+	}
+}
+
+func TestSIWEDomainsFrom(t *testing.T) {
+	got := siweDomainsFrom("https://dropwhere.xyz, http://localhost:3000,app.dropwhere.xyz,")
+	want := []string{"dropwhere.xyz", "localhost:3000", "app.dropwhere.xyz"}
+	if len(got) != len(want) {
+		t.Fatalf("siweDomainsFrom() = %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("siweDomainsFrom() = %v; want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// signatureBackend is the part of the node client signature checks need.
+type signatureBackend interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+var (
+	// erc6492MagicSuffix ends every ERC-6492 wrapped signature
+	erc6492MagicSuffix = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+	// erc1271MagicValue is isValidSignature's selector, returned on success
+	erc1271MagicValue = common.FromHex("0x1626ba7e")
+
+	erc1271ABI = mustParseABI(`[{"type":"function","name":"isValidSignature","stateMutability":"view",
+		"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],
+		"outputs":[{"name":"magicValue","type":"bytes4"}]}]`)
+
+	erc6492Wrapper = abi.Arguments{
+		{Name: "factory", Type: mustNewType("address")},
+		{Name: "factoryCalldata", Type: mustNewType("bytes")},
+		{Name: "signature", Type: mustNewType("bytes")},
+	}
+)
+
+// erc6492ValidatorCode is run as a deployless eth_call (no `to`, so the node
+// executes it as a constructor) to check a signature from a smart wallet
+// that has not been deployed yet. It expects these words appended to it:
+//
+//	signer | factory | len(factoryCalldata) | factoryCalldata | len(call) | call
+//
+// where call is the encoded isValidSignature(hash, signature). If signer has
+// no code it first calls factory with factoryCalldata to deploy it, then
+// returns the 32 bytes isValidSignature returned, or zero if it reverted.
+// Being a constructor, nothing it deploys outlives the call.
+//
+//	PUSH2 0x46 DUP1 CODESIZE SUB SWAP1 PUSH1 0 CODECOPY          copy args to memory[0:]
+//	PUSH1 0 MLOAD EXTCODESIZE PUSH1 0x22 JUMPI                   skip deploy if signer has code
+//	CALL(gas, mload(0x20), 0, 0x60, mload(0x40), 0, 0) POP       deploy through the factory
+//	JUMPDEST o := 0x60 + mload(0x40)
+//	STATICCALL(gas, mload(0), o+0x20, mload(o), 0, 0x20)         isValidSignature -> memory[0:32]
+//	PUSH1 0x40 JUMPI MSTORE(0, 0)                                zero the result on revert
+//	JUMPDEST RETURN(0, 0x20)
+var erc6492ValidatorCode = common.FromHex("0x610046803803906000396000513b60225760006000604051606060006020515af1505b604051606001602060008251836020016000515afa60405760006000525b60206000f3")
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func mustNewType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
+
+// verifyMessageSignature checks an EIP-191 personal_sign signature over
+// message, the way viem's verifyMessage does.
+func verifyMessageSignature(ctx context.Context, backend signatureBackend, signer common.Address, message []byte, sig []byte) (bool, error) {
+	return verifyHashSignature(ctx, backend, signer, common.BytesToHash(accounts.TextHash(message)), sig)
+}
+
+// verifyTypedDataSignature checks an EIP-712 signature over typedData.
+func verifyTypedDataSignature(ctx context.Context, backend signatureBackend, signer common.Address, typedData apitypes.TypedData, sig []byte) (bool, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return false, err
+	}
+	return verifyHashSignature(ctx, backend, signer, common.BytesToHash(hash), sig)
+}
+
+// verifyHashSignature checks that signer signed hash. EOAs are checked with
+// ecrecover, deployed smart wallets (Coinbase Smart Wallet included) with an
+// EIP-1271 isValidSignature call, and ERC-6492 wrapped signatures from
+// wallets that are not deployed yet by simulating the deployment first.
+func verifyHashSignature(ctx context.Context, backend signatureBackend, signer common.Address, hash common.Hash, sig []byte) (bool, error) {
+	code, err := backend.CodeAt(ctx, signer, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if isERC6492Signature(sig) {
+		factory, factoryCalldata, inner, err := unwrapERC6492Signature(sig)
+		if err != nil {
+			return false, err
+		}
+		if len(code) > 0 {
+			return verifyERC1271(ctx, backend, signer, hash, inner)
+		}
+		return verifyERC6492(ctx, backend, signer, hash, factory, factoryCalldata, inner)
+	}
+
+	if len(code) > 0 {
+		return verifyERC1271(ctx, backend, signer, hash, sig)
+	}
+	return verifyECDSA(signer, hash, sig)
+}
+
+func verifyECDSA(signer common.Address, hash common.Hash, sig []byte) (bool, error) {
+	if len(sig) != crypto.SignatureLength {
+		return false, errors.New("signature must be 65 bytes")
+	}
+
+	// wallets sign with v as 27/28, crypto wants the 0/1 recovery id
+	normalized := make([]byte, len(sig))
+	copy(normalized, sig)
+	if normalized[crypto.RecoveryIDOffset] >= 27 {
+		normalized[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(hash.Bytes(), normalized)
+	if err != nil {
+		return false, err
+	}
+	return crypto.PubkeyToAddress(*pub) == signer, nil
+}
+
+func verifyERC1271(ctx context.Context, backend signatureBackend, signer common.Address, hash common.Hash, sig []byte) (bool, error) {
+	data, err := erc1271ABI.Pack("isValidSignature", hash, sig)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := backend.CallContract(ctx, ethereum.CallMsg{To: &signer, Data: data}, nil)
+	if err != nil {
+		return false, err
+	}
+	return isERC1271MagicValue(res), nil
+}
+
+func verifyERC6492(ctx context.Context, backend signatureBackend, signer common.Address, hash common.Hash, factory common.Address, factoryCalldata, sig []byte) (bool, error) {
+	call, err := erc1271ABI.Pack("isValidSignature", hash, sig)
+	if err != nil {
+		return false, err
+	}
+
+	data := append([]byte{}, erc6492ValidatorCode...)
+	data = append(data, common.LeftPadBytes(signer.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(factory.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(factoryCalldata))).Bytes(), 32)...)
+	data = append(data, factoryCalldata...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(call))).Bytes(), 32)...)
+	data = append(data, call...)
+
+	res, err := backend.CallContract(ctx, ethereum.CallMsg{Data: data}, nil)
+	if err != nil {
+		return false, err
+	}
+	return isERC1271MagicValue(res), nil
+}
+
+func isERC1271MagicValue(res []byte) bool {
+	return len(res) >= 4 && bytes.Equal(res[:4], erc1271MagicValue)
+}
+
+func isERC6492Signature(sig []byte) bool {
+	return len(sig) > len(erc6492MagicSuffix) && bytes.HasSuffix(sig, erc6492MagicSuffix)
+}
+
+func unwrapERC6492Signature(sig []byte) (common.Address, []byte, []byte, error) {
+	values, err := erc6492Wrapper.Unpack(sig[:len(sig)-len(erc6492MagicSuffix)])
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return values[0].(common.Address), values[1].([]byte), values[2].([]byte), nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// A signature made by the first hardhat/anvil dev account over the message
+// the UI signs for a note at 51.469,-0.040, v as 27/28 like wallets send it.
+const (
+	fixtureSigner    = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+	fixtureMessage   = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb9226651.469-0.040"
+	fixtureSignature = "0xa8fb8b0ee2066aba5856f3bf9a3623078e23df6ec10252bd8ec44571f037382c3e58443eaf144d7bf96e41562baf8ddc5eab4f73b289cbb2ae8634db953cd7741c"
+)
+
+// walletRuntime is a minimal EIP-1271 wallet: isValidSignature(hash, sig)
+// ecrecovers the 65 byte sig and returns the magic value if it was made by
+// the owner, zero otherwise.
+func walletRuntime(owner common.Address) []byte {
+	code := "" +
+		"60043560005260a43560f81c602052" + // hash, v
+		"606435604052" + "608435606052" + // r, s
+		"602060806080600060015afa50" + // ecrecover -> memory[0x80]
+		"608051" + "73" + common.Bytes2Hex(owner.Bytes()) + "14" + "604957" + // == owner?
+		"602060a0f3" + // return zero
+		"5b" + "631626ba7e60e01b600052" + "60206000f3" // return magic value
+	return common.FromHex(code)
+}
+
+// walletFactoryRuntime CREATE2s its calldata as init code with a zero salt.
+const walletFactoryRuntime = "366000600037" + "60003660006000f5" + "5000"
+
+func signText(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	sig, err := crypto.Sign(accounts.TextHash(message), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig
+}
+
+func (c *testChain) deploy(code []byte) common.Address {
+	address, _, _, err := bind.DeployContract(c.auth, abi.ABI{}, code, c.sim.Client())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.sim.Commit()
+	return address
+}
+
+func wrapERC6492(t *testing.T, factory common.Address, factoryCalldata, sig []byte) []byte {
+	wrapped, err := erc6492Wrapper.Pack(factory, factoryCalldata, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(wrapped, erc6492MagicSuffix...)
+}
+
+func TestVerifyMessageSignatureEOA(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	signer := common.HexToAddress(fixtureSigner)
+	sig := common.FromHex(fixtureSignature)
+
+	ok, err := verifyMessageSignature(ctx, chain.sim.Client(), signer, []byte(fixtureMessage), sig)
+	if err != nil || !ok {
+		t.Fatalf("verifyMessageSignature() = %v, %v; want true", ok, err)
+	}
+
+	ok, err = verifyMessageSignature(ctx, chain.sim.Client(), signer, []byte(fixtureMessage+"1"), sig)
+	if err != nil || ok {
+		t.Errorf("verifyMessageSignature() of a different message = %v, %v; want false", ok, err)
+	}
+
+	ok, err = verifyMessageSignature(ctx, chain.sim.Client(), common.Address{1}, []byte(fixtureMessage), sig)
+	if err != nil || ok {
+		t.Errorf("verifyMessageSignature() for a different signer = %v, %v; want false", ok, err)
+	}
+
+	if _, err := verifyMessageSignature(ctx, chain.sim.Client(), signer, []byte(fixtureMessage), sig[:64]); err == nil {
+		t.Errorf("verifyMessageSignature() accepted a 64 byte signature")
+	}
+}
+
+func TestVerifyMessageSignatureERC1271(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	owner, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	wallet := chain.deploy(initCodeFor(walletRuntime(crypto.PubkeyToAddress(owner.PublicKey))))
+	message := []byte(fmt.Sprintf("%s%.3f%.3f", wallet.Hex(), 51.469, -0.040))
+
+	ok, err := verifyMessageSignature(ctx, chain.sim.Client(), wallet, message, signText(t, owner, message))
+	if err != nil || !ok {
+		t.Fatalf("verifyMessageSignature() = %v, %v; want true", ok, err)
+	}
+
+	ok, err = verifyMessageSignature(ctx, chain.sim.Client(), wallet, message, signText(t, other, message))
+	if err != nil || ok {
+		t.Errorf("verifyMessageSignature() by a non-owner = %v, %v; want false", ok, err)
+	}
+}
+
+func TestVerifyMessageSignatureERC6492(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	owner, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+
+	factory := chain.deploy(initCodeFor(common.FromHex(walletFactoryRuntime)))
+	walletInit := initCodeFor(walletRuntime(crypto.PubkeyToAddress(owner.PublicKey)))
+	wallet := crypto.CreateAddress2(factory, [32]byte{}, crypto.Keccak256(walletInit))
+	message := []byte(fmt.Sprintf("%s%.3f%.3f", wallet.Hex(), 51.469, -0.040))
+
+	sig := wrapERC6492(t, factory, walletInit, signText(t, owner, message))
+	ok, err := verifyMessageSignature(ctx, chain.sim.Client(), wallet, message, sig)
+	if err != nil || !ok {
+		t.Fatalf("verifyMessageSignature() before deployment = %v, %v; want true", ok, err)
+	}
+
+	bad := wrapERC6492(t, factory, walletInit, signText(t, other, message))
+	ok, err = verifyMessageSignature(ctx, chain.sim.Client(), wallet, message, bad)
+	if err != nil || ok {
+		t.Errorf("verifyMessageSignature() by a non-owner = %v, %v; want false", ok, err)
+	}
+
+	code, err := chain.sim.Client().CodeAt(ctx, wallet, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 0 {
+		t.Fatalf("verification deployed the wallet")
+	}
+
+	// once the wallet is deployed the wrapped signature is checked directly
+	deployer := bind.NewBoundContract(factory, abi.ABI{}, chain.sim.Client(), chain.sim.Client(), chain.sim.Client())
+	if _, err := deployer.RawTransact(chain.auth, walletInit); err != nil {
+		t.Fatal(err)
+	}
+	chain.sim.Commit()
+	if code, _ := chain.sim.Client().CodeAt(ctx, wallet, nil); len(code) == 0 {
+		t.Fatalf("factory did not deploy the wallet")
+	}
+
+	ok, err = verifyMessageSignature(ctx, chain.sim.Client(), wallet, message, sig)
+	if err != nil || !ok {
+		t.Fatalf("verifyMessageSignature() after deployment = %v, %v; want true", ok, err)
+	}
+}
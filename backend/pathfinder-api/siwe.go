@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const siweHeaderSuffix = " wants you to sign in with your Ethereum account:"
+
+// how far a login message's issued at may be ahead of our clock
+const siweClockSkew = time.Minute
+
+var siweNoncePattern = regexp.MustCompile(`^[a-zA-Z0-9]{8,}$`)
+
+// siweMessage is an EIP-4361 Sign-In with Ethereum message.
+type siweMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        *big.Int
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// parseSIWEMessage parses the text a wallet signed, following the EIP-4361
+// ABNF closely enough to reject anything a compliant client wouldn't send.
+func parseSIWEMessage(text string) (*siweMessage, error) {
+	lines := strings.Split(text, "\n")
+	msg := &siweMessage{}
+	next := func() (string, bool) {
+		if len(lines) == 0 {
+			return "", false
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, true
+	}
+
+	header, _ := next()
+	domain, ok := strings.CutSuffix(header, siweHeaderSuffix)
+	if !ok || domain == "" {
+		return nil, errors.New("missing sign in header")
+	}
+	if scheme, rest, found := strings.Cut(domain, "://"); found {
+		if scheme == "" {
+			return nil, errors.New("invalid scheme")
+		}
+		domain = rest
+	}
+	msg.Domain = domain
+
+	address, _ := next()
+	if !common.IsHexAddress(address) || common.HexToAddress(address).Hex() != address {
+		return nil, errors.New("address must be EIP-55 checksummed")
+	}
+	msg.Address = common.HexToAddress(address)
+
+	if line, _ := next(); line != "" {
+		return nil, errors.New("expected a blank line after the address")
+	}
+	line, _ := next()
+	if line != "" {
+		msg.Statement = line
+		if line, _ := next(); line != "" {
+			return nil, errors.New("expected a blank line after the statement")
+		}
+	}
+
+	field := func(name string, required bool) (string, error) {
+		if len(lines) > 0 && strings.HasPrefix(lines[0], name+": ") {
+			line, _ := next()
+			return strings.TrimPrefix(line, name+": "), nil
+		}
+		if required {
+			return "", fmt.Errorf("missing %s", name)
+		}
+		return "", nil
+	}
+	timeField := func(name string, required bool) (*time.Time, error) {
+		v, err := field(name, required)
+		if err != nil || v == "" {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return &t, nil
+	}
+
+	var err error
+	if msg.URI, err = field("URI", true); err != nil {
+		return nil, err
+	}
+	if msg.Version, err = field("Version", true); err != nil {
+		return nil, err
+	}
+	if msg.Version != "1" {
+		return nil, fmt.Errorf("unsupported version %s", msg.Version)
+	}
+	chainID, err := field("Chain ID", true)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ChainID, ok = new(big.Int).SetString(chainID, 10); !ok {
+		return nil, fmt.Errorf("invalid chain id %s", chainID)
+	}
+	if msg.Nonce, err = field("Nonce", true); err != nil {
+		return nil, err
+	}
+	if !siweNoncePattern.MatchString(msg.Nonce) {
+		return nil, errors.New("invalid nonce")
+	}
+	issuedAt, err := timeField("Issued At", true)
+	if err != nil {
+		return nil, err
+	}
+	msg.IssuedAt = *issuedAt
+	if msg.ExpirationTime, err = timeField("Expiration Time", false); err != nil {
+		return nil, err
+	}
+	if msg.NotBefore, err = timeField("Not Before", false); err != nil {
+		return nil, err
+	}
+	if msg.RequestID, err = field("Request ID", false); err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 && lines[0] == "Resources:" {
+		next()
+		for len(lines) > 0 && strings.HasPrefix(lines[0], "- ") {
+			line, _ := next()
+			msg.Resources = append(msg.Resources, strings.TrimPrefix(line, "- "))
+		}
+	}
+
+	// a trailing newline is tolerated, anything else isn't
+	if len(lines) > 1 || (len(lines) == 1 && lines[0] != "") {
+		return nil, fmt.Errorf("unexpected line %q", lines[0])
+	}
+	return msg, nil
+}
+
+// validate checks the message was meant for us and is current. The nonce is
+// left to the caller, which has to mark it used.
+func (m *siweMessage) validate(domains []string, chainID *big.Int, now time.Time) error {
+	allowed := false
+	for _, domain := range domains {
+		if m.Domain == domain {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("domain %s isn't accepted", m.Domain)
+	}
+	if m.ChainID.Cmp(chainID) != 0 {
+		return fmt.Errorf("chain id %s isn't %s", m.ChainID, chainID)
+	}
+	if m.IssuedAt.After(now.Add(siweClockSkew)) {
+		return errors.New("issued in the future")
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return errors.New("message expired")
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return errors.New("message not valid yet")
+	}
+	return nil
+}
+
+// verifySIWE parses and checks a signed login message, returning it once
+// the signature is good. Smart wallets are supported like everywhere else.
+func verifySIWE(ctx context.Context, backend signatureBackend, text string, sig []byte, domains []string, chainID *big.Int, now time.Time) (*siweMessage, error) {
+	msg, err := parseSIWEMessage(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.validate(domains, chainID, now); err != nil {
+		return nil, err
+	}
+
+	ok, err := verifyMessageSignature(ctx, backend, msg.Address, []byte(text), sig)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("signature isn't from the message's address")
+	}
+	return msg, nil
+}
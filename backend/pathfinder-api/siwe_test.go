@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// laid out the way viem's createSiweMessage writes it
+func testSIWEMessage(address common.Address, statement string, extra ...string) string {
+	lines := []string{
+		"dropwhere.xyz wants you to sign in with your Ethereum account:",
+		address.Hex(),
+		"",
+	}
+	if statement != "" {
+		lines = append(lines, statement, "")
+	} else {
+		lines = append(lines, "")
+	}
+	lines = append(lines,
+		"URI: https://dropwhere.xyz",
+		"Version: 1",
+		"Chain ID: 1337",
+		"Nonce: 3f9a1c7d2b8e4f60",
+		"Issued At: 2024-06-01T12:00:00.000Z",
+	)
+	return strings.Join(append(lines, extra...), "\n")
+}
+
+var siweAddress = common.HexToAddress(fixtureSigner)
+
+func TestParseSIWEMessage(t *testing.T) {
+	msg, err := parseSIWEMessage(testSIWEMessage(siweAddress, "Sign in to dropwhere",
+		"Expiration Time: 2024-06-01T12:10:00Z",
+		"Request ID: abc",
+		"Resources:",
+		"- https://dropwhere.xyz/a",
+		"- ipfs://b",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Domain != "dropwhere.xyz" || msg.Address != siweAddress || msg.Statement != "Sign in to dropwhere" ||
+		msg.URI != "https://dropwhere.xyz" || msg.ChainID.Int64() != 1337 || msg.Nonce != "3f9a1c7d2b8e4f60" ||
+		msg.RequestID != "abc" || len(msg.Resources) != 2 || msg.ExpirationTime == nil || msg.NotBefore != nil {
+		t.Errorf("parseSIWEMessage() = %+v", msg)
+	}
+	if want := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC); !msg.IssuedAt.Equal(want) {
+		t.Errorf("parseSIWEMessage() issued at = %s; want %s", msg.IssuedAt, want)
+	}
+
+	if msg, err := parseSIWEMessage(testSIWEMessage(siweAddress, "") + "\n"); err != nil || msg.Statement != "" {
+		t.Errorf("parseSIWEMessage() without a statement = %+v, %v", msg, err)
+	}
+}
+
+func TestParseSIWEMessageRejects(t *testing.T) {
+	valid := testSIWEMessage(siweAddress, "Sign in")
+	invalid := map[string]string{
+		"header":           strings.Replace(valid, "wants you to sign in", "wants you to log in", 1),
+		"lowercase":        strings.Replace(valid, siweAddress.Hex(), strings.ToLower(siweAddress.Hex()), 1),
+		"version":          strings.Replace(valid, "Version: 1", "Version: 2", 1),
+		"nonce":            strings.Replace(valid, "Nonce: 3f9a1c7d2b8e4f60", "Nonce: short", 1),
+		"issued at":        strings.Replace(valid, "2024-06-01T12:00:00.000Z", "yesterday", 1),
+		"missing chain id": strings.Replace(valid, "Chain ID: 1337\n", "", 1),
+		"trailing":         valid + "\nP.S. hello",
+	}
+	for name, text := range invalid {
+		if _, err := parseSIWEMessage(text); err == nil {
+			t.Errorf("parseSIWEMessage() with bad %s = nil error", name)
+		}
+	}
+}
+
+func TestSIWEMessageValidate(t *testing.T) {
+	msg, err := parseSIWEMessage(testSIWEMessage(siweAddress, "", "Expiration Time: 2024-06-01T12:10:00Z", "Not Before: 2024-06-01T12:00:30Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	domains := []string{"localhost:3000", "dropwhere.xyz"}
+	at := time.Date(2024, 6, 1, 12, 5, 0, 0, time.UTC)
+
+	if err := msg.validate(domains, big.NewInt(1337), at); err != nil {
+		t.Errorf("validate() = %v; want nil", err)
+	}
+	checks := map[string]error{
+		"domain":     msg.validate([]string{"evil.xyz"}, big.NewInt(1337), at),
+		"chain":      msg.validate(domains, big.NewInt(1), at),
+		"expired":    msg.validate(domains, big.NewInt(1337), at.Add(time.Minute*5)),
+		"not before": msg.validate(domains, big.NewInt(1337), at.Add(-time.Minute*4-time.Second*45)),
+	}
+	for name, err := range checks {
+		if err == nil {
+			t.Errorf("validate() with bad %s = nil error", name)
+		}
+	}
+}
+
+func TestVerifySIWE(t *testing.T) {
+	chain := newTestChain(t)
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	text := testSIWEMessage(address, "Sign in to dropwhere")
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	domains := []string{"dropwhere.xyz"}
+
+	msg, err := verifySIWE(context.Background(), chain.sim.Client(), text, signText(t, key, []byte(text)), domains, big.NewInt(1337), at)
+	if err != nil || msg.Address != address {
+		t.Fatalf("verifySIWE() = %v, %v; want %s", msg, err, address.Hex())
+	}
+
+	other, _ := crypto.GenerateKey()
+	if _, err := verifySIWE(context.Background(), chain.sim.Client(), text, signText(t, other, []byte(text)), domains, big.NewInt(1337), at); err == nil {
+		t.Errorf("verifySIWE() signed by another key = nil error")
+	}
+
+	tampered := strings.Replace(text, "Sign in to dropwhere", "Sign in to dropwhere!", 1)
+	if _, err := verifySIWE(context.Background(), chain.sim.Client(), tampered, signText(t, key, []byte(text)), domains, big.NewInt(1337), at); err == nil {
+		t.Errorf("verifySIWE() of a changed message = nil error")
+	}
+}
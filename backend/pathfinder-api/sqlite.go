@@ -0,0 +1,623 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore is a Store in a single SQLite file, for running one node
+// without Postgres: local development, demos and small self-hosted
+// instances. Placeholders are ?NNN, which SQLite numbers like Postgres's $N.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// connectSQLite opens SQLITE_PATH, pathfinder.db by default. There's one
+// connection, SQLite only has one writer anyway and that way nothing waits
+// on a lock it holds itself.
+func connectSQLite() *sql.DB {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "pathfinder.db"
+	}
+	options := url.Values{
+		"_foreign_keys": {"on"},
+		"_journal_mode": {"WAL"},
+		"_busy_timeout": {"5000"},
+		"_txlock":       {"immediate"},
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+options.Encode())
+	if err != nil {
+		Sugar.Fatalf("DB ERROR: %s", err.Error())
+	}
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		Sugar.Fatalf("DB ERROR: %s", err.Error())
+	}
+	return db
+}
+
+func openSQLiteStore() *sqliteStore {
+	db := connectSQLite()
+	if err := sqliteSchema.prepare(db); err != nil {
+		Sugar.Fatalf("DB ERROR: %s", err.Error())
+	}
+	return &sqliteStore{db: db}
+}
+
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+// sqliteRadiusFilter narrows a radius search to candidate rows like
+// postgresStore's grid path, the cells going in as one JSON array.
+func sqliteRadiusFilter(lat, lon, radius float64, firstArg int) (string, []interface{}) {
+	area := searchAreaFor(lat, lon, radius)
+	if area.Cells == nil {
+		return fmt.Sprintf(`latitude BETWEEN ?%d AND ?%d`, firstArg, firstArg+1), []interface{}{area.MinLat, area.MaxLat}
+	}
+	cells, _ := json.Marshal(area.Cells)
+	return fmt.Sprintf(`latitude BETWEEN ?%d AND ?%d AND cell IN (SELECT value FROM json_each(?%d))`, firstArg, firstArg+1, firstArg+2),
+		[]interface{}{area.MinLat, area.MaxLat, string(cells)}
+}
+
+// sqliteModerated is moderated for SQLite, which has no ::text.
+func sqliteModerated(table, targetType string) string {
+	return fmt.Sprintf(`
+		NOT EXISTS (SELECT 1 FROM moderation WHERE target_type = '%[2]s' AND target_id = CAST(%[1]s.id AS TEXT) AND hidden)
+		AND NOT EXISTS (SELECT 1 FROM banned_senders WHERE address = %[1]s.sender)`, table, targetType)
+}
+
+// rowsAffectedOne is whether an Exec changed exactly one row.
+func rowsAffectedOne(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *sqliteStore) upsertPrizeLock(prize Prize) error {
+	query := `
+	INSERT INTO prizes (id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active, cell)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14)
+	ON CONFLICT (id)
+	DO UPDATE SET
+		sender = excluded.sender,
+		latitude = excluded.latitude,
+		longitude = excluded.longitude,
+		password = excluded.password,
+		hashed_password = excluded.hashed_password,
+		type = excluded.type,
+		contract_address = excluded.contract_address,
+		name = excluded.name,
+		symbol = excluded.symbol,
+		amount = excluded.amount,
+		expires = excluded.expires,
+		active = prizes.active OR excluded.active,
+		cell = excluded.cell
+	`
+	_, err := s.db.Exec(query, prize.ID, prize.Sender, prize.Latitude, prize.Longitude, prize.Password, prize.HashedPassword,
+		prize.Type, prize.ContractAddress, prize.Name, prize.Symbol, prize.Amount.String(), prize.Expires, prize.Active, cellFor(prize.Latitude, prize.Longitude))
+	return err
+}
+
+func (s *sqliteStore) getStoredPasswords() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT id, password FROM prizes WHERE password IS NOT NULL AND password <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passwords := map[string]string{}
+	for rows.Next() {
+		var id, password string
+		if err := rows.Scan(&id, &password); err != nil {
+			return nil, err
+		}
+		passwords[id] = password
+	}
+	return passwords, rows.Err()
+}
+
+func (s *sqliteStore) replaceStoredPassword(id, old, new string) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`UPDATE prizes SET password = ?3 WHERE id = ?1 AND password = ?2`, id, old, new))
+}
+
+func (s *sqliteStore) getPrizeLockByID(id string) (Prize, bool, error) {
+	var prize Prize
+	err := s.db.QueryRow(`SELECT id, sender, latitude, longitude, active FROM prizes WHERE id = ?1`, id).
+		Scan(&prize.ID, &prize.Sender, &prize.Latitude, &prize.Longitude, &prize.Active)
+	if err == sql.ErrNoRows {
+		return Prize{}, false, nil
+	}
+	if err != nil {
+		return Prize{}, false, err
+	}
+	return prize, true, nil
+}
+
+func (s *sqliteStore) getPrize(id string) (Prize, bool, error) {
+	prize, err := scanPrize(s.db.QueryRow(`SELECT `+prizeColumns+` FROM prizes WHERE id = ?1`, id))
+	if err == sql.ErrNoRows {
+		return Prize{}, false, nil
+	}
+	if err != nil {
+		return Prize{}, false, err
+	}
+	return prize, true, nil
+}
+
+func (s *sqliteStore) getPrizesBySender(sender, after string, limit int) ([]Prize, error) {
+	return scanPrizes(s.db.Query(`SELECT `+prizeColumns+` FROM prizes WHERE sender = ?1 AND id > ?2 ORDER BY id LIMIT ?3`, sender, after, limit))
+}
+
+func (s *sqliteStore) getPrizesClaimedBy(claimer, after string, limit int) ([]Prize, error) {
+	return scanPrizes(s.db.Query(`SELECT `+prizeColumns+` FROM prizes WHERE claimed_by = ?1 AND id > ?2 ORDER BY id LIMIT ?3`, claimer, after, limit))
+}
+
+func (s *sqliteStore) updatePrizeLockFields(pType, sender, hashedPassword, id string, active bool, claimedBy string, block uint64, index uint) error {
+	query := `
+	UPDATE prizes
+	SET type = ?1, sender = ?2, active = ?3, claimed_by = NULLIF(?7, ''), event_block = ?5, event_index = ?6
+	WHERE id = ?4 AND (event_block IS NULL OR (event_block, event_index) < (?5, ?6))
+		AND (NOT ?3 OR (sender = ?2 AND LOWER(hashed_password) = ?8))
+	`
+	_, err := s.db.Exec(query, pType, sender, active, id, int64(block), index, claimedBy, hashedPassword)
+	return err
+}
+
+func (s *sqliteStore) revertPrizeLockFields(id string, active bool, block uint64, index uint) error {
+	query := `
+	UPDATE prizes
+	SET active = ?2, claimed_by = NULL, event_block = NULL, event_index = NULL
+	WHERE id = ?1 AND event_block = ?3 AND event_index = ?4
+	`
+	_, err := s.db.Exec(query, id, active, int64(block), index)
+	return err
+}
+
+func (s *sqliteStore) getIndexedBlock(name string) (uint64, bool, error) {
+	var block int64
+	err := s.db.QueryRow(`SELECT block FROM indexer_state WHERE name = ?1`, name).Scan(&block)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(block), true, nil
+}
+
+func (s *sqliteStore) insertDropLog(log DropLog) error {
+	query := `
+	INSERT INTO drop_events (block_number, log_index, drop_id, event, tx_hash, sender, receiver, block_time)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6, NULLIF(?7, ''), ?8)
+	ON CONFLICT (block_number, log_index)
+	DO UPDATE SET
+		drop_id = excluded.drop_id,
+		event = excluded.event,
+		tx_hash = excluded.tx_hash,
+		sender = excluded.sender,
+		receiver = excluded.receiver,
+		block_time = excluded.block_time
+	`
+	_, err := s.db.Exec(query, int64(log.Block), log.LogIndex, log.DropID, log.Event, log.TxHash, log.Sender, log.Receiver, log.Timestamp)
+	return err
+}
+
+func (s *sqliteStore) deleteDropLog(block uint64, index uint, txHash string) error {
+	_, err := s.db.Exec(`DELETE FROM drop_events WHERE block_number = ?1 AND log_index = ?2 AND tx_hash = ?3`, int64(block), index, txHash)
+	return err
+}
+
+func (s *sqliteStore) getDropLogs(dropID string) ([]DropLog, error) {
+	return scanDropLogs(s.db.Query(`
+		SELECT drop_id, event, sender, receiver, block_number, tx_hash, log_index, block_time
+		FROM drop_events
+		WHERE drop_id = ?1
+		ORDER BY block_number, log_index`, dropID))
+}
+
+func (s *sqliteStore) getMovementTrack(key string) (movementTrack, bool, error) {
+	track, err := scanMovementTrack(s.db.QueryRow(`SELECT `+movementTrackColumns+` FROM movement_tracks WHERE key = ?1`, key))
+	if err == sql.ErrNoRows {
+		return movementTrack{}, false, nil
+	}
+	if err != nil {
+		return movementTrack{}, false, err
+	}
+	return track, true, nil
+}
+
+func (s *sqliteStore) saveMovementTrack(key string, track movementTrack, prev *time.Time) (bool, error) {
+	args := []interface{}{key, track.last.Latitude, track.last.Longitude, track.last.At.UnixNano(),
+		track.anchor.Latitude, track.anchor.Longitude, track.anchor.At.UnixNano()}
+	if prev == nil {
+		return rowsAffectedOne(s.db.Exec(`
+			INSERT INTO movement_tracks (key, `+movementTrackColumns+`)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+			ON CONFLICT (key) DO NOTHING`, args...))
+	}
+	return rowsAffectedOne(s.db.Exec(`
+		UPDATE movement_tracks
+		SET last_latitude = ?2, last_longitude = ?3, last_at = ?4, anchor_latitude = ?5, anchor_longitude = ?6, anchor_at = ?7
+		WHERE key = ?1 AND last_at = ?8`, append(args, prev.UnixNano())...))
+}
+
+func (s *sqliteStore) deleteMovementTracks(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM movement_tracks WHERE last_at < ?1`, before.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) setIndexedBlock(name string, block uint64) error {
+	query := `
+	INSERT INTO indexer_state (name, block)
+	VALUES (?1, ?2)
+	ON CONFLICT (name)
+	DO UPDATE SET block = MAX(indexer_state.block, excluded.block)
+	`
+	_, err := s.db.Exec(query, name, int64(block))
+	return err
+}
+
+func (s *sqliteStore) insertSignatureNonce(sender, nonce string, expires int64) error {
+	if _, err := s.db.Exec(`DELETE FROM signature_nonces WHERE expires < ?1`, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(`INSERT INTO signature_nonces (nonce, sender, expires) VALUES (?1, ?2, ?3)`, normalizeAddress(nonce), sender, expires)
+	return err
+}
+
+func (s *sqliteStore) useSignatureNonce(sender, nonce string) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`
+	UPDATE signature_nonces SET used = TRUE
+	WHERE nonce = ?1 AND sender = ?2 AND used = FALSE AND expires >= ?3
+	`, normalizeAddress(nonce), sender, time.Now().Unix()))
+}
+
+func (s *sqliteStore) insertLoginNonce(nonce string, expires int64) error {
+	if _, err := s.db.Exec(`DELETE FROM login_nonces WHERE expires < ?1`, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(`INSERT INTO login_nonces (nonce, expires) VALUES (?1, ?2)`, nonce, expires)
+	return err
+}
+
+func (s *sqliteStore) useLoginNonce(nonce string) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`UPDATE login_nonces SET used = TRUE WHERE nonce = ?1 AND used = FALSE AND expires >= ?2`, nonce, time.Now().Unix()))
+}
+
+func (s *sqliteStore) getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error) {
+	filter, args := sqliteRadiusFilter(lat, lon, radius, 2)
+	rows, err := s.db.Query(`
+		SELECT id, sender, latitude, longitude, password, hashed_password, type, contract_address, name, symbol, amount, expires, active
+		FROM prizes
+		WHERE active = TRUE AND expires > ?1 AND `+sqliteModerated("prizes", "prize")+` AND `+filter,
+		append([]interface{}{time.Now().Unix()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prizes []Prize
+	for rows.Next() {
+		var prize Prize
+		var amount string
+		if err := rows.Scan(&prize.ID, &prize.Sender, &prize.Latitude, &prize.Longitude, &prize.Password, &prize.HashedPassword,
+			&prize.Type, &prize.ContractAddress, &prize.Name, &prize.Symbol, &amount, &prize.Expires, &prize.Active); err != nil {
+			return nil, err
+		}
+		prize.Amount, _ = new(big.Int).SetString(amount, 10)
+
+		if distance, _ := haversine(lat, lon, prize.Latitude, prize.Longitude); distance <= radius {
+			prizes = append(prizes, prize)
+		}
+	}
+	return prizes, rows.Err()
+}
+
+func encodeMessageText(text []int16) string {
+	if text == nil {
+		text = []int16{}
+	}
+	data, _ := json.Marshal(text)
+	return string(data)
+}
+
+func (s *sqliteStore) insertMessage(msg Message) (int64, error) {
+	query := `
+	INSERT INTO messages (sender, text, latitude, longitude, expires, active, cell, template_version)
+	VALUES (?1, ?2, ?3, ?4, ?5, TRUE, ?6, ?7)
+	RETURNING id
+	`
+	var id int64
+	err := s.db.QueryRow(query, msg.Sender, encodeMessageText(msg.Text), msg.Latitude, msg.Longitude, msg.Expires,
+		cellFor(msg.Latitude, msg.Longitude), msg.TemplateVersion).Scan(&id)
+	return id, err
+}
+
+func (s *sqliteStore) editMessage(id int64, sender string, text []int16, version int) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`
+	UPDATE messages SET text = ?3, template_version = ?4
+	WHERE id = ?1 AND sender = ?2 AND active = TRUE AND expires > ?5 AND deleted_at IS NULL
+	`, id, sender, encodeMessageText(text), version, time.Now().Unix()))
+}
+
+func (s *sqliteStore) deleteMessage(id int64, sender string, at int64) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`
+	UPDATE messages SET active = FALSE, deleted_at = ?3
+	WHERE id = ?1 AND sender = ?2 AND deleted_at IS NULL
+	`, id, sender, at))
+}
+
+// sqliteMessageColumns are the columns scanMessages reads, appraisal counts
+// included.
+const sqliteMessageColumns = `
+	id, sender, text, latitude, longitude, expires, active, template_version,
+	(SELECT COUNT(*) FROM message_appraisals WHERE message_id = messages.id AND helpful) AS helpful,
+	(SELECT COUNT(*) FROM message_appraisals WHERE message_id = messages.id AND NOT helpful) AS unhelpful`
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		var text string
+		if err := rows.Scan(&msg.ID, &msg.Sender, &text, &msg.Latitude, &msg.Longitude, &msg.Expires, &msg.Active, &msg.TemplateVersion, &msg.Helpful, &msg.Unhelpful); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(text), &msg.Text); err != nil {
+			return nil, fmt.Errorf("message %d: %w", msg.ID, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqliteStore) getMessagesBySender(sender string) ([]Message, error) {
+	rows, err := s.db.Query(`
+		SELECT`+sqliteMessageColumns+`
+		FROM messages
+		WHERE sender = ?1 AND deleted_at IS NULL
+		ORDER BY id DESC`, sender)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (s *sqliteStore) getMessageSender(id int64) (string, error) {
+	var sender string
+	err := s.db.QueryRow(`
+		SELECT sender FROM messages
+		WHERE id = ?1 AND active = TRUE AND expires > ?2 AND deleted_at IS NULL`, id, time.Now().Unix()).Scan(&sender)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return sender, err
+}
+
+func (s *sqliteStore) appraiseMessage(id int64, appraiser string, helpful bool, at int64) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`
+	INSERT INTO message_appraisals (message_id, appraiser, helpful, appraised_at)
+	SELECT id, ?2, ?3, ?4 FROM messages
+	WHERE id = ?1 AND active = TRUE AND expires > ?4 AND deleted_at IS NULL
+	ON CONFLICT (message_id, appraiser) DO UPDATE SET helpful = excluded.helpful, appraised_at = excluded.appraised_at
+	`, id, appraiser, helpful, at))
+}
+
+func (s *sqliteStore) expireMessages(now int64) (int64, error) {
+	res, err := s.db.Exec(`UPDATE messages SET active = FALSE WHERE active = TRUE AND expires <= ?1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) purgeMessages(before int64) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM messages WHERE active = FALSE AND COALESCE(deleted_at, expires) < ?1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) getMessagesWithinRadius(lat, lon, radius float64, hideScore int64) ([]Message, error) {
+	filter, args := sqliteRadiusFilter(lat, lon, radius, 3)
+	rows, err := s.db.Query(`
+		SELECT * FROM (
+			SELECT`+sqliteMessageColumns+`
+			FROM messages
+			WHERE active = TRUE AND expires > ?1 AND `+sqliteModerated("messages", "message")+` AND `+filter+`
+		) WHERE helpful - unhelpful > ?2`,
+		append([]interface{}{time.Now().Unix(), hideScore}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	for _, msg := range candidates {
+		if distance, _ := haversine(lat, lon, msg.Latitude, msg.Longitude); distance <= radius {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *sqliteStore) insertReport(report ReportInput, reporter string, at int64) (bool, error) {
+	target := `SELECT 1 FROM messages WHERE CAST(id AS TEXT) = ?2 AND deleted_at IS NULL`
+	if report.TargetType == "prize" {
+		target = `SELECT 1 FROM prizes WHERE id = ?2`
+	}
+	return rowsAffectedOne(s.db.Exec(`
+	INSERT INTO reports (target_type, target_id, reporter, reason, created_at)
+	SELECT ?1, ?2, ?3, ?4, ?5 WHERE EXISTS (`+target+`)
+	ON CONFLICT (target_type, target_id, reporter) DO UPDATE
+	SET reason = excluded.reason, created_at = excluded.created_at, resolved_at = NULL
+	`, report.TargetType, report.TargetID, reporter, report.Reason, at))
+}
+
+func (s *sqliteStore) getReports(resolved bool) ([]Report, error) {
+	rows, err := s.db.Query(`
+		SELECT id, target_type, target_id, reporter, reason, created_at, COALESCE(resolved_at, 0)
+		FROM reports
+		WHERE (resolved_at IS NOT NULL) = ?1
+		ORDER BY created_at DESC
+		LIMIT 500`, resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		var report Report
+		if err := rows.Scan(&report.ID, &report.TargetType, &report.TargetID, &report.Reporter, &report.Reason, &report.CreatedAt, &report.ResolvedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// setModeration is two statements where Postgres has one, SQLite can't
+// update in a CTE, so they share a transaction.
+func (s *sqliteStore) setModeration(input ModerationInput, moderator string, at int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+	UPDATE reports SET resolved_at = ?3
+	WHERE target_type = ?1 AND target_id = ?2 AND resolved_at IS NULL
+	`, input.TargetType, input.TargetID, at); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+	INSERT INTO moderation (target_type, target_id, hidden, note, moderator, updated_at)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+	ON CONFLICT (target_type, target_id) DO UPDATE
+	SET hidden = excluded.hidden, note = excluded.note, moderator = excluded.moderator, updated_at = excluded.updated_at
+	`, input.TargetType, input.TargetID, input.Hidden, input.Note, moderator, at); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) getBans() ([]Ban, error) {
+	rows, err := s.db.Query(`SELECT address, COALESCE(reason, ''), banned_by, banned_at FROM banned_senders ORDER BY banned_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		if err := rows.Scan(&ban.Address, &ban.Reason, &ban.BannedBy, &ban.BannedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (s *sqliteStore) insertBan(ban Ban) error {
+	_, err := s.db.Exec(`
+	INSERT INTO banned_senders (address, reason, banned_by, banned_at)
+	VALUES (?1, ?2, ?3, ?4)
+	ON CONFLICT (address) DO UPDATE SET reason = excluded.reason, banned_by = excluded.banned_by, banned_at = excluded.banned_at
+	`, ban.Address, ban.Reason, ban.BannedBy, ban.BannedAt)
+	return err
+}
+
+func (s *sqliteStore) deleteBan(address string) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`DELETE FROM banned_senders WHERE address = ?1`, address))
+}
+
+func (s *sqliteStore) isBanned(address string) (bool, error) {
+	var banned bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM banned_senders WHERE address = ?1)`, address).Scan(&banned)
+	return banned, err
+}
+
+func (s *sqliteStore) getExclusionZones() ([]exclusionZone, error) {
+	rows, err := s.db.Query(`SELECT id, name, polygons, created_by, created_at FROM exclusion_zones ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var zones []exclusionZone
+	for rows.Next() {
+		var zone exclusionZone
+		var polygons string
+		if err := rows.Scan(&zone.ID, &zone.Name, &polygons, &zone.CreatedBy, &zone.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(polygons), &zone.Polygons); err != nil {
+			return nil, fmt.Errorf("zone %d: %w", zone.ID, err)
+		}
+		zones = append(zones, zone)
+	}
+	return zones, rows.Err()
+}
+
+func (s *sqliteStore) insertExclusionZones(zones []exclusionZone) ([]int64, error) {
+	var values []string
+	var args []interface{}
+	for _, zone := range zones {
+		polygons, err := json.Marshal(zone.Polygons)
+		if err != nil {
+			return nil, err
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("(?%d, ?%d, ?%d, ?%d)", n+1, n+2, n+3, n+4))
+		args = append(args, zone.Name, string(polygons), zone.CreatedBy, zone.CreatedAt)
+	}
+
+	rows, err := s.db.Query(`
+	INSERT INTO exclusion_zones (name, polygons, created_by, created_at)
+	VALUES `+strings.Join(values, ", ")+`
+	RETURNING id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteStore) deleteExclusionZone(id int64) (bool, error) {
+	return rowsAffectedOne(s.db.Exec(`DELETE FROM exclusion_zones WHERE id = ?1`, id))
+}
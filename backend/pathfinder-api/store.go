@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+)
+
+// Store is everything the API keeps. postgresStore is what it runs on,
+// memoryStore keeps it all in process for tests and for running without a
+// database. Both have to behave the same.
+type Store interface {
+	indexerStore
+	movementStore
+
+	upsertPrizeLock(prize Prize) error
+	getPrizeLockByID(id string) (Prize, bool, error)
+	getPrize(id string) (Prize, bool, error)
+	getPrizesBySender(sender, after string, limit int) ([]Prize, error)
+	getPrizesClaimedBy(claimer, after string, limit int) ([]Prize, error)
+	getDropLogs(dropID string) ([]DropLog, error)
+	getPrizeLocksWithinRadius(lat, lon, radius float64) ([]Prize, error)
+	getStoredPasswords() (map[string]string, error)
+	replaceStoredPassword(id, old, new string) (bool, error)
+
+	insertSignatureNonce(sender, nonce string, expires int64) error
+	useSignatureNonce(sender, nonce string) (bool, error)
+	insertLoginNonce(nonce string, expires int64) error
+	useLoginNonce(nonce string) (bool, error)
+
+	insertMessage(msg Message) (int64, error)
+	editMessage(id int64, sender string, text []int16, version int) (bool, error)
+	deleteMessage(id int64, sender string, at int64) (bool, error)
+	getMessagesBySender(sender string) ([]Message, error)
+	getMessageSender(id int64) (string, error)
+	appraiseMessage(id int64, appraiser string, helpful bool, at int64) (bool, error)
+	expireMessages(now int64) (int64, error)
+	purgeMessages(before int64) (int64, error)
+	getMessagesWithinRadius(lat, lon, radius float64, hideScore int64) ([]Message, error)
+
+	insertReport(report ReportInput, reporter string, at int64) (bool, error)
+	getReports(resolved bool) ([]Report, error)
+	setModeration(input ModerationInput, moderator string, at int64) error
+	getBans() ([]Ban, error)
+	insertBan(ban Ban) error
+	deleteBan(address string) (bool, error)
+	isBanned(address string) (bool, error)
+
+	getExclusionZones() ([]exclusionZone, error)
+	insertExclusionZones(zones []exclusionZone) ([]int64, error)
+	deleteExclusionZone(id int64) (bool, error)
+
+	close() error
+}
+
+// server is the API's handlers and the store and chain they share.
+type server struct {
+	store Store
+	chain chainReader
+}
+
+// openStore picks the store with STORE: postgres (the default), sqlite for
+// a single node, or memory, which forgets everything on restart.
+func openStore() Store {
+	switch kind := os.Getenv("STORE"); kind {
+	case "", "postgres":
+		return openPostgresStore()
+	case "sqlite":
+		return openSQLiteStore()
+	case "memory":
+		Sugar.Warn("STORE=memory, nothing will survive a restart")
+		return newMemoryStore()
+	default:
+		Sugar.Fatalf("unknown STORE %q", kind)
+	}
+	return nil
+}
+
+// migrate is the migrate subcommand, run against STORE's database.
+func migrate(args []string) {
+	var db *sql.DB
+	var dialect schemaDialect
+	switch kind := os.Getenv("STORE"); kind {
+	case "", "postgres":
+		db, dialect = connectPostgres(), postgresSchema
+	case "sqlite":
+		db, dialect = connectSQLite(), sqliteSchema
+	default:
+		Sugar.Fatalf("STORE=%s has no schema to migrate", kind)
+	}
+	defer db.Close()
+	migrateCommand(db, dialect, args)
+}
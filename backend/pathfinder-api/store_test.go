@@ -0,0 +1,584 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storeConformance is what every Store has to do the same. Each test gets
+// an empty store from open.
+var storeConformance = map[string]func(t *testing.T, s Store){
+	"prizes":      conformPrizes,
+	"prize reads": conformPrizeReads,
+	"indexer":     conformIndexer,
+	"drop logs":   conformDropLogs,
+	"movement":    conformMovement,
+	"nonces":      conformNonces,
+	"messages":    conformMessages,
+	"appraisals":  conformAppraisals,
+	"moderation":  conformModeration,
+	"zones":       conformZones,
+}
+
+func runStoreConformance(t *testing.T, open func(t *testing.T) Store) {
+	for name, test := range storeConformance {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.close()
+			test(t, s)
+		})
+	}
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) Store { return newMemoryStore() })
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) Store {
+		t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "conformance.db"))
+		return openSQLiteStore()
+	})
+}
+
+// TestPostgresStoreConformance runs against the DB_ database with
+// TEST_POSTGRES=true, emptying it first.
+func TestPostgresStoreConformance(t *testing.T) {
+	if os.Getenv("TEST_POSTGRES") != "true" {
+		t.Skip("TEST_POSTGRES not set")
+	}
+	runStoreConformance(t, func(t *testing.T) Store {
+		s := openPostgresStore()
+		if _, err := s.db.Exec(`TRUNCATE messages, prizes, indexer_state, signature_nonces, login_nonces, message_appraisals,
+			reports, moderation, banned_senders, exclusion_zones, drop_events, movement_tracks RESTART IDENTITY CASCADE`); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func conformancePrize(id byte, lat, lon float64) Prize {
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	return Prize{
+		ID:              "0x" + strings.Repeat(strconv.FormatInt(int64(id%16), 16), 64),
+		Sender:          normalizeAddress(fixtureSigner),
+		Latitude:        lat,
+		Longitude:       lon,
+		Password:        "sealed",
+		HashedPassword:  "0xhash",
+		Type:            "erc20",
+		ContractAddress: "0xdef4567890abcdef1234567890abcdef12345678",
+		Name:            "SampleToken",
+		Symbol:          "STK",
+		Amount:          amount,
+		Expires:         time.Now().Add(time.Hour).Unix(),
+		Active:          true,
+	}
+}
+
+func prizeIDs(prizes []Prize) []string {
+	ids := []string{}
+	for _, p := range prizes {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func conformPrizes(t *testing.T, s Store) {
+	near := conformancePrize(1, 51.5, -0.1)
+	far := conformancePrize(2, 52.5, -0.1)
+	expired := conformancePrize(3, 51.5, -0.1)
+	expired.Expires = time.Now().Add(-time.Hour).Unix()
+	inactive := conformancePrize(4, 51.5, -0.1)
+	inactive.Active = false
+	for _, p := range []Prize{near, far, expired, inactive} {
+		must(t, s.upsertPrizeLock(p))
+	}
+
+	got, err := s.getPrizeLocksWithinRadius(51.501, -0.1, 1)
+	must(t, err)
+	if len(got) != 1 || !reflect.DeepEqual(got[0], near) {
+		t.Fatalf("getPrizeLocksWithinRadius() = %+v; want only %+v", got, near)
+	}
+
+	// reposting can't deactivate what the indexer activated
+	near.Active = false
+	near.Name = "Renamed"
+	must(t, s.upsertPrizeLock(near))
+	found, ok, err := s.getPrizeLockByID(near.ID)
+	must(t, err)
+	want := Prize{ID: near.ID, Sender: near.Sender, Latitude: near.Latitude, Longitude: near.Longitude, Active: true}
+	if !ok || !reflect.DeepEqual(found, want) {
+		t.Errorf("getPrizeLockByID() = %+v, %t; want %+v", found, ok, want)
+	}
+	if _, ok, _ := s.getPrizeLockByID("0xmissing"); ok {
+		t.Errorf("getPrizeLockByID() of a missing prize found one")
+	}
+
+	passwords, err := s.getStoredPasswords()
+	must(t, err)
+	if len(passwords) != 4 || passwords[near.ID] != "sealed" {
+		t.Errorf("getStoredPasswords() = %v; want all 4", passwords)
+	}
+	if ok, _ := s.replaceStoredPassword(near.ID, "stale", "resealed"); ok {
+		t.Errorf("replaceStoredPassword() with a stale password = true")
+	}
+	if ok, err := s.replaceStoredPassword(near.ID, "sealed", "resealed"); err != nil || !ok {
+		t.Errorf("replaceStoredPassword() = %t, %v; want true", ok, err)
+	}
+}
+
+func conformPrizeReads(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	var sent []Prize
+	for id := byte(1); id <= 5; id++ {
+		p := conformancePrize(id, 51.5, -0.1)
+		must(t, s.upsertPrizeLock(p))
+		sent = append(sent, p)
+	}
+	other := conformancePrize(6, 51.5, -0.1)
+	other.Sender = "0xother"
+	must(t, s.upsertPrizeLock(other))
+
+	found, ok, err := s.getPrize(sent[0].ID)
+	must(t, err)
+	if !ok || !reflect.DeepEqual(found, sent[0]) {
+		t.Errorf("getPrize() = %+v, %t; want %+v", found, ok, sent[0])
+	}
+	if _, ok, _ := s.getPrize("0xmissing"); ok {
+		t.Errorf("getPrize() of a missing prize found one")
+	}
+
+	first, err := s.getPrizesBySender(sender, "", 3)
+	must(t, err)
+	if got, want := prizeIDs(first), prizeIDs(sent[:3]); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesBySender() first page = %v; want %v", got, want)
+	}
+	rest, err := s.getPrizesBySender(sender, first[len(first)-1].ID, 3)
+	must(t, err)
+	if got, want := prizeIDs(rest), prizeIDs(sent[3:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesBySender() second page = %v; want %v", got, want)
+	}
+
+	must(t, s.updatePrizeLockFields("erc20", sender, "0xhash", sent[3].ID, false, "0xclaimer", 10, 0))
+	must(t, s.updatePrizeLockFields("erc20", sender, "0xhash", sent[1].ID, false, "0xclaimer", 11, 0))
+	must(t, s.updatePrizeLockFields("erc20", "0xother", "0xhash", other.ID, false, "0xsomeoneelse", 12, 0))
+	claimed, err := s.getPrizesClaimedBy("0xclaimer", "", 10)
+	must(t, err)
+	if got, want := prizeIDs(claimed), []string{sent[1].ID, sent[3].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesClaimedBy() = %v; want %v", got, want)
+	}
+	claimed, err = s.getPrizesClaimedBy("0xclaimer", sent[1].ID, 10)
+	must(t, err)
+	if got, want := prizeIDs(claimed), []string{sent[3].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesClaimedBy() after a cursor = %v; want %v", got, want)
+	}
+
+	// reads say what moderation hides, for the handlers to leave out
+	must(t, s.setModeration(ModerationInput{TargetType: "prize", TargetID: sent[1].ID, Hidden: true}, "0xadmin", 1))
+	must(t, s.insertBan(Ban{Address: "0xother", Reason: "spam", BannedBy: "0xadmin", BannedAt: 2}))
+	hidden := func(prizes []Prize) []string {
+		ids := []string{}
+		for _, p := range prizes {
+			if p.Hidden {
+				ids = append(ids, p.ID)
+			}
+		}
+		return ids
+	}
+	if found, _, err := s.getPrize(sent[1].ID); err != nil || !found.Hidden {
+		t.Errorf("getPrize() of a hidden prize = %+v, %v; want it marked hidden", found, err)
+	}
+	if found, _, err := s.getPrize(other.ID); err != nil || !found.Hidden {
+		t.Errorf("getPrize() from a banned sender = %+v, %v; want it marked hidden", found, err)
+	}
+	all, err := s.getPrizesBySender(sender, "", 10)
+	must(t, err)
+	if got, want := hidden(all), []string{sent[1].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesBySender() hidden = %v; want %v", got, want)
+	}
+	claimed, err = s.getPrizesClaimedBy("0xsomeoneelse", "", 10)
+	must(t, err)
+	if got, want := hidden(claimed), []string{other.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getPrizesClaimedBy() hidden = %v; want %v", got, want)
+	}
+}
+
+func conformIndexer(t *testing.T, s Store) {
+	p := conformancePrize(1, 51.5, -0.1)
+	p.Active = false
+	must(t, s.upsertPrizeLock(p))
+
+	active := func() bool {
+		found, _, err := s.getPrizeLockByID(p.ID)
+		must(t, err)
+		return found.Active
+	}
+
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", p.ID, true, "", 10, 2))
+	// an older log is ignored
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", p.ID, false, "0xclaimer", 10, 1))
+	if !active() {
+		t.Errorf("an older log overwrote a newer one")
+	}
+	// reverting a log that isn't the latest does nothing
+	must(t, s.revertPrizeLockFields(p.ID, false, 9, 0))
+	if !active() {
+		t.Errorf("reverting a log that wasn't applied changed the prize")
+	}
+	must(t, s.revertPrizeLockFields(p.ID, false, 10, 2))
+	if active() {
+		t.Errorf("reverting the latest log left the prize active")
+	}
+	// once reverted any log applies
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", p.ID, true, "", 1, 0))
+	if !active() {
+		t.Errorf("a log after a revert wasn't applied")
+	}
+
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", p.ID, false, "0xclaimer", 11, 0))
+	if found, _, err := s.getPrize(p.ID); err != nil || found.Active || found.ClaimedBy != "0xclaimer" {
+		t.Errorf("getPrize() after an unlock = %+v, %v; want claimed by 0xclaimer", found, err)
+	}
+	// reposting keeps the claimer
+	must(t, s.upsertPrizeLock(p))
+	must(t, s.revertPrizeLockFields(p.ID, true, 11, 0))
+	if found, _, err := s.getPrize(p.ID); err != nil || !found.Active || found.ClaimedBy != "" {
+		t.Errorf("getPrize() after reverting the unlock = %+v, %v; want active and unclaimed", found, err)
+	}
+
+	// a row posted ahead of the drop by someone else doesn't go live when
+	// the real sender's DropAdded comes in
+	squatted := conformancePrize(2, 51.5, -0.1)
+	squatted.Sender, squatted.Active = "0xsquatter", false
+	must(t, s.upsertPrizeLock(squatted))
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", squatted.ID, true, "", 12, 0))
+	if found, _, err := s.getPrizeLockByID(squatted.ID); err != nil || found.Active || found.Sender != "0xsquatter" {
+		t.Errorf("getPrizeLockByID() of a squatted drop after its DropAdded = %+v, %v; want it left inactive", found, err)
+	}
+	squatted.Sender, squatted.HashedPassword = p.Sender, "0xguess"
+	must(t, s.upsertPrizeLock(squatted))
+	must(t, s.updatePrizeLockFields("erc20", p.Sender, "0xhash", squatted.ID, true, "", 12, 0))
+	if found, _, err := s.getPrizeLockByID(squatted.ID); err != nil || found.Active {
+		t.Errorf("getPrizeLockByID() of a drop with another hashed password after its DropAdded = %+v, %v; want it inactive", found, err)
+	}
+
+	if _, ok, err := s.getIndexedBlock("drops"); err != nil || ok {
+		t.Errorf("getIndexedBlock() before any = %t, %v; want nothing", ok, err)
+	}
+	must(t, s.setIndexedBlock("drops", 20))
+	must(t, s.setIndexedBlock("drops", 15))
+	if block, ok, err := s.getIndexedBlock("drops"); err != nil || !ok || block != 20 {
+		t.Errorf("getIndexedBlock() = %d, %t, %v; want 20, it never moves back", block, ok, err)
+	}
+}
+
+func conformDropLogs(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	added := DropLog{DropID: "0xdrop", Event: dropLogAdded, Sender: sender, Block: 10, TxHash: "0xadd", LogIndex: 3, Timestamp: 1000}
+	reclaimed := DropLog{DropID: "0xdrop", Event: dropLogUnlocked, Sender: sender, Receiver: sender, Block: 12, TxHash: "0xreclaim", LogIndex: 0, Timestamp: 1024}
+	other := DropLog{DropID: "0xother", Event: dropLogAdded, Sender: sender, Block: 11, TxHash: "0xother", LogIndex: 0, Timestamp: 1012}
+	for _, log := range []DropLog{reclaimed, added, other, added} {
+		must(t, s.insertDropLog(log))
+	}
+
+	logs, err := s.getDropLogs("0xdrop")
+	must(t, err)
+	if want := []DropLog{added, reclaimed}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("getDropLogs() = %+v; want %+v", logs, want)
+	}
+	if logs[1].kind() != "reclaimed" {
+		t.Errorf("unlock to the sender is %s; want reclaimed", logs[1].kind())
+	}
+
+	// only the log that was there is deleted
+	must(t, s.deleteDropLog(12, 0, "0xsomethingelse"))
+	must(t, s.deleteDropLog(12, 0, "0xreclaim"))
+	logs, err = s.getDropLogs("0xdrop")
+	must(t, err)
+	if want := []DropLog{added}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("getDropLogs() after a delete = %+v; want %+v", logs, want)
+	}
+}
+
+func conformMovement(t *testing.T, s Store) {
+	at := time.Unix(1700000000, 123456789)
+	first := movementTrack{
+		last:   locationReport{Latitude: 51.5, Longitude: -0.1, At: at},
+		anchor: locationReport{Latitude: 51.5, Longitude: -0.1, At: at},
+	}
+	if ok, err := s.saveMovementTrack("a", first, nil); err != nil || !ok {
+		t.Fatalf("saveMovementTrack() of a new track = %t, %v; want true", ok, err)
+	}
+	if ok, _ := s.saveMovementTrack("a", first, nil); ok {
+		t.Errorf("saveMovementTrack() of a new track twice = true")
+	}
+
+	next := first
+	next.last = locationReport{Latitude: 51.501, Longitude: -0.1, At: at.Add(time.Second)}
+	if ok, _ := s.saveMovementTrack("a", next, &next.last.At); ok {
+		t.Errorf("saveMovementTrack() over a stale report = true")
+	}
+	if ok, err := s.saveMovementTrack("a", next, &at); err != nil || !ok {
+		t.Errorf("saveMovementTrack() = %t, %v; want true", ok, err)
+	}
+	track, ok, err := s.getMovementTrack("a")
+	must(t, err)
+	if !ok || track.last.Latitude != 51.501 || !track.last.At.Equal(next.last.At) || !track.anchor.At.Equal(at) {
+		t.Errorf("getMovementTrack() = %+v, %t; want %+v", track, ok, next)
+	}
+
+	must(t, s.deleteMovementTracks(at.Add(time.Minute)))
+	if _, ok, _ := s.getMovementTrack("a"); ok {
+		t.Errorf("deleteMovementTracks() kept an expired track")
+	}
+}
+
+func conformNonces(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	nonce := "0x" + strings.Repeat("Ab", 32)
+	future := time.Now().Add(time.Minute).Unix()
+
+	must(t, s.insertSignatureNonce(sender, nonce, future))
+	if ok, _ := s.useSignatureNonce("0xsomeoneelse", nonce); ok {
+		t.Errorf("useSignatureNonce() by another sender = true")
+	}
+	if ok, err := s.useSignatureNonce(sender, strings.ToLower(nonce)); err != nil || !ok {
+		t.Errorf("useSignatureNonce() = %t, %v; want true whatever the case", ok, err)
+	}
+	if ok, _ := s.useSignatureNonce(sender, nonce); ok {
+		t.Errorf("useSignatureNonce() twice = true")
+	}
+	expired := "0x" + strings.Repeat("cd", 32)
+	must(t, s.insertSignatureNonce(sender, expired, time.Now().Add(-time.Minute).Unix()))
+	if ok, _ := s.useSignatureNonce(sender, expired); ok {
+		t.Errorf("useSignatureNonce() of an expired nonce = true")
+	}
+
+	must(t, s.insertLoginNonce("login", future))
+	if ok, err := s.useLoginNonce("login"); err != nil || !ok {
+		t.Errorf("useLoginNonce() = %t, %v; want true", ok, err)
+	}
+	if ok, _ := s.useLoginNonce("login"); ok {
+		t.Errorf("useLoginNonce() twice = true")
+	}
+	if ok, _ := s.useLoginNonce("never issued"); ok {
+		t.Errorf("useLoginNonce() of an unknown nonce = true")
+	}
+}
+
+func conformanceMessage(sender string, lat, lon float64) Message {
+	return Message{
+		Sender:          sender,
+		Text:            []int16{3, -1, 250},
+		Latitude:        lat,
+		Longitude:       lon,
+		Expires:         time.Now().Add(time.Hour).Unix(),
+		TemplateVersion: 1,
+	}
+}
+
+func conformMessages(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	first, err := s.insertMessage(conformanceMessage(sender, 51.5, -0.1))
+	must(t, err)
+	second, err := s.insertMessage(conformanceMessage(sender, 52.5, -0.1))
+	must(t, err)
+	if first == second {
+		t.Fatalf("insertMessage() gave both messages id %d", first)
+	}
+
+	near, err := s.getMessagesWithinRadius(51.501, -0.1, 1, -5)
+	must(t, err)
+	want := conformanceMessage(sender, 51.5, -0.1)
+	want.ID, want.Active = first, true
+	if len(near) != 1 || !reflect.DeepEqual(near[0], want) {
+		t.Fatalf("getMessagesWithinRadius() = %+v; want %+v", near, want)
+	}
+
+	if ok, _ := s.editMessage(first, "0xsomeoneelse", []int16{1}, 2); ok {
+		t.Errorf("editMessage() by another sender = true")
+	}
+	if ok, err := s.editMessage(first, sender, []int16{7, 8}, 2); err != nil || !ok {
+		t.Errorf("editMessage() = %t, %v; want true", ok, err)
+	}
+
+	mine, err := s.getMessagesBySender(sender)
+	must(t, err)
+	if len(mine) != 2 || mine[0].ID != second || mine[1].ID != first {
+		t.Fatalf("getMessagesBySender() = %+v; want newest first", mine)
+	}
+	if !reflect.DeepEqual(mine[1].Text, []int16{7, 8}) || mine[1].TemplateVersion != 2 {
+		t.Errorf("getMessagesBySender() after an edit = %+v", mine[1])
+	}
+
+	if got, err := s.getMessageSender(first); err != nil || got != sender {
+		t.Errorf("getMessageSender() = %q, %v; want %s", got, err, sender)
+	}
+
+	at := time.Now().Unix()
+	if ok, err := s.deleteMessage(first, sender, at); err != nil || !ok {
+		t.Errorf("deleteMessage() = %t, %v; want true", ok, err)
+	}
+	if ok, _ := s.deleteMessage(first, sender, at); ok {
+		t.Errorf("deleteMessage() twice = true")
+	}
+	if got, _ := s.getMessageSender(first); got != "" {
+		t.Errorf("getMessageSender() of a deleted message = %q", got)
+	}
+	if near, _ := s.getMessagesWithinRadius(51.501, -0.1, 1, -5); len(near) != 0 {
+		t.Errorf("getMessagesWithinRadius() found a deleted message")
+	}
+	if mine, _ := s.getMessagesBySender(sender); len(mine) != 1 {
+		t.Errorf("getMessagesBySender() = %+v; want the deleted one left out", mine)
+	}
+
+	// the second expires, then both are purged
+	if n, err := s.expireMessages(time.Now().Add(2 * time.Hour).Unix()); err != nil || n != 1 {
+		t.Errorf("expireMessages() = %d, %v; want 1", n, err)
+	}
+	if n, err := s.purgeMessages(time.Now().Add(3 * time.Hour).Unix()); err != nil || n != 2 {
+		t.Errorf("purgeMessages() = %d, %v; want 2", n, err)
+	}
+}
+
+func conformAppraisals(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	id, err := s.insertMessage(conformanceMessage(sender, 51.5, -0.1))
+	must(t, err)
+	at := time.Now().Unix()
+
+	for i, helpful := range []bool{true, false, false} {
+		if ok, err := s.appraiseMessage(id, "0xappraiser"+strconv.Itoa(i), helpful, at); err != nil || !ok {
+			t.Fatalf("appraiseMessage() = %t, %v; want true", ok, err)
+		}
+	}
+	// a second appraisal replaces the first
+	must(t, func() error { _, err := s.appraiseMessage(id, "0xappraiser2", true, at); return err }())
+	if ok, _ := s.appraiseMessage(id+100, "0xappraiser0", true, at); ok {
+		t.Errorf("appraiseMessage() of a missing message = true")
+	}
+
+	near, err := s.getMessagesWithinRadius(51.5, -0.1, 1, -5)
+	must(t, err)
+	if len(near) != 1 || near[0].Helpful != 2 || near[0].Unhelpful != 1 {
+		t.Fatalf("getMessagesWithinRadius() = %+v; want 2 helpful, 1 unhelpful", near)
+	}
+	// a score of 1 is hidden at 1, not at 0
+	if near, _ := s.getMessagesWithinRadius(51.5, -0.1, 1, 1); len(near) != 0 {
+		t.Errorf("getMessagesWithinRadius() with hideScore 1 = %+v; want it hidden", near)
+	}
+	if near, _ := s.getMessagesWithinRadius(51.5, -0.1, 1, 0); len(near) != 1 {
+		t.Errorf("getMessagesWithinRadius() with hideScore 0 = %+v; want it shown", near)
+	}
+}
+
+func conformModeration(t *testing.T, s Store) {
+	sender := normalizeAddress(fixtureSigner)
+	prize := conformancePrize(1, 51.5, -0.1)
+	must(t, s.upsertPrizeLock(prize))
+	id, err := s.insertMessage(conformanceMessage(sender, 51.5, -0.1))
+	must(t, err)
+	messageID := strconv.FormatInt(id, 10)
+
+	if ok, _ := s.insertReport(ReportInput{TargetType: "message", TargetID: "999", Reason: "spam"}, "ip:1", 1); ok {
+		t.Errorf("insertReport() of a missing message = true")
+	}
+	if ok, err := s.insertReport(ReportInput{TargetType: "message", TargetID: messageID, Reason: "spam"}, "ip:1", 1); err != nil || !ok {
+		t.Fatalf("insertReport() = %t, %v; want true", ok, err)
+	}
+	if ok, err := s.insertReport(ReportInput{TargetType: "prize", TargetID: prize.ID, Reason: "railway"}, "ip:1", 2); err != nil || !ok {
+		t.Fatalf("insertReport() = %t, %v; want true", ok, err)
+	}
+	// reporting again updates the report
+	must(t, func() error {
+		_, err := s.insertReport(ReportInput{TargetType: "message", TargetID: messageID, Reason: "slur"}, "ip:1", 3)
+		return err
+	}())
+
+	open, err := s.getReports(false)
+	must(t, err)
+	if len(open) != 2 || open[0].TargetID != messageID || open[0].Reason != "slur" || open[1].TargetID != prize.ID {
+		t.Fatalf("getReports() = %+v; want the updated message report then the prize's", open)
+	}
+
+	must(t, s.setModeration(ModerationInput{TargetType: "message", TargetID: messageID, Hidden: true}, "0xadmin", 4))
+	if near, _ := s.getMessagesWithinRadius(51.5, -0.1, 1, -5); len(near) != 0 {
+		t.Errorf("getMessagesWithinRadius() found a hidden message")
+	}
+	resolved, err := s.getReports(true)
+	must(t, err)
+	if len(resolved) != 1 || resolved[0].TargetID != messageID || resolved[0].ResolvedAt != 4 {
+		t.Errorf("getReports(true) = %+v; want the message's report resolved at 4", resolved)
+	}
+	must(t, s.setModeration(ModerationInput{TargetType: "message", TargetID: messageID, Hidden: false}, "0xadmin", 5))
+	if near, _ := s.getMessagesWithinRadius(51.5, -0.1, 1, -5); len(near) != 1 {
+		t.Errorf("getMessagesWithinRadius() didn't find a restored message")
+	}
+
+	must(t, s.insertBan(Ban{Address: sender, Reason: "spam", BannedBy: "0xadmin", BannedAt: 6}))
+	if banned, err := s.isBanned(sender); err != nil || !banned {
+		t.Errorf("isBanned() = %t, %v; want true", banned, err)
+	}
+	if near, _ := s.getMessagesWithinRadius(51.5, -0.1, 1, -5); len(near) != 0 {
+		t.Errorf("getMessagesWithinRadius() found a banned sender's message")
+	}
+	if near, _ := s.getPrizeLocksWithinRadius(51.5, -0.1, 1); len(near) != 0 {
+		t.Errorf("getPrizeLocksWithinRadius() found a banned sender's prize")
+	}
+	bans, err := s.getBans()
+	must(t, err)
+	if want := (Ban{Address: sender, Reason: "spam", BannedBy: "0xadmin", BannedAt: 6}); len(bans) != 1 || bans[0] != want {
+		t.Errorf("getBans() = %+v; want %+v", bans, want)
+	}
+	if ok, err := s.deleteBan(sender); err != nil || !ok {
+		t.Errorf("deleteBan() = %t, %v; want true", ok, err)
+	}
+	if ok, _ := s.deleteBan(sender); ok {
+		t.Errorf("deleteBan() twice = true")
+	}
+	if near, _ := s.getPrizeLocksWithinRadius(51.5, -0.1, 1); !reflect.DeepEqual(prizeIDs(near), []string{prize.ID}) {
+		t.Errorf("getPrizeLocksWithinRadius() after unbanning = %v; want the prize", prizeIDs(near))
+	}
+}
+
+func conformZones(t *testing.T, s Store) {
+	square := [][][][2]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}}
+	ids, err := s.insertExclusionZones([]exclusionZone{
+		{Name: "a", Polygons: square, CreatedBy: "0xadmin", CreatedAt: 1},
+		{Name: "b", Polygons: square, CreatedBy: "0xadmin", CreatedAt: 1},
+	})
+	must(t, err)
+	if len(ids) != 2 || ids[0] >= ids[1] {
+		t.Fatalf("insertExclusionZones() = %v; want two ids in order", ids)
+	}
+
+	zones, err := s.getExclusionZones()
+	must(t, err)
+	if len(zones) != 2 || zones[0].ID != ids[0] || zones[1].Name != "b" || !reflect.DeepEqual(zones[0].Polygons, square) {
+		t.Fatalf("getExclusionZones() = %+v; want a and b", zones)
+	}
+
+	if ok, err := s.deleteExclusionZone(ids[0]); err != nil || !ok {
+		t.Errorf("deleteExclusionZone() = %t, %v; want true", ok, err)
+	}
+	if ok, _ := s.deleteExclusionZone(ids[0]); ok {
+		t.Errorf("deleteExclusionZone() twice = true")
+	}
+	if zones, _ := s.getExclusionZones(); len(zones) != 1 || zones[0].ID != ids[1] {
+		t.Errorf("getExclusionZones() after a delete = %+v; want b", zones)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// how often a stream applies the newest location it was sent; anything
+	// sent in between is superseded
+	deltaStreamInterval = time.Second
+	// how far a delta's direction has to swing before it's pushed again
+	deltaStreamBearingStep = 5.0
+
+	deltaStreamPingInterval = time.Second * 30
+	deltaStreamPongWait     = time.Second * 60
+	deltaStreamWriteWait    = time.Second * 10
+	deltaStreamReadLimit    = 1024
+)
+
+// deltaHub tells open streams that something they may be showing has
+// changed, so they recompute rather than wait for the next location.
+type deltaHub struct {
+	mu     sync.Mutex
+	subs   map[chan struct{}]struct{}
+	closed chan struct{}
+}
+
+var deltaUpdates *deltaHub
+
+func newDeltaHub() *deltaHub {
+	return &deltaHub{subs: map[chan struct{}]struct{}{}, closed: make(chan struct{})}
+}
+
+// subscribe returns a channel that receives after every notify, coalescing
+// notifications the subscriber hasn't caught up with yet.
+func (h *deltaHub) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *deltaHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends every stream; the server's shutdown doesn't reach hijacked
+// connections.
+func (h *deltaHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+// DeltaUpdate is what a stream pushes: the deltas that are new or changed
+// since the last update and the ids of those that went out of range, or an
+// error about the last location sent.
+type DeltaUpdate struct {
+	Type     string   `json:"type"`
+	Upserted []Delta  `json:"upserted,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// deltaSet is what a stream's client has been sent, by id.
+type deltaSet map[string]Delta
+
+// update diffs next against what was sent and records what's returned as
+// sent. A delta only counts as changed if it moved proximity bucket, its
+// direction swung by deltaStreamBearingStep or more, or anything else about
+// it is different.
+func (s deltaSet) update(next []Delta) ([]Delta, []string) {
+	var upserted []Delta
+	seen := map[string]bool{}
+	for _, d := range next {
+		seen[d.ID] = true
+		if prev, ok := s[d.ID]; ok && !deltaChanged(prev, d) {
+			continue
+		}
+		s[d.ID] = d
+		upserted = append(upserted, d)
+	}
+
+	var removed []string
+	for id := range s {
+		if !seen[id] {
+			delete(s, id)
+			removed = append(removed, id)
+		}
+	}
+	return upserted, removed
+}
+
+func deltaChanged(prev, next Delta) bool {
+	swing := math.Abs(math.Mod(next.Direction-prev.Direction+540, 360) - 180)
+	if swing >= deltaStreamBearingStep {
+		return true
+	}
+	prev.Direction = next.Direction
+	return !reflect.DeepEqual(prev, next)
+}
+
+// deltaStream serves /delta/stream, a WebSocket the client sends its
+// location over as it moves, the same UserLocation /delta takes. Updates are
+// pushed when a location changes what's in range and whenever the hub says
+// drops or messages changed.
+type deltaStream struct {
+	origins  []string
+	deltas   func(ctx context.Context, userLocation UserLocation, viewer, acceptLanguage string, reveal bool) ([]Delta, error)
+	viewer   func(r *http.Request) string
+	interval time.Duration
+}
+
+// checkOrigin holds browsers to ALLOWED_HOSTS, as the CORS handler does for
+// everything else; clients that send no Origin aren't browsers.
+func (s *deltaStream) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed = strings.TrimSpace(allowed); allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *deltaStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already answered
+		return
+	}
+	defer conn.Close()
+
+	updates, unsubscribe := deltaUpdates.subscribe()
+	defer unsubscribe()
+
+	locations := make(chan UserLocation, 1)
+	done := make(chan struct{})
+	go s.read(conn, locations, done)
+
+	interval := s.interval
+	if interval == 0 {
+		interval = deltaStreamInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	ping := time.NewTicker(deltaStreamPingInterval)
+	defer ping.Stop()
+
+	write := func(update DeltaUpdate) bool {
+		conn.SetWriteDeadline(time.Now().Add(deltaStreamWriteWait))
+		if err := conn.WriteJSON(update); err != nil {
+			return false
+		}
+		return true
+	}
+
+	ctx := r.Context()
+	viewer := ""
+	if s.viewer != nil {
+		viewer = s.viewer(r)
+	}
+	// browsers send Accept-Language with the upgrade
+	acceptLanguage := r.Header.Get("Accept-Language")
+	var sent deltaSet
+	var location *UserLocation
+	reveal := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-deltaUpdates.closed:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(deltaStreamWriteWait))
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(deltaStreamWriteWait)); err != nil {
+				return
+			}
+			continue
+		case <-updates:
+			if location == nil {
+				continue
+			}
+		case <-tick.C:
+			var next UserLocation
+			select {
+			case next = <-locations:
+			default:
+				continue
+			}
+			if err := validateLocation(next); err != nil {
+				if !write(DeltaUpdate{Type: "error", Error: err.Error()}) {
+					return
+				}
+				continue
+			}
+			revealed, err := reportLocation(ctx, next, time.Now())
+			if err != nil {
+				if !write(DeltaUpdate{Type: "error", Error: fmt.Sprintf("Location rejected: %s", err.Error())}) {
+					return
+				}
+				continue
+			}
+			location, reveal = &next, revealed
+		}
+
+		deltas, err := s.deltas(ctx, *location, viewer, acceptLanguage, reveal)
+		if err != nil {
+			Sugar.Error(err)
+			continue
+		}
+
+		// the first update is sent even when empty, so the client knows
+		// where it stands
+		first := sent == nil
+		if first {
+			sent = deltaSet{}
+		}
+		upserted, removed := sent.update(deltas)
+		if !first && len(upserted) == 0 && len(removed) == 0 {
+			continue
+		}
+		if !write(DeltaUpdate{Type: "delta", Upserted: upserted, Removed: removed}) {
+			return
+		}
+	}
+}
+
+// read passes on locations as they arrive, keeping only the newest one, and
+// closes done when the connection goes.
+func (s *deltaStream) read(conn *websocket.Conn, locations chan UserLocation, done chan struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(deltaStreamReadLimit)
+	conn.SetReadDeadline(time.Now().Add(deltaStreamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(deltaStreamPongWait))
+	})
+
+	for {
+		var location UserLocation
+		if err := conn.ReadJSON(&location); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(deltaStreamPongWait))
+		select {
+		case <-locations:
+		default:
+		}
+		locations <- location
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestDeltaSetUpdate(t *testing.T) {
+	sent := deltaSet{}
+	a := Delta{ID: "a", Direction: 10, Proximity: "<1km"}
+	b := Delta{ID: "b", Direction: 170, Proximity: "<5km"}
+
+	if upserted, removed := sent.update([]Delta{a, b}); len(upserted) != 2 || len(removed) != 0 {
+		t.Fatalf("update() of new deltas = %v, %v", upserted, removed)
+	}
+
+	a.Direction = 12
+	b.Direction = -178 // across the wrap, 12 degrees round
+	if upserted, _ := sent.update([]Delta{a, b}); len(upserted) != 1 || upserted[0].ID != "b" {
+		t.Errorf("update() after small and large swings = %v; want only b", upserted)
+	}
+
+	// small swings add up against what was last sent
+	a.Direction = 16
+	if upserted, _ := sent.update([]Delta{a, b}); len(upserted) != 1 || upserted[0].ID != "a" {
+		t.Errorf("update() after swings adding up = %v; want a", upserted)
+	}
+
+	a.Proximity = "<500m"
+	if upserted, removed := sent.update([]Delta{a}); len(upserted) != 1 || len(removed) != 1 || removed[0] != "b" {
+		t.Errorf("update() after a bucket change and b leaving = %v, %v", upserted, removed)
+	}
+}
+
+func TestDeltaStream(t *testing.T) {
+	movement = newMovementTracker(movementConfig{MaxSpeed: 50, MaxJump: 2000, Jitter: 25, MinDwell: time.Hour, DwellRadius: 15, TrackTTL: time.Minute}, newMemoryStore())
+	sessionSecret = testSessionSecret
+	deltaUpdates = newDeltaHub()
+	defer deltaUpdates.close()
+
+	var mu sync.Mutex
+	current := []Delta{{ID: "a", Proximity: "<1km"}}
+	stream := &deltaStream{
+		origins: []string{"https://dropwhere.xyz"},
+		deltas: func(ctx context.Context, userLocation UserLocation, viewer, acceptLanguage string, reveal bool) ([]Delta, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]Delta(nil), current...), nil
+		},
+		interval: time.Millisecond * 10,
+	}
+	r := mux.NewRouter()
+	r.Handle("/delta/stream", stream)
+	srv := httptest.NewServer(sessionMiddleware(r))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/delta/stream"
+
+	if _, _, err := websocket.DefaultDialer.Dial(url, map[string][]string{"Origin": {"https://evil.xyz"}}); err == nil {
+		t.Errorf("Dial() from another origin = nil error")
+	}
+
+	// only signed in callers are tracked, so only they can be caught teleporting
+	token := issueSessionToken(sessionSecret, common.HexToAddress(fixtureSigner), time.Now().Add(time.Hour))
+	conn, _, err := websocket.DefaultDialer.Dial(url, map[string][]string{"Origin": {"https://dropwhere.xyz"}, "Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	next := func() DeltaUpdate {
+		t.Helper()
+		var update DeltaUpdate
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if err := conn.ReadJSON(&update); err != nil {
+			t.Fatal(err)
+		}
+		return update
+	}
+
+	conn.WriteJSON(UserLocation{Latitude: 51.5, Longitude: -0.12})
+	if update := next(); update.Type != "delta" || len(update.Upserted) != 1 || update.Upserted[0].ID != "a" {
+		t.Fatalf("first update = %+v", update)
+	}
+
+	mu.Lock()
+	current = []Delta{{ID: "b", Proximity: "<10m"}}
+	mu.Unlock()
+	deltaUpdates.notify()
+	if update := next(); len(update.Upserted) != 1 || update.Upserted[0].ID != "b" || len(update.Removed) != 1 || update.Removed[0] != "a" {
+		t.Errorf("update after a notify = %+v", update)
+	}
+
+	conn.WriteJSON(UserLocation{Latitude: 40.7, Longitude: -74})
+	if update := next(); update.Type != "error" || !strings.HasPrefix(update.Error, "Location rejected") {
+		t.Errorf("update after teleporting = %+v; want a rejection", update)
+	}
+
+	conn.WriteJSON(UserLocation{Latitude: 91, Longitude: 0})
+	if update := next(); update.Type != "error" {
+		t.Errorf("update after bad coordinates = %+v; want an error", update)
+	}
+}
@@ -0,0 +1,264 @@
+// Package templates holds the catalogue messages are written from. A message
+// is a template index, a category index and a word index, filled in where
+// the template has a placeholder. Catalogues are versioned and never change
+// once released, so a message always renders against the version it was
+// written with.
+//
+// vN.json is version N in English. vN.<lang>.json translates it: the same
+// layout with the same indexes, where anything left out or empty falls back
+// to the English.
+package templates
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Placeholder marks where a template takes its word.
+const Placeholder = "****"
+
+var (
+	ErrUnknownVersion = errors.New("unknown catalogue version")
+	ErrMalformed      = errors.New("message must be a template, category and word index")
+	ErrOutOfRange     = errors.New("index out of range")
+)
+
+// Category is a named list of words.
+type Category struct {
+	Name  string   `json:"name"`
+	Words []string `json:"words"`
+}
+
+// Catalogue is one version of the templates and the words they can take, in
+// Language.
+type Catalogue struct {
+	Version    int        `json:"version"`
+	Language   string     `json:"language"`
+	Templates  []string   `json:"templates"`
+	Categories []Category `json:"categories"`
+}
+
+// version is a catalogue and its translations, English first.
+type version struct {
+	localized []*Catalogue
+	matcher   language.Matcher
+}
+
+//go:embed catalogues/*.json
+var catalogueFiles embed.FS
+
+var catalogues = mustLoad()
+
+func mustLoad() map[int]*version {
+	loaded, err := load(catalogueFiles)
+	if err != nil {
+		panic(err)
+	}
+	return loaded
+}
+
+func load(fsys fs.FS) (map[int]*version, error) {
+	files, err := fs.ReadDir(fsys, "catalogues")
+	if err != nil {
+		return nil, err
+	}
+
+	bases := map[int]*Catalogue{}
+	var translations []*Catalogue
+	for _, f := range files {
+		data, err := fs.ReadFile(fsys, path.Join("catalogues", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var c Catalogue
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		if c.Language == "" {
+			c.Language = "en"
+		}
+		tag, err := language.Parse(c.Language)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		c.Language = tag.String()
+
+		if f.Name() == fmt.Sprintf("v%d.json", c.Version) {
+			if c.Language != "en" {
+				return nil, fmt.Errorf("%s must be in English", f.Name())
+			}
+			if len(c.Templates) == 0 || len(c.Categories) == 0 {
+				return nil, fmt.Errorf("%s is empty", f.Name())
+			}
+			bases[c.Version] = &c
+			continue
+		}
+		if f.Name() != fmt.Sprintf("v%d.%s.json", c.Version, c.Language) || c.Language == "en" {
+			return nil, fmt.Errorf("%s holds version %d in %s", f.Name(), c.Version, c.Language)
+		}
+		translations = append(translations, &c)
+	}
+	if len(bases) == 0 {
+		return nil, errors.New("no catalogues")
+	}
+
+	loaded := map[int]*version{}
+	for v, base := range bases {
+		loaded[v] = &version{localized: []*Catalogue{base}}
+	}
+	sort.Slice(translations, func(i, j int) bool { return translations[i].Language < translations[j].Language })
+	for _, t := range translations {
+		v, ok := loaded[t.Version]
+		if !ok {
+			return nil, fmt.Errorf("%s translates a version that doesn't exist", t.Language)
+		}
+		merged, err := translate(v.localized[0], t)
+		if err != nil {
+			return nil, fmt.Errorf("version %d in %s: %w", t.Version, t.Language, err)
+		}
+		v.localized = append(v.localized, merged)
+	}
+	for _, v := range loaded {
+		tags := make([]language.Tag, len(v.localized))
+		for i, c := range v.localized {
+			tags[i] = language.MustParse(c.Language)
+		}
+		v.matcher = language.NewMatcher(tags)
+	}
+	return loaded, nil
+}
+
+// translate fills in t from base wherever it's missing something, and
+// refuses anything base doesn't have.
+func translate(base, t *Catalogue) (*Catalogue, error) {
+	if len(t.Templates) > len(base.Templates) {
+		return nil, fmt.Errorf("%d templates; English has %d", len(t.Templates), len(base.Templates))
+	}
+	if len(t.Categories) > len(base.Categories) {
+		return nil, fmt.Errorf("%d categories; English has %d", len(t.Categories), len(base.Categories))
+	}
+
+	merged := &Catalogue{Version: base.Version, Language: t.Language}
+	merged.Templates = fallback(base.Templates, t.Templates)
+	for i, template := range merged.Templates {
+		if strings.Contains(template, Placeholder) != strings.Contains(base.Templates[i], Placeholder) {
+			return nil, fmt.Errorf("template %d: placeholder doesn't match English", i)
+		}
+	}
+	for i, category := range base.Categories {
+		var translated Category
+		if i < len(t.Categories) {
+			translated = t.Categories[i]
+		}
+		if len(translated.Words) > len(category.Words) {
+			return nil, fmt.Errorf("category %d: %d words; English has %d", i, len(translated.Words), len(category.Words))
+		}
+		merged.Categories = append(merged.Categories, Category{
+			Name:  fallback([]string{category.Name}, []string{translated.Name})[0],
+			Words: fallback(category.Words, translated.Words),
+		})
+	}
+	return merged, nil
+}
+
+// fallback is translated, with base's entry wherever it's missing or empty.
+func fallback(base, translated []string) []string {
+	merged := make([]string, len(base))
+	for i, s := range base {
+		merged[i] = s
+		if i < len(translated) && translated[i] != "" {
+			merged[i] = translated[i]
+		}
+	}
+	return merged
+}
+
+// Versions lists the catalogue versions, oldest first.
+func Versions() []int {
+	versions := make([]int, 0, len(catalogues))
+	for v := range catalogues {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Latest is the catalogue new messages are written with, in English.
+func Latest() *Catalogue {
+	versions := Versions()
+	return catalogues[versions[len(versions)-1]].localized[0]
+}
+
+// Version returns the catalogue with version v, in English.
+func Version(v int) (*Catalogue, error) {
+	c, ok := catalogues[v]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, v)
+	}
+	return c.localized[0], nil
+}
+
+// Localized returns the catalogue with version v in the language that best
+// suits acceptLanguage, an Accept-Language header, or in English if none
+// do.
+func Localized(v int, acceptLanguage string) (*Catalogue, error) {
+	c, ok := catalogues[v]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, v)
+	}
+	_, i := language.MatchStrings(c.matcher, acceptLanguage)
+	return c.localized[i], nil
+}
+
+// Languages lists the languages version v is available in, English first.
+func Languages(v int) []string {
+	c, ok := catalogues[v]
+	if !ok {
+		return nil
+	}
+	languages := make([]string, len(c.localized))
+	for i, l := range c.localized {
+		languages[i] = l.Language
+	}
+	return languages
+}
+
+// Validate checks text indexes into the catalogue. The category and word
+// have to be valid even for templates without a placeholder, where they're
+// ignored.
+func (c *Catalogue) Validate(text []int16) error {
+	if len(text) != 3 {
+		return ErrMalformed
+	}
+	t, category, word := int(text[0]), int(text[1]), int(text[2])
+	if t < 0 || t >= len(c.Templates) {
+		return fmt.Errorf("template %d: %w", t, ErrOutOfRange)
+	}
+	if category < 0 || category >= len(c.Categories) {
+		return fmt.Errorf("category %d: %w", category, ErrOutOfRange)
+	}
+	if word < 0 || word >= len(c.Categories[category].Words) {
+		return fmt.Errorf("word %d: %w", word, ErrOutOfRange)
+	}
+	return nil
+}
+
+// Render writes out the message text indexes.
+func (c *Catalogue) Render(text []int16) (string, error) {
+	if err := c.Validate(text); err != nil {
+		return "", err
+	}
+	template := c.Templates[text[0]]
+	if !strings.Contains(template, Placeholder) {
+		return template, nil
+	}
+	return strings.Replace(template, Placeholder, c.Categories[text[1]].Words[text[2]], 1), nil
+}
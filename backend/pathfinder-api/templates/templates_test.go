@@ -0,0 +1,121 @@
+package templates
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLatest(t *testing.T) {
+	c := Latest()
+	if c.Version != Versions()[len(Versions())-1] {
+		t.Errorf("Latest() = version %d; want the newest", c.Version)
+	}
+	if _, err := Version(c.Version); err != nil {
+		t.Errorf("Version(%d) = %v", c.Version, err)
+	}
+	if _, err := Version(0); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Version(0) = %v; want ErrUnknownVersion", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Version(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]int16{
+		"Enemy ahead":         {0, 0, 0},
+		"Praise the Bonfire!": {23, 1, 0},
+		"Good luck":           {19, 4, 2}, // no placeholder, the word is ignored
+	}
+	for want, text := range cases {
+		if got, err := c.Render(text); err != nil || got != want {
+			t.Errorf("Render(%v) = %q, %v; want %q", text, got, err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Latest()
+	invalid := map[string][]int16{
+		"empty":           nil,
+		"too long":        {0, 0, 0, 0},
+		"template":        {int16(len(c.Templates)), 0, 0},
+		"negative":        {-1, 0, 0},
+		"category":        {0, int16(len(c.Categories)), 0},
+		"word":            {0, 0, int16(len(c.Categories[0].Words))},
+		"placeholderless": {19, 0, 999},
+	}
+	for name, text := range invalid {
+		if err := c.Validate(text); err == nil {
+			t.Errorf("Validate() with bad %s = nil error", name)
+		}
+	}
+}
+
+func TestLocalized(t *testing.T) {
+	if got := Languages(1); len(got) < 2 || got[0] != "en" {
+		t.Errorf("Languages(1) = %v; want English first", got)
+	}
+	cases := map[string]string{
+		"":                       "en",
+		"es":                     "es",
+		"es-AR,es;q=0.9":         "es",
+		"de-DE,de;q=0.9,es;q=.5": "es",
+		"ja":                     "en",
+		"en-GB,es;q=0.5":         "en",
+	}
+	for acceptLanguage, want := range cases {
+		c, err := Localized(1, acceptLanguage)
+		if err != nil || c.Language != want {
+			t.Errorf("Localized(1, %q) = %v, %v; want %s", acceptLanguage, c, err, want)
+		}
+	}
+	if _, err := Localized(0, "es"); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Localized(0) = %v; want ErrUnknownVersion", err)
+	}
+}
+
+func TestTranslationFallback(t *testing.T) {
+	fsys := fstest.MapFS{
+		"catalogues/v1.json": {Data: []byte(`{"version": 1, "templates": ["**** ahead", "Good luck"],
+			"categories": [{"name": "Objects", "words": ["Key", "Door"]}, {"name": "Actions", "words": ["Run"]}]}`)},
+		"catalogues/v1.fr.json": {Data: []byte(`{"version": 1, "language": "fr", "templates": ["**** devant"],
+			"categories": [{"name": "Objets", "words": ["", "Porte"]}]}`)},
+	}
+	loaded, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := loaded[1].localized[1]
+	cases := map[string][]int16{
+		"Key devant":   {0, 0, 0},
+		"Porte devant": {0, 0, 1},
+		"Run devant":   {0, 1, 0},
+		"Good luck":    {1, 0, 0},
+	}
+	for want, text := range cases {
+		if got, err := fr.Render(text); err != nil || got != want {
+			t.Errorf("Render(%v) = %q, %v; want %q", text, got, err, want)
+		}
+	}
+	if fr.Categories[1].Name != "Actions" {
+		t.Errorf("untranslated category = %q; want Actions", fr.Categories[1].Name)
+	}
+
+	invalid := map[string]string{
+		"extra template":    `{"version": 1, "language": "fr", "templates": ["a", "b", "c"]}`,
+		"extra word":        `{"version": 1, "language": "fr", "categories": [{"words": ["a", "b", "c"]}]}`,
+		"lost placeholder":  `{"version": 1, "language": "fr", "templates": ["devant"]}`,
+		"wrong version":     `{"version": 2, "language": "fr"}`,
+		"mislabelled":       `{"version": 1, "language": "de"}`,
+		"unparsed language": `{"version": 1, "language": "not a language"}`,
+	}
+	for name, data := range invalid {
+		fsys["catalogues/v1.fr.json"] = &fstest.MapFile{Data: []byte(data)}
+		if _, err := load(fsys); err == nil {
+			t.Errorf("load() with %s = nil error", name)
+		}
+	}
+}
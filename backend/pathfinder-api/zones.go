@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxZoneUploadBytes caps a GeoJSON import.
+const maxZoneUploadBytes = 8 << 20
+
+// exclusionZone is an area nothing can be dropped in, such as a school or a
+// motorway. Polygons are a GeoJSON MultiPolygon's coordinates: rings of
+// [longitude, latitude], the first of each polygon its outline and any
+// others holes in it. Polygons can't cross the antimeridian; GeoJSON splits
+// those in two.
+type exclusionZone struct {
+	ID        int64            `json:"id"`
+	Name      string           `json:"name"`
+	Polygons  [][][][2]float64 `json:"polygons"`
+	CreatedBy string           `json:"createdBy,omitempty"`
+	CreatedAt int64            `json:"createdAt,omitempty"`
+
+	minLat, minLon, maxLat, maxLon float64
+}
+
+// bound works out the zone's bounding box, which contains checks first.
+func (z *exclusionZone) bound() {
+	z.minLat, z.minLon, z.maxLat, z.maxLon = 90, 180, -90, -180
+	for _, polygon := range z.Polygons {
+		for _, p := range polygon[0] {
+			lon, lat := p[0], p[1]
+			if lat < z.minLat {
+				z.minLat = lat
+			}
+			if lat > z.maxLat {
+				z.maxLat = lat
+			}
+			if lon < z.minLon {
+				z.minLon = lon
+			}
+			if lon > z.maxLon {
+				z.maxLon = lon
+			}
+		}
+	}
+}
+
+func (z *exclusionZone) contains(lat, lon float64) bool {
+	if lat < z.minLat || lat > z.maxLat || lon < z.minLon || lon > z.maxLon {
+		return false
+	}
+	for _, polygon := range z.Polygons {
+		if !ringContains(polygon[0], lat, lon) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, lat, lon) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains is an even-odd ray cast, treating degrees as flat; zones are
+// small enough for that not to matter.
+func ringContains(ring [][2]float64, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (z *exclusionZone) validate() error {
+	if z.Name == "" {
+		return fmt.Errorf("zone needs a name")
+	}
+	if len(z.Polygons) == 0 {
+		return fmt.Errorf("zone %s has no polygons", z.Name)
+	}
+	for _, polygon := range z.Polygons {
+		if len(polygon) == 0 {
+			return fmt.Errorf("zone %s has an empty polygon", z.Name)
+		}
+		for _, ring := range polygon {
+			if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+				return fmt.Errorf("zone %s has a ring that isn't closed", z.Name)
+			}
+			for _, p := range ring {
+				if !validCoordinates(p[1], p[0]) {
+					return fmt.Errorf("zone %s has invalid coordinates", z.Name)
+				}
+			}
+		}
+	}
+	z.bound()
+	if z.maxLon-z.minLon > 180 {
+		return fmt.Errorf("zone %s crosses the antimeridian", z.Name)
+	}
+	return nil
+}
+
+// geoJSON is as much of a GeoJSON object as zones are read from.
+type geoJSON struct {
+	Type       string                 `json:"type"`
+	Features   []geoJSON              `json:"features"`
+	Geometry   *geoJSON               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	Coords     json.RawMessage        `json:"coordinates"`
+}
+
+// parseZones reads zones from a GeoJSON FeatureCollection, Feature, Polygon
+// or MultiPolygon. Features are named by their "name" property, anything
+// unnamed gets name.
+func parseZones(data []byte, name string) ([]exclusionZone, error) {
+	var doc geoJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var zones []exclusionZone
+	var read func(g geoJSON, name string) error
+	read = func(g geoJSON, name string) error {
+		switch g.Type {
+		case "FeatureCollection":
+			for _, f := range g.Features {
+				if err := read(f, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "Feature":
+			if n, ok := g.Properties["name"].(string); ok && n != "" {
+				name = n
+			}
+			if g.Geometry == nil {
+				return fmt.Errorf("feature %s has no geometry", name)
+			}
+			return read(*g.Geometry, name)
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(g.Coords, &polygon); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			zones = append(zones, exclusionZone{Name: name, Polygons: [][][][2]float64{polygon}})
+			return nil
+		case "MultiPolygon":
+			var polygons [][][][2]float64
+			if err := json.Unmarshal(g.Coords, &polygons); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			zones = append(zones, exclusionZone{Name: name, Polygons: polygons})
+			return nil
+		}
+		return fmt.Errorf("%s: can't make a zone from a %q", name, g.Type)
+	}
+	if err := read(doc, name); err != nil {
+		return nil, err
+	}
+
+	if len(zones) == 0 {
+		return nil, errors.New("no zones")
+	}
+	for i := range zones {
+		if err := zones[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return zones, nil
+}
+
+// zoneIndex finds the zone a point is in. Zones are filed under every grid
+// cell their bounding box touches, or kept aside if that's too many cells,
+// so a lookup only polygon tests the zones near the point.
+type zoneIndex struct {
+	cells map[int64][]*exclusionZone
+	large []*exclusionZone
+}
+
+func newZoneIndex(zones []exclusionZone) *zoneIndex {
+	index := &zoneIndex{cells: map[int64][]*exclusionZone{}}
+	for i := range zones {
+		z := &zones[i]
+		z.bound()
+		firstRow, lastRow := cellRow(z.minLat), cellRow(z.maxLat)
+		firstCol, lastCol := cellColumn(z.minLon), cellColumn(z.maxLon)
+		// a box reaching 180 wraps round to column 0
+		if lastCol < firstCol || (lastRow-firstRow+1)*(lastCol-firstCol+1) > maxSearchCells {
+			index.large = append(index.large, z)
+			continue
+		}
+		for row := firstRow; row <= lastRow; row++ {
+			for col := firstCol; col <= lastCol; col++ {
+				cell := row*cellColumns + col
+				index.cells[cell] = append(index.cells[cell], z)
+			}
+		}
+	}
+	return index
+}
+
+func (ix *zoneIndex) find(lat, lon float64) *exclusionZone {
+	for _, z := range ix.cells[cellFor(lat, lon)] {
+		if z.contains(lat, lon) {
+			return z
+		}
+	}
+	for _, z := range ix.large {
+		if z.contains(lat, lon) {
+			return z
+		}
+	}
+	return nil
+}
+
+var exclusionZones atomic.Pointer[zoneIndex]
+
+// exclusionZoneAt is the zone a point is in, or nil.
+func exclusionZoneAt(lat, lon float64) *exclusionZone {
+	index := exclusionZones.Load()
+	if index == nil {
+		return nil
+	}
+	return index.find(lat, lon)
+}
+
+// checkNotExcluded writes a 422 and returns false if a point is in an
+// exclusion zone.
+func checkNotExcluded(w http.ResponseWriter, lat, lon float64) bool {
+	if zone := exclusionZoneAt(lat, lon); zone != nil {
+		http.Error(w, fmt.Sprintf("Can't drop here, it's in exclusion zone %s", zone.Name), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+func loadExclusionZones(store Store) error {
+	zones, err := store.getExclusionZones()
+	if err != nil {
+		return err
+	}
+	exclusionZones.Store(newZoneIndex(zones))
+	return nil
+}
+
+// zoneRefresher reloads the zones every Interval, picking up changes made
+// through other replicas. The first load is left to the caller so the server
+// doesn't start serving with an empty index.
+type zoneRefresher struct {
+	Interval time.Duration
+	store    Store
+}
+
+func zoneRefresherFromEnv(store Store) zoneRefresher {
+	return zoneRefresher{Interval: envDuration("EXCLUSION_ZONE_REFRESH", time.Minute), store: store}
+}
+
+func (z zoneRefresher) run(ctx context.Context) {
+	ticker := time.NewTicker(z.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := loadExclusionZones(z.store); err != nil {
+			Sugar.Error(err)
+		}
+	}
+}
+
+func (s *server) listZonesHandler(w http.ResponseWriter, r *http.Request) {
+	zones, err := s.store.getExclusionZones()
+	if err != nil {
+		http.Error(w, "Failed to retrieve zones", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if zones == nil {
+		zones = []exclusionZone{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(zones)
+}
+
+// importZonesHandler serves POST /admin/zones, a GeoJSON body with a zone
+// for each polygon or feature. ?name= names anything without a name.
+func (s *server) importZonesHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxZoneUploadBytes))
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	zones, err := parseZones(data, r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid GeoJSON: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	admin, _ := callerAddress(r.Context())
+	now := time.Now().Unix()
+	for i := range zones {
+		zones[i].CreatedBy = normalizeAddress(admin.Hex())
+		zones[i].CreatedAt = now
+	}
+	ids, err := s.store.insertExclusionZones(zones)
+	if err != nil {
+		http.Error(w, "Zone import error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	Sugar.Infof("%s imported %d exclusion zones", admin.Hex(), len(ids))
+	s.zonesChanged()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		IDs []int64 `json:"ids"`
+	}{IDs: ids})
+}
+
+func (s *server) deleteZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid zone id", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.store.deleteExclusionZone(id)
+	if err != nil {
+		http.Error(w, "Zone delete error", http.StatusInternalServerError)
+		Sugar.Error(err)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Zone not found", http.StatusNotFound)
+		return
+	}
+	admin, _ := callerAddress(r.Context())
+	Sugar.Infof("%s deleted exclusion zone %d", admin.Hex(), id)
+	s.zonesChanged()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// zonesChanged reloads the zones here straight away, other replicas catch up
+// on their next refresh.
+func (s *server) zonesChanged() {
+	if err := loadExclusionZones(s.store); err != nil {
+		Sugar.Error(err)
+	}
+	deltaUpdates.notify()
+}
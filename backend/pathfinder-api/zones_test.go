@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a square school with a square courtyard cut out of it, and a motorway
+// drawn as a MultiPolygon
+const testZones = `{
+	"type": "FeatureCollection",
+	"features": [
+		{
+			"type": "Feature",
+			"properties": {"name": "school"},
+			"geometry": {
+				"type": "Polygon",
+				"coordinates": [
+					[[-0.10, 51.50], [-0.09, 51.50], [-0.09, 51.51], [-0.10, 51.51], [-0.10, 51.50]],
+					[[-0.097, 51.503], [-0.093, 51.503], [-0.093, 51.507], [-0.097, 51.507], [-0.097, 51.503]]
+				]
+			}
+		},
+		{
+			"type": "Feature",
+			"properties": {},
+			"geometry": {
+				"type": "MultiPolygon",
+				"coordinates": [
+					[[[2.0, 48.0], [2.1, 48.0], [2.1, 48.001], [2.0, 48.001], [2.0, 48.0]]],
+					[[[2.2, 48.0], [2.3, 48.0], [2.3, 48.001], [2.2, 48.001], [2.2, 48.0]]]
+				]
+			}
+		}
+	]
+}`
+
+func TestParseZones(t *testing.T) {
+	zones, err := parseZones([]byte(testZones), "unnamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 2 || zones[0].Name != "school" || zones[1].Name != "unnamed" {
+		t.Fatalf("parseZones() = %+v; want school and unnamed", zones)
+	}
+
+	invalid := map[string]string{
+		"json":     `{`,
+		"empty":    `{"type": "FeatureCollection", "features": []}`,
+		"point":    `{"type": "Point", "coordinates": [0, 0]}`,
+		"unclosed": `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}`,
+		"range":    `{"type": "Polygon", "coordinates": [[[0, 0], [200, 0], [1, 1], [0, 0]]]}`,
+		"unnamed":  `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`,
+		"wrapping": `{"type": "Polygon", "coordinates": [[[-179, 0], [179, 0], [179, 1], [-179, 0]]]}`,
+	}
+	for name, data := range invalid {
+		zoneName := "zone"
+		if name == "unnamed" {
+			zoneName = ""
+		}
+		if _, err := parseZones([]byte(data), zoneName); err == nil {
+			t.Errorf("parseZones() with bad %s = nil error", name)
+		}
+	}
+}
+
+func TestZoneIndexFind(t *testing.T) {
+	zones, err := parseZones([]byte(testZones), "motorway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a zone too big for the grid is checked on its own
+	big, err := parseZones([]byte(`{"type": "Polygon", "coordinates": [[[10, 10], [20, 10], [20, 20], [10, 20], [10, 10]]]}`), "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := newZoneIndex(append(zones, big...))
+	if len(index.large) != 1 {
+		t.Errorf("newZoneIndex() kept %d zones off the grid; want 1", len(index.large))
+	}
+
+	cases := []struct {
+		lat, lon float64
+		want     string
+	}{
+		{51.501, -0.099, "school"},
+		{51.505, -0.095, ""}, // the courtyard
+		{51.52, -0.095, ""},
+		{48.0005, 2.05, "motorway"},
+		{48.0005, 2.25, "motorway"},
+		{48.0005, 2.15, ""},
+		{15, 15, "big"},
+		{-15, 15, ""},
+	}
+	for _, c := range cases {
+		got := ""
+		if z := index.find(c.lat, c.lon); z != nil {
+			got = z.Name
+		}
+		if got != c.want {
+			t.Errorf("find(%g, %g) = %q; want %q", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestCheckNotExcluded(t *testing.T) {
+	zones, err := parseZones([]byte(testZones), "motorway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclusionZones.Store(newZoneIndex(zones))
+	defer exclusionZones.Store(nil)
+
+	w := httptest.NewRecorder()
+	if checkNotExcluded(w, 51.501, -0.099) || w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("checkNotExcluded() in the school = %d; want 422", w.Code)
+	}
+	if !checkNotExcluded(httptest.NewRecorder(), 51.505, -0.095) {
+		t.Errorf("checkNotExcluded() in the courtyard = false")
+	}
+
+	prizes := []Prize{
+		{ID: "0x01", Type: "eth", Latitude: 51.501, Longitude: -0.099},
+		{ID: "0x02", Type: "eth", Latitude: 51.4995, Longitude: -0.0991},
+	}
+	deltas := filterPrizeDeltas(context.Background(), UserLocation{Latitude: 51.5, Longitude: -0.1}, "", prizes, false)
+	if len(deltas) != 1 || deltas[0].ID != "0x02" {
+		t.Errorf("filterPrizeDeltas() = %+v; want only the prize outside the zone", deltas)
+	}
+}
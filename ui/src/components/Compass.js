@@ -1,8 +1,8 @@
 import React from "react";
-import { useEffect, useState } from "react";
+import { useEffect, useRef, useState } from "react";
 import useGeolocation from "../hooks/useGeolocation";
 import MessageRenderer from "./MessageRenderer";
-import { useAccount } from "wagmi";
+import { useAccount, useSignTypedData } from "wagmi";
 import { writeContract } from "@wagmi/core";
 import { wagmiConfig } from "../WagmiConfig";
 import { dropManagerABI } from "../abi/dropManager";
@@ -10,6 +10,7 @@ import { useAlert } from "react-alert";
 import { config } from "../config";
 import { spiral } from "ldrs";
 import { isIOS } from "react-device-detect";
+import useSignIn from "../hooks/useSignIn";
 
 spiral.register();
 
@@ -18,6 +19,7 @@ const Compass = (props) => {
   const [dir, setDir] = useState("");
   const { position, error } = useGeolocation();
   const [deltas, setDeltas] = useState([]);
+  const signedIn = useSignIn();
   const [veryCloseDeltas, setVeryCloseDeltas] = useState([]);
   const [isClose, setIsClose] = useState(false);
   const [loading, setLoading] = useState(false);
@@ -25,6 +27,7 @@ const Compass = (props) => {
 
   const alert = useAlert();
   const account = useAccount();
+  const { signTypedDataAsync } = useSignTypedData();
 
   useEffect(() => {
     //implimentation yoinked from: https://onlinecompass.app/ <-cheers m8
@@ -129,40 +132,82 @@ const Compass = (props) => {
     }
   }, []);
 
+  // deltas are streamed over a websocket, which is sent our position as it
+  // changes and every few seconds while it doesn't, so the api can see us
+  // stay put
+  const socket = useRef(null);
+  const location = useRef(null);
+
+  // the stream picks up the session when it connects, so it reconnects
+  // once signed in
   useEffect(() => {
-    const fetchDeltas = async () => {
-      if (position.latitude && position.longitude) {
-        //alert.show("making request");
-        try {
-          const response = await fetch(
-            `${config.pathfinderURL}${config.deltaPath}`,
-            {
-              method: "POST",
-              headers: {
-                "Content-Type": "application/json",
-              },
-              body: JSON.stringify({
-                latitude: position.latitude,
-                longitude: position.longitude,
-              }),
-            },
-          );
+    let closed = false;
+    let retryDelay = 1000;
+    let retryTimer;
+
+    const connect = () => {
+      const ws = new WebSocket(
+        `${config.pathfinderURL.replace(/^http/, "ws")}${config.deltaStreamPath}`,
+      );
+      const known = new Map();
+      socket.current = ws;
 
-          const data = await response.json();
-          if (data == null || data == undefined) {
-            setDeltas([]);
-            return;
-          }
-          setDeltas(data);
-        } catch (error) {
-          console.error("Error fetching deltas:", error);
+      ws.onopen = () => {
+        retryDelay = 1000;
+        if (location.current) {
+          ws.send(JSON.stringify(location.current));
+        }
+      };
+      ws.onmessage = (event) => {
+        const update = JSON.parse(event.data);
+        if (update.type === "error") {
+          console.error("Error streaming deltas:", update.error);
+          return;
+        }
+        (update.removed || []).forEach((id) => known.delete(id));
+        (update.upserted || []).forEach((delta) => known.set(delta.id, delta));
+        setDeltas([...known.values()]);
+      };
+      ws.onclose = () => {
+        socket.current = null;
+        if (!closed) {
+          retryTimer = setTimeout(connect, retryDelay);
+          retryDelay = Math.min(retryDelay * 2, 30000);
         }
+      };
+    };
+
+    const sendLocation = () => {
+      const ws = socket.current;
+      if (location.current && ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(location.current));
       }
     };
 
-    const intervalId = setInterval(fetchDeltas, 2500);
+    connect();
+    const intervalId = setInterval(sendLocation, 5000);
+
+    return () => {
+      closed = true;
+      clearTimeout(retryTimer);
+      clearInterval(intervalId);
+      if (socket.current) {
+        socket.current.close();
+      }
+    };
+  }, [signedIn]);
 
-    return () => clearInterval(intervalId);
+  useEffect(() => {
+    if (position.latitude && position.longitude) {
+      location.current = {
+        latitude: position.latitude,
+        longitude: position.longitude,
+      };
+      const ws = socket.current;
+      if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(location.current));
+      }
+    }
   }, [position.latitude, position.longitude]);
 
   const compassStyle = {
@@ -285,10 +330,10 @@ const Compass = (props) => {
     const veryClose = [];
 
     deltas.forEach((delta) => {
-      if (delta.proximity === "<100m") {
+      if (delta.precise) {
         close = true;
       }
-      if (delta.proximity === "<10m") {
+      if (delta.inReach) {
         close = true;
         veryClose.push(delta);
       }
@@ -302,7 +347,7 @@ const Compass = (props) => {
   }, [deltas]);
 
   const mapDeltas = deltas.map((delta) => {
-    if (delta.proximity === "<100m" || delta.proximity === "<10m") {
+    if (delta.precise || delta.inReach) {
       return null;
     } else {
       return delta.type === "message" ? (
@@ -340,6 +385,71 @@ const Compass = (props) => {
     }
   };
 
+  const handleAppraise = async (message, helpful) => {
+    const address = account.address;
+
+    if (address == null || address == undefined) {
+      alert.show("Can't get your address, create a SmartWallet first!", {
+        type: "error",
+      });
+      return;
+    }
+
+    try {
+      const nonceRes = await fetch(
+        `${config.pathfinderURL}${config.messagePath}/nonce?sender=${address}`,
+      );
+      if (!nonceRes.ok) {
+        alert.show("error appraising message", { type: "error" });
+        return;
+      }
+      const nonce = await nonceRes.json();
+
+      const signature = await signTypedDataAsync({
+        domain: { name: "dropwhere", version: "1", chainId: config.chainId },
+        types: {
+          MessageAppraisal: [
+            { name: "id", type: "uint256" },
+            { name: "appraiser", type: "address" },
+            { name: "helpful", type: "bool" },
+            { name: "nonce", type: "bytes32" },
+            { name: "deadline", type: "uint256" },
+          ],
+        },
+        primaryType: "MessageAppraisal",
+        message: {
+          id: BigInt(message.id),
+          appraiser: address,
+          helpful,
+          nonce: nonce.nonce,
+          deadline: BigInt(nonce.deadline),
+        },
+      });
+
+      const res = await fetch(
+        `${config.pathfinderURL}${config.messagePath}/${message.id}/appraisals`,
+        {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({
+            appraiser: address,
+            helpful,
+            nonce: nonce.nonce,
+            deadline: nonce.deadline,
+            signature,
+          }),
+        },
+      );
+      if (!res.ok) {
+        alert.show(await res.text(), { type: "error" });
+        return;
+      }
+      alert.show(helpful ? "appraised: helpful" : "appraised: unhelpful");
+    } catch (error) {
+      console.log(error);
+    }
+  };
+
   const handleUnlockDrop = async (lockId, pw, pwHash, locker) => {
     const userAddress = await account.address;
 
@@ -421,7 +531,12 @@ const Compass = (props) => {
       veryCloseDeltas.length > 0 &&
       veryCloseDeltas[0]["type"] == "message"
     ) {
-      return <MessageRenderer indices={veryCloseDeltas[0]["text"]} />;
+      return (
+        <MessageRenderer
+          indices={veryCloseDeltas[0]["text"]}
+          rendered={veryCloseDeltas[0]["rendered"]}
+        />
+      );
     } else if (
       isClose &&
       veryCloseDeltas.length > 0 &&
@@ -499,6 +614,24 @@ const Compass = (props) => {
           <div className="pulseLoader"></div>
         </div>
       ) : null}
+      {isClose &&
+      veryCloseDeltas.length > 0 &&
+      veryCloseDeltas[0]["type"] == "message" ? (
+        <div
+          style={{
+            display: "flex",
+            gap: 20,
+            fontFamily: '"Tiny5", sans-serif',
+          }}
+        >
+          <span onClick={() => handleAppraise(veryCloseDeltas[0], true)}>
+            👍 {veryCloseDeltas[0]["helpful"] || 0}
+          </span>
+          <span onClick={() => handleAppraise(veryCloseDeltas[0], false)}>
+            👎 {veryCloseDeltas[0]["unhelpful"] || 0}
+          </span>
+        </div>
+      ) : null}
     </div>
   );
 };
@@ -1,10 +1,16 @@
 import React from "react";
 import { useState, useRef, useMemo } from "react";
 import { MapContainer, TileLayer, Marker } from "react-leaflet";
-import { useAccount, useSignMessage } from "wagmi";
+import { useAccount, useSignTypedData } from "wagmi";
 import { readContracts, writeContract } from "@wagmi/core";
 import { wagmiConfig } from "../WagmiConfig";
-import { toHex, encodePacked, keccak256, parseUnits } from "viem";
+import {
+  toHex,
+  encodePacked,
+  keccak256,
+  parseUnits,
+  zeroAddress,
+} from "viem";
 import { useAlert } from "react-alert";
 import { config } from "../config";
 import { dropManagerABI } from "../abi/dropManager";
@@ -44,13 +50,14 @@ const Create = () => {
   const [dataIsFetching, setDataIsFetching] = useState(false);
 
   const markerRef = useRef(null);
-  const { signMessage } = useSignMessage({
+  const { signTypedData } = useSignTypedData({
     mutation: {
       onSuccess: (sig) => {
         postMessage(sig);
       },
     },
   });
+  const { signTypedDataAsync: signPrize } = useSignTypedData();
   const account = useAccount();
   const alert = useAlert();
 
@@ -74,7 +81,7 @@ const Create = () => {
         "Content-Type": "application/json",
       },
       method: "POST",
-      body: JSON.stringify({ message: msg, signature: sig }),
+      body: JSON.stringify({ ...msg, signature: sig }),
     })
       .then(function (res) {
         if (!res.ok) {
@@ -91,6 +98,73 @@ const Create = () => {
       });
   };
 
+  // prizes are signed by the sender with a nonce from the api, which checks
+  // them against the on-chain drop once it exists
+  const storePrize = async (prize) => {
+    const latitude = Math.round(prize.latitude * 1e6);
+    const longitude = Math.round(prize.longitude * 1e6);
+    prize = { ...prize, latitude: latitude / 1e6, longitude: longitude / 1e6 };
+
+    const nonceRes = await fetch(
+      `${config.pathfinderURL}${config.dropPath}/nonce?sender=${prize.sender}`,
+    );
+    if (!nonceRes.ok) {
+      return nonceRes;
+    }
+    const nonce = await nonceRes.json();
+
+    const signature = await signPrize({
+      domain: { name: "dropwhere", version: "1", chainId: config.chainId },
+      types: {
+        Prize: [
+          { name: "id", type: "bytes32" },
+          { name: "sender", type: "address" },
+          { name: "latitude", type: "int32" },
+          { name: "longitude", type: "int32" },
+          { name: "hashedPassword", type: "bytes32" },
+          { name: "prizeType", type: "string" },
+          { name: "contractAddress", type: "address" },
+          { name: "name", type: "string" },
+          { name: "symbol", type: "string" },
+          { name: "amount", type: "uint256" },
+          { name: "expires", type: "uint256" },
+          { name: "nonce", type: "bytes32" },
+          { name: "deadline", type: "uint256" },
+        ],
+      },
+      primaryType: "Prize",
+      message: {
+        id: prize.id,
+        sender: prize.sender,
+        latitude,
+        longitude,
+        hashedPassword: prize.hashedPassword,
+        prizeType: prize.type,
+        contractAddress:
+          prize.type == "eth" ? zeroAddress : prize.contractAddress,
+        name: prize.name,
+        symbol: prize.symbol,
+        amount: BigInt(prize.amount),
+        expires: BigInt(prize.expires),
+        nonce: nonce.nonce,
+        deadline: BigInt(nonce.deadline),
+      },
+    });
+
+    return fetch(`${config.pathfinderURL}${config.dropPath}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({
+        prize,
+        nonce: nonce.nonce,
+        deadline: nonce.deadline,
+        signature,
+      }),
+    });
+  };
+
   const handleTab = () => {
     if (tab == "message") {
       return (
@@ -192,14 +266,61 @@ const Create = () => {
       return;
     }
 
+    // coordinates are signed as microdegrees, snap them so the api agrees
+    const latitude = Math.round(position.lat * 1e6);
+    const longitude = Math.round(position.lng * 1e6);
+
     message["sender"] = address;
     message["text"] = messageIndices;
-    message["longitude"] = position.lng;
-    message["latitude"] = position.lat;
+    message["templateVersion"] = config.templateVersion;
+    message["longitude"] = longitude / 1e6;
+    message["latitude"] = latitude / 1e6;
+    message["ttl"] = config.messageTTL;
+
+    let nonce;
+    try {
+      const nonceRes = await fetch(
+        `${config.pathfinderURL}${config.messagePath}/nonce?sender=${address}`,
+      );
+      if (!nonceRes.ok) {
+        alert.show("error dropping message", { type: "error" });
+        console.log(nonceRes);
+        return;
+      }
+      nonce = await nonceRes.json();
+    } catch (err) {
+      console.log(err);
+      alert.show("server error", { type: "error" });
+      return;
+    }
 
-    setMsg(message);
-    let msgToSign = `${address}${message["latitude"].toFixed(3)}${message["longitude"].toFixed(3)}`; // PoC implimentation, will replace with unique UUID or Nonce
-    signMessage({ message: msgToSign });
+    setMsg({ message, nonce: nonce.nonce, deadline: nonce.deadline });
+    signTypedData({
+      domain: { name: "dropwhere", version: "1", chainId: config.chainId },
+      types: {
+        Message: [
+          { name: "sender", type: "address" },
+          { name: "text", type: "int16[]" },
+          { name: "templateVersion", type: "uint256" },
+          { name: "latitude", type: "int32" },
+          { name: "longitude", type: "int32" },
+          { name: "ttl", type: "uint256" },
+          { name: "nonce", type: "bytes32" },
+          { name: "deadline", type: "uint256" },
+        ],
+      },
+      primaryType: "Message",
+      message: {
+        sender: address,
+        text: messageIndices,
+        templateVersion: BigInt(config.templateVersion),
+        latitude,
+        longitude,
+        ttl: BigInt(config.messageTTL),
+        nonce: nonce.nonce,
+        deadline: BigInt(nonce.deadline),
+      },
+    });
   };
 
   const handleTokenDrop = async () => {
@@ -293,26 +414,19 @@ const Create = () => {
         encodePacked(["bytes32", "address"], [pw, userAddress]),
       );
 
-      const apiRes = await fetch(`${config.pathfinderURL}${config.dropPath}`, {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({
-          id: dropID,
-          sender: userAddress,
-          latitude: position.lat,
-          longitude: position.lng,
-          password: pw,
-          hashedPassword: hashedPw,
-          type: dropType,
-          contractAddress: dropDetails.contractAddress,
-          name: dropDetails.contractName,
-          symbol: dropDetails.contractSymbol,
-          amount: dropDetails.amount,
-          expires: fDate,
-          active: false,
-        }),
+      const apiRes = await storePrize({
+        id: dropID,
+        sender: userAddress,
+        latitude: position.lat,
+        longitude: position.lng,
+        password: pw,
+        hashedPassword: hashedPw,
+        type: dropType,
+        contractAddress: dropDetails.contractAddress,
+        name: dropDetails.contractName,
+        symbol: dropDetails.contractSymbol,
+        amount: fAmount.toString(),
+        expires: fDate,
       });
 
       if (apiRes.status != 201) {
@@ -424,26 +538,19 @@ const Create = () => {
         encodePacked(["bytes32", "address"], [pw, userAddress]),
       );
 
-      const apiRes = await fetch(`${config.pathfinderURL}${config.dropPath}`, {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({
-          id: dropID,
-          sender: userAddress,
-          latitude: position.lat,
-          longitude: position.lng,
-          password: pw,
-          hashedPassword: hashedPw,
-          type: dropType,
-          contractAddress: dropDetails.contractAddress,
-          name: dropDetails.contractName,
-          symbol: dropDetails.contractSymbol,
-          amount: dropDetails.amount,
-          expires: fDate,
-          active: false,
-        }),
+      const apiRes = await storePrize({
+        id: dropID,
+        sender: userAddress,
+        latitude: position.lat,
+        longitude: position.lng,
+        password: pw,
+        hashedPassword: hashedPw,
+        type: dropType,
+        contractAddress: dropDetails.contractAddress,
+        name: dropDetails.contractName,
+        symbol: dropDetails.contractSymbol,
+        amount: dropDetails.amount,
+        expires: fDate,
       });
 
       if (apiRes.status != 201) {
@@ -528,26 +635,19 @@ const Create = () => {
         encodePacked(["bytes32", "address"], [pw, userAddress]),
       );
 
-      const apiRes = await fetch(`${config.pathfinderURL}${config.dropPath}`, {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({
-          id: dropID,
-          sender: userAddress,
-          latitude: position.lat,
-          longitude: position.lng,
-          password: pw,
-          hashedPassword: hashedPw,
-          type: dropType,
-          contractAddress: "ETH",
-          name: "Ethereum",
-          symbol: "ETH",
-          amount: fAmount.toString(),
-          expires: fDate,
-          active: false,
-        }),
+      const apiRes = await storePrize({
+        id: dropID,
+        sender: userAddress,
+        latitude: position.lat,
+        longitude: position.lng,
+        password: pw,
+        hashedPassword: hashedPw,
+        type: dropType,
+        contractAddress: "ETH",
+        name: "Ethereum",
+        symbol: "ETH",
+        amount: fAmount.toString(),
+        expires: fDate,
       });
 
       if (apiRes.status != 201) {
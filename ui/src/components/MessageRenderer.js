@@ -1,16 +1,20 @@
 import React from "react";
 import { messageTemplate } from "../messageTemplate";
 
-const MessageRenderer = ({ indices }) => {
+// the api renders messages from the catalogue they were written with, the
+// local copy is only used when it couldn't
+const renderLocally = (indices) => {
   const [templateIndex, categoryIndex, wordIndex] = indices;
 
   const template = messageTemplate.templates[templateIndex];
   const category = messageTemplate.categories[categoryIndex];
   const word = category ? category[wordIndex] : "";
 
-  const message = template.includes("****")
-    ? template.replace("****", word)
-    : template;
+  return template.includes("****") ? template.replace("****", word) : template;
+};
+
+const MessageRenderer = ({ indices, rendered }) => {
+  const message = rendered || renderLocally(indices);
 
   return (
     <div
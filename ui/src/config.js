@@ -1,8 +1,13 @@
 export const config = {
   pathfinderURL: "https://api.dropwhere.xyz",
   messagePath: "/messages",
+  // how long dropped messages last, in seconds
+  messageTTL: 86400,
+  // the api's template catalogue version messageTemplate.js matches
+  templateVersion: 1,
   dropPath: "/prizes",
   deltaPath: "/delta",
+  deltaStreamPath: "/delta/stream",
   proofURL: "https://proof.dropwhere.xyz",
   proofPath: "/generate-proof",
   dropManagerAddress: "0x5393d1E58e17f7cF943826342BA05b5AD7Bd35a3",
@@ -0,0 +1,77 @@
+// src/hooks/useSignIn.js
+import { useEffect, useState } from "react";
+import { useAccount, useSignMessage } from "wagmi";
+import { SiweMessage } from "siwe";
+import { config } from "../config";
+
+// useSignIn signs the connected wallet in to the api with Sign-In with
+// Ethereum, leaving a session cookie behind. The api only reveals passwords
+// to signed in wallets it has seen stay put.
+const useSignIn = () => {
+  const { address } = useAccount();
+  const { signMessageAsync } = useSignMessage();
+  const [signedIn, setSignedIn] = useState(false);
+
+  useEffect(() => {
+    let cancelled = false;
+    setSignedIn(false);
+    if (!address) {
+      return;
+    }
+
+    const signIn = async () => {
+      const current = await fetch(`${config.pathfinderURL}/auth/session`, {
+        credentials: "include",
+      });
+      if (current.ok) {
+        const session = await current.json();
+        if (session.address === address.toLowerCase()) {
+          return true;
+        }
+      }
+
+      const nonceRes = await fetch(`${config.pathfinderURL}/auth/nonce`, {
+        credentials: "include",
+      });
+      if (!nonceRes.ok) {
+        return false;
+      }
+      const { nonce } = await nonceRes.json();
+
+      const message = new SiweMessage({
+        domain: window.location.host,
+        address,
+        statement: "Sign in to dropwhere",
+        uri: window.location.origin,
+        version: "1",
+        chainId: config.chainId,
+        nonce,
+      }).prepareMessage();
+      const signature = await signMessageAsync({ message });
+
+      const res = await fetch(`${config.pathfinderURL}/auth/login`, {
+        method: "POST",
+        credentials: "include",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ message, signature }),
+      });
+      return res.ok;
+    };
+
+    signIn()
+      .then((ok) => {
+        if (!cancelled) {
+          setSignedIn(ok);
+        }
+      })
+      .catch((error) => console.error("Error signing in:", error));
+
+    return () => {
+      cancelled = true;
+    };
+  }, [address, signMessageAsync]);
+
+  return signedIn;
+};
+
+export default useSignIn;